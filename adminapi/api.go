@@ -0,0 +1,190 @@
+// Package adminapi implements admin API endpoints for moderators.
+//
+// Like simpleapi, it doesn't do authentication by itself, wrap it with
+// [auth.Middleware] or limit access to it in some other way.
+package adminapi
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/Jille/convreq"
+	"github.com/Jille/convreq/respond"
+
+	"bsky.watch/labeler/auth"
+	"bsky.watch/labeler/server"
+)
+
+const (
+	defaultPageSize = 100
+	maxPageSize     = 1000
+)
+
+type Handler struct {
+	server *server.Server
+	mux    *http.ServeMux
+}
+
+// New returns HTTP handler to serve requests.
+//
+// Endpoints:
+//
+//   - GET /api/subjects?label=...&cursor=...&limit=... - lists subjects that currently
+//     have the label.
+//   - GET /api/history?uri=... - returns all label changes of the subject.
+//   - POST /api/revert - writes labels that undo the changes made in a range of
+//     sequence numbers, optionally only those with a particular label value or
+//     made by a particular actor. Accepts a JSON object with "from", "to", "val",
+//     "actor" and "dry_run" fields.
+//   - GET /api/reports?cursor=...&limit=... - lists open reports, grouped by subject.
+//   - GET /api/report?id=... - returns a single report.
+//   - POST /api/reports/assign - assigns open reports to a moderator. Accepts a JSON
+//     object with "ids" and "assignee" (defaults to the caller).
+//   - POST /api/reports/resolve - resolves open reports, optionally applying and
+//     negating labels on their subject. Accepts a JSON object with "ids", "comment",
+//     "apply" and "negate" fields. Sequence numbers of written labels are stored
+//     with the reports.
+//   - GET /api/appeals?cursor=...&limit=... - lists open appeals.
+//   - GET /api/appeal?id=... - returns a single appeal.
+//   - POST /api/appeals/grant - negates the labels the appeal is about and closes it.
+//     Accepts a JSON object with "id" and "comment".
+//   - POST /api/appeals/deny - closes the appeal without changing any labels.
+//   - GET /api/approvals?cursor=...&limit=... - lists pending approval requests.
+//   - GET /api/approval?id=... - returns a single approval request.
+//   - POST /api/approvals/approve - writes the label from the approval request.
+//     Must be done by a different moderator than the one who made the request.
+//     Accepts a JSON object with "id" and "comment".
+//   - POST /api/approvals/reject - closes the approval request without writing anything.
+//   - GET /api/delayed?cursor=...&limit=... - lists labels waiting to be published.
+//     With "id" parameter returns a single delayed label in any status.
+//   - POST /api/delayed/cancel - cancels publication of a delayed label.
+//     Accepts a JSON object with "id".
+//   - GET /api/scheduled?cursor=...&limit=... - lists labels scheduled to be written
+//     later. With "id" parameter returns a single scheduled label in any status.
+//   - POST /api/scheduled - schedules applying or negating a label. Accepts a JSON
+//     object with "uri", "cid", "val", "neg", "exp" and "at" (RFC 3339 timestamp) fields.
+//   - POST /api/scheduled/cancel - cancels a scheduled label. Accepts a JSON object with "id".
+func New(server *server.Server) *Handler {
+	h := &Handler{server: server, mux: http.NewServeMux()}
+	h.mux.Handle("GET /api/subjects", convreq.Wrap(h.subjects))
+	h.mux.Handle("GET /api/history", convreq.Wrap(h.history))
+	h.mux.Handle("POST /api/revert", convreq.Wrap(h.revert))
+	h.mux.Handle("GET /api/reports", convreq.Wrap(h.reports))
+	h.mux.Handle("GET /api/report", convreq.Wrap(h.report))
+	h.mux.Handle("POST /api/reports/assign", convreq.Wrap(h.assign))
+	h.mux.Handle("POST /api/reports/resolve", convreq.Wrap(h.resolve))
+	h.mux.Handle("GET /api/appeals", convreq.Wrap(h.appeals))
+	h.mux.Handle("GET /api/appeal", convreq.Wrap(h.appeal))
+	h.mux.Handle("POST /api/appeals/grant", convreq.Wrap(h.grantAppeal))
+	h.mux.Handle("POST /api/appeals/deny", convreq.Wrap(h.denyAppeal))
+	h.mux.Handle("GET /api/approvals", convreq.Wrap(h.approvals))
+	h.mux.Handle("GET /api/approval", convreq.Wrap(h.approval))
+	h.mux.Handle("POST /api/approvals/approve", convreq.Wrap(h.approve))
+	h.mux.Handle("POST /api/approvals/reject", convreq.Wrap(h.reject))
+	h.mux.Handle("GET /api/delayed", convreq.Wrap(h.delayed))
+	h.mux.Handle("POST /api/delayed/cancel", convreq.Wrap(h.cancelDelayed))
+	h.mux.Handle("GET /api/scheduled", convreq.Wrap(h.scheduled))
+	h.mux.Handle("POST /api/scheduled", convreq.Wrap(h.schedule))
+	h.mux.Handle("POST /api/scheduled/cancel", convreq.Wrap(h.cancelScheduled))
+	return h
+}
+
+func (h *Handler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	h.mux.ServeHTTP(w, req)
+}
+
+// LabelView is a JSON representation of a label entry.
+type LabelView struct {
+	Seq int64  `json:"seq"`
+	Cts string `json:"cts"`
+	Src string `json:"src"`
+	Uri string `json:"uri"`
+	Cid string `json:"cid,omitempty"`
+	Val string `json:"val"`
+	Neg bool   `json:"neg,omitempty"`
+	Exp string `json:"exp,omitempty"`
+	// Actor is who has written the entry, if known.
+	Actor string `json:"actor,omitempty"`
+}
+
+func labelView(e server.Entry) LabelView {
+	return LabelView{
+		Seq: e.Seq,
+		Cts: e.Cts,
+		Src: e.Src,
+		Uri: e.Uri,
+		Cid: e.Cid,
+		Val: e.Val,
+		Neg: e.Neg,
+		Exp: e.Exp,
+
+		Actor: e.Actor,
+	}
+}
+
+type subjectsRequestGet struct {
+	Label  string `schema:"label"`
+	Cursor string `schema:"cursor"`
+	Limit  int    `schema:"limit"`
+}
+
+func (h *Handler) subjects(ctx context.Context, get subjectsRequestGet) convreq.HttpResponse {
+	if get.Label == "" {
+		return respond.BadRequest("missing `label` parameter")
+	}
+	if err := auth.CheckLabel(ctx, get.Label); err != nil {
+		return respond.Forbidden(err.Error())
+	}
+	if get.Limit <= 0 {
+		get.Limit = defaultPageSize
+	}
+	get.Limit = min(get.Limit, maxPageSize)
+	var after int64
+	if get.Cursor != "" {
+		n, err := strconv.ParseInt(get.Cursor, 10, 64)
+		if err != nil {
+			return respond.BadRequest("invalid cursor")
+		}
+		after = n
+	}
+
+	entries, err := h.server.SubjectsWithLabel(ctx, get.Label, after, get.Limit)
+	if err != nil {
+		return respond.InternalServerError(err.Error())
+	}
+
+	r := map[string]any{}
+	subjects := []LabelView{}
+	for _, e := range entries {
+		subjects = append(subjects, labelView(e))
+	}
+	r["subjects"] = subjects
+	if len(entries) == get.Limit {
+		r["cursor"] = fmt.Sprint(entries[len(entries)-1].Seq)
+	}
+	return respond.JSON(r)
+}
+
+type historyRequestGet struct {
+	Uri string `schema:"uri"`
+}
+
+func (h *Handler) history(ctx context.Context, get historyRequestGet) convreq.HttpResponse {
+	if !strings.HasPrefix(get.Uri, "did:") && !strings.HasPrefix(get.Uri, "at://") {
+		return respond.BadRequest("`uri` must be a DID or an AT URI")
+	}
+
+	entries, err := h.server.SubjectHistory(ctx, get.Uri)
+	if err != nil {
+		return respond.InternalServerError(err.Error())
+	}
+
+	r := []LabelView{}
+	for _, e := range entries {
+		r = append(r, labelView(e))
+	}
+	return respond.JSON(map[string]any{"entries": r})
+}
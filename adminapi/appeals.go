@@ -0,0 +1,185 @@
+package adminapi
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/Jille/convreq"
+	"github.com/Jille/convreq/respond"
+
+	comatproto "github.com/bluesky-social/indigo/api/atproto"
+
+	"bsky.watch/labeler/auth"
+	"bsky.watch/labeler/server"
+)
+
+// AppealView is a JSON representation of an appeal.
+type AppealView struct {
+	ID         int64             `json:"id"`
+	CreatedAt  string            `json:"created_at"`
+	ReportedBy string            `json:"reported_by"`
+	SubjectUri string            `json:"subject_uri"`
+	SubjectCid string            `json:"subject_cid,omitempty"`
+	Reason     string            `json:"reason,omitempty"`
+	Status     string            `json:"status"`
+	ResolvedBy string            `json:"resolved_by,omitempty"`
+	ResolvedAt string            `json:"resolved_at,omitempty"`
+	Comment    string            `json:"comment,omitempty"`
+	Labels     []AppealLabelView `json:"labels"`
+}
+
+type AppealLabelView struct {
+	Seq         int64  `json:"seq"`
+	Val         string `json:"val"`
+	NegationSeq int64  `json:"negation_seq,omitempty"`
+}
+
+func appealView(a server.Appeal) AppealView {
+	v := AppealView{
+		ID:         a.ID,
+		CreatedAt:  a.CreatedAt.UTC().Format(time.RFC3339),
+		ReportedBy: a.ReportedBy,
+		SubjectUri: a.SubjectUri,
+		SubjectCid: a.SubjectCid,
+		Reason:     a.Reason,
+		Status:     a.Status,
+		ResolvedBy: a.ResolvedBy,
+		Comment:    a.Comment,
+		Labels:     []AppealLabelView{},
+	}
+	if a.ResolvedAt != nil {
+		v.ResolvedAt = a.ResolvedAt.UTC().Format(time.RFC3339)
+	}
+	for _, l := range a.Labels {
+		v.Labels = append(v.Labels, AppealLabelView{Seq: l.Seq, Val: l.Val, NegationSeq: l.NegationSeq})
+	}
+	return v
+}
+
+type appealsRequestGet struct {
+	Cursor string `schema:"cursor"`
+	Limit  int    `schema:"limit"`
+}
+
+func (h *Handler) appeals(ctx context.Context, get appealsRequestGet) convreq.HttpResponse {
+	if get.Limit <= 0 {
+		get.Limit = defaultPageSize
+	}
+	get.Limit = min(get.Limit, maxPageSize)
+	var after int64
+	if get.Cursor != "" {
+		n, err := strconv.ParseInt(get.Cursor, 10, 64)
+		if err != nil {
+			return respond.BadRequest("invalid cursor")
+		}
+		after = n
+	}
+
+	appeals, err := h.server.OpenAppeals(ctx, after, get.Limit)
+	if err != nil {
+		return respond.InternalServerError(err.Error())
+	}
+	views := []AppealView{}
+	for _, a := range appeals {
+		views = append(views, appealView(a))
+	}
+	r := map[string]any{"appeals": views}
+	if len(appeals) == get.Limit {
+		r["cursor"] = fmt.Sprint(appeals[len(appeals)-1].ID)
+	}
+	return respond.JSON(r)
+}
+
+type appealRequestGet struct {
+	ID int64 `schema:"id"`
+}
+
+func (h *Handler) appeal(ctx context.Context, get appealRequestGet) convreq.HttpResponse {
+	a, err := h.server.GetAppeal(ctx, get.ID)
+	if err != nil {
+		return respond.NotFound(err.Error())
+	}
+	return respond.JSON(appealView(*a))
+}
+
+type appealActionJSON struct {
+	ID      int64  `json:"id"`
+	Comment string `json:"comment"`
+}
+
+// checkAppeal returns the labels affected by the appeal, or an error response
+// if the principal is not allowed to use any of them.
+func (h *Handler) checkAppeal(ctx context.Context, id int64) ([]comatproto.LabelDefs_Label, convreq.HttpResponse) {
+	appeal, err := h.server.GetAppeal(ctx, id)
+	if err != nil {
+		return nil, respond.NotFound(err.Error())
+	}
+	labels, err := h.server.AppealedLabels(ctx, appeal)
+	if err != nil {
+		return nil, respond.InternalServerError(err.Error())
+	}
+	for _, l := range labels {
+		if err := auth.CheckLabel(ctx, l.Val); err != nil {
+			return nil, respond.Forbidden(err.Error())
+		}
+	}
+	return labels, nil
+}
+
+func (h *Handler) grantAppeal(ctx context.Context, req appealActionJSON) convreq.HttpResponse {
+	labels, resp := h.checkAppeal(ctx, req.ID)
+	if resp != nil {
+		return resp
+	}
+
+	results, err := h.server.GrantAppeal(ctx, req.ID, auth.Name(ctx), req.Comment, server.WithActor(auth.Name(ctx)), server.RequireApproval())
+	if err != nil {
+		if errors.Is(err, server.ErrAppealNotOpen) || errors.Is(err, server.ErrApprovalRequired) || errors.Is(err, server.ErrAppealedLabelRejected) {
+			return respond.BadRequest(err.Error())
+		}
+		return respond.InternalServerError(err.Error())
+	}
+	appeal, err := h.server.GetAppeal(ctx, req.ID)
+	if err != nil {
+		return respond.InternalServerError(err.Error())
+	}
+
+	items := []labelChange{}
+	for i, l := range labels {
+		if i >= len(results) {
+			break
+		}
+		v := labelChange{Uri: l.Uri, Val: l.Val, Neg: true}
+		if l.Cid != nil {
+			v.Cid = *l.Cid
+		}
+		switch {
+		case results[i].Err != nil:
+			v.Status = "error"
+			v.Error = results[i].Err.Error()
+		case results[i].Changed:
+			v.Status = "created"
+			v.Seq = results[i].Seq
+		default:
+			v.Status = "noop"
+		}
+		items = append(items, v)
+	}
+	return respond.JSON(map[string]any{"id": req.ID, "status": appeal.Status, "labels": items})
+}
+
+func (h *Handler) denyAppeal(ctx context.Context, req appealActionJSON) convreq.HttpResponse {
+	if _, resp := h.checkAppeal(ctx, req.ID); resp != nil {
+		return resp
+	}
+	if err := h.server.DenyAppeal(ctx, req.ID, auth.Name(ctx), req.Comment); err != nil {
+		if errors.Is(err, server.ErrAppealNotOpen) {
+			return respond.BadRequest(err.Error())
+		}
+		return respond.InternalServerError(err.Error())
+	}
+	return respond.JSON(map[string]any{"id": req.ID, "status": server.AppealDenied})
+}
@@ -0,0 +1,150 @@
+package adminapi
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/Jille/convreq"
+	"github.com/Jille/convreq/respond"
+
+	"bsky.watch/labeler/auth"
+	"bsky.watch/labeler/server"
+)
+
+// ApprovalView is a JSON representation of an approval request.
+type ApprovalView struct {
+	ID          int64  `json:"id"`
+	CreatedAt   string `json:"created_at"`
+	RequestedBy string `json:"requested_by"`
+	Uri         string `json:"uri"`
+	Cid         string `json:"cid,omitempty"`
+	Val         string `json:"val"`
+	Neg         bool   `json:"neg,omitempty"`
+	Exp         string `json:"exp,omitempty"`
+	Status      string `json:"status"`
+	ResolvedBy  string `json:"resolved_by,omitempty"`
+	ResolvedAt  string `json:"resolved_at,omitempty"`
+	Comment     string `json:"comment,omitempty"`
+	Seq         int64  `json:"seq,omitempty"`
+}
+
+func approvalView(r server.ApprovalRequest) ApprovalView {
+	v := ApprovalView{
+		ID:          r.ID,
+		CreatedAt:   r.CreatedAt.UTC().Format(time.RFC3339),
+		RequestedBy: r.RequestedBy,
+		Uri:         r.Uri,
+		Cid:         r.Cid,
+		Val:         r.Val,
+		Neg:         r.Neg,
+		Exp:         r.Exp,
+		Status:      r.Status,
+		ResolvedBy:  r.ResolvedBy,
+		Comment:     r.Comment,
+		Seq:         r.Seq,
+	}
+	if r.ResolvedAt != nil {
+		v.ResolvedAt = r.ResolvedAt.UTC().Format(time.RFC3339)
+	}
+	return v
+}
+
+type approvalsRequestGet struct {
+	Cursor string `schema:"cursor"`
+	Limit  int    `schema:"limit"`
+}
+
+func (h *Handler) approvals(ctx context.Context, get approvalsRequestGet) convreq.HttpResponse {
+	if get.Limit <= 0 {
+		get.Limit = defaultPageSize
+	}
+	get.Limit = min(get.Limit, maxPageSize)
+	var after int64
+	if get.Cursor != "" {
+		n, err := strconv.ParseInt(get.Cursor, 10, 64)
+		if err != nil {
+			return respond.BadRequest("invalid cursor")
+		}
+		after = n
+	}
+
+	requests, err := h.server.PendingApprovalRequests(ctx, after, get.Limit)
+	if err != nil {
+		return respond.InternalServerError(err.Error())
+	}
+	views := []ApprovalView{}
+	for _, r := range requests {
+		views = append(views, approvalView(r))
+	}
+	r := map[string]any{"approvals": views}
+	if len(requests) == get.Limit {
+		r["cursor"] = fmt.Sprint(requests[len(requests)-1].ID)
+	}
+	return respond.JSON(r)
+}
+
+type approvalRequestGet struct {
+	ID int64 `schema:"id"`
+}
+
+func (h *Handler) approval(ctx context.Context, get approvalRequestGet) convreq.HttpResponse {
+	r, err := h.server.GetApprovalRequest(ctx, get.ID)
+	if err != nil {
+		return respond.NotFound(err.Error())
+	}
+	return respond.JSON(approvalView(*r))
+}
+
+type approvalActionJSON struct {
+	ID      int64  `json:"id"`
+	Comment string `json:"comment"`
+}
+
+func (h *Handler) approve(ctx context.Context, req approvalActionJSON) convreq.HttpResponse {
+	r, err := h.server.GetApprovalRequest(ctx, req.ID)
+	if err != nil {
+		return respond.NotFound(err.Error())
+	}
+	if err := auth.CheckLabel(ctx, r.Val); err != nil {
+		return respond.Forbidden(err.Error())
+	}
+
+	result, err := h.server.ApproveRequest(ctx, req.ID, auth.Name(ctx), req.Comment)
+	switch {
+	case errors.Is(err, server.ErrSelfApproval):
+		return respond.Forbidden(err.Error())
+	case errors.Is(err, server.ErrApprovalNotPending):
+		return respond.BadRequest(err.Error())
+	case err != nil:
+		return respond.InternalServerError(err.Error())
+	case result.Err != nil:
+		return respond.BadRequest(result.Err.Error())
+	}
+
+	v := labelChange{Uri: r.Uri, Cid: r.Cid, Val: r.Val, Neg: r.Neg, Exp: r.Exp, Status: "noop"}
+	if result.Changed {
+		v.Status = "created"
+		v.Seq = result.Seq
+	}
+	return respond.JSON(map[string]any{"id": req.ID, "status": server.ApprovalApproved, "label": v})
+}
+
+func (h *Handler) reject(ctx context.Context, req approvalActionJSON) convreq.HttpResponse {
+	r, err := h.server.GetApprovalRequest(ctx, req.ID)
+	if err != nil {
+		return respond.NotFound(err.Error())
+	}
+	if err := auth.CheckLabel(ctx, r.Val); err != nil {
+		return respond.Forbidden(err.Error())
+	}
+	if err := h.server.RejectRequest(ctx, req.ID, auth.Name(ctx), req.Comment); err != nil {
+		if errors.Is(err, server.ErrApprovalNotPending) {
+			return respond.BadRequest(err.Error())
+		}
+		return respond.InternalServerError(err.Error())
+	}
+	return respond.JSON(map[string]any{"id": req.ID, "status": server.ApprovalRejected})
+}
@@ -0,0 +1,117 @@
+package adminapi
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/Jille/convreq"
+	"github.com/Jille/convreq/respond"
+
+	"bsky.watch/labeler/auth"
+	"bsky.watch/labeler/server"
+)
+
+// DelayedView is a JSON representation of a label waiting to be published.
+type DelayedView struct {
+	ID         int64  `json:"id"`
+	CreatedAt  string `json:"created_at"`
+	PublishAt  string `json:"publish_at"`
+	Actor      string `json:"actor,omitempty"`
+	Uri        string `json:"uri"`
+	Cid        string `json:"cid,omitempty"`
+	Val        string `json:"val"`
+	Exp        string `json:"exp,omitempty"`
+	Status     string `json:"status"`
+	ResolvedBy string `json:"resolved_by,omitempty"`
+	ResolvedAt string `json:"resolved_at,omitempty"`
+	Error      string `json:"error,omitempty"`
+	Seq        int64  `json:"seq,omitempty"`
+}
+
+func delayedView(d server.DelayedLabel) DelayedView {
+	v := DelayedView{
+		ID:         d.ID,
+		CreatedAt:  d.CreatedAt.UTC().Format(time.RFC3339),
+		PublishAt:  d.PublishAt.UTC().Format(time.RFC3339),
+		Actor:      d.Actor,
+		Uri:        d.Uri,
+		Cid:        d.Cid,
+		Val:        d.Val,
+		Exp:        d.Exp,
+		Status:     d.Status,
+		ResolvedBy: d.ResolvedBy,
+		Error:      d.Error,
+		Seq:        d.Seq,
+	}
+	if d.ResolvedAt != nil {
+		v.ResolvedAt = d.ResolvedAt.UTC().Format(time.RFC3339)
+	}
+	return v
+}
+
+type delayedRequestGet struct {
+	ID     int64  `schema:"id"`
+	Cursor string `schema:"cursor"`
+	Limit  int    `schema:"limit"`
+}
+
+func (h *Handler) delayed(ctx context.Context, get delayedRequestGet) convreq.HttpResponse {
+	if get.ID != 0 {
+		d, err := h.server.GetDelayedLabel(ctx, get.ID)
+		if err != nil {
+			return respond.NotFound(err.Error())
+		}
+		return respond.JSON(delayedView(*d))
+	}
+
+	if get.Limit <= 0 {
+		get.Limit = defaultPageSize
+	}
+	get.Limit = min(get.Limit, maxPageSize)
+	var after int64
+	if get.Cursor != "" {
+		n, err := strconv.ParseInt(get.Cursor, 10, 64)
+		if err != nil {
+			return respond.BadRequest("invalid cursor")
+		}
+		after = n
+	}
+
+	labels, err := h.server.PendingDelayedLabels(ctx, after, get.Limit)
+	if err != nil {
+		return respond.InternalServerError(err.Error())
+	}
+	views := []DelayedView{}
+	for _, d := range labels {
+		views = append(views, delayedView(d))
+	}
+	r := map[string]any{"delayed": views}
+	if len(labels) == get.Limit {
+		r["cursor"] = fmt.Sprint(labels[len(labels)-1].ID)
+	}
+	return respond.JSON(r)
+}
+
+type cancelDelayedJSON struct {
+	ID int64 `json:"id"`
+}
+
+func (h *Handler) cancelDelayed(ctx context.Context, req cancelDelayedJSON) convreq.HttpResponse {
+	d, err := h.server.GetDelayedLabel(ctx, req.ID)
+	if err != nil {
+		return respond.NotFound(err.Error())
+	}
+	if err := auth.CheckLabel(ctx, d.Val); err != nil {
+		return respond.Forbidden(err.Error())
+	}
+	if err := h.server.CancelDelayedLabel(ctx, req.ID, auth.Name(ctx)); err != nil {
+		if errors.Is(err, server.ErrDelayedNotPending) {
+			return respond.BadRequest(err.Error())
+		}
+		return respond.InternalServerError(err.Error())
+	}
+	return respond.JSON(map[string]any{"id": req.ID, "status": server.DelayedCancelled})
+}
@@ -0,0 +1,208 @@
+package adminapi
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/Jille/convreq"
+	"github.com/Jille/convreq/respond"
+
+	comatproto "github.com/bluesky-social/indigo/api/atproto"
+
+	"bsky.watch/labeler/auth"
+	"bsky.watch/labeler/server"
+)
+
+// ReportView is a JSON representation of a report.
+type ReportView struct {
+	ID         int64   `json:"id"`
+	CreatedAt  string  `json:"created_at"`
+	ReportedBy string  `json:"reported_by"`
+	SubjectUri string  `json:"subject_uri"`
+	SubjectCid string  `json:"subject_cid,omitempty"`
+	ReasonType string  `json:"reason_type"`
+	Reason     string  `json:"reason,omitempty"`
+	Status     string  `json:"status"`
+	AssignedTo string  `json:"assigned_to,omitempty"`
+	ResolvedBy string  `json:"resolved_by,omitempty"`
+	ResolvedAt string  `json:"resolved_at,omitempty"`
+	Comment    string  `json:"comment,omitempty"`
+	LabelSeqs  []int64 `json:"label_seqs,omitempty"`
+}
+
+func reportView(r server.Report) ReportView {
+	v := ReportView{
+		ID:         r.ID,
+		CreatedAt:  r.CreatedAt.UTC().Format(time.RFC3339),
+		ReportedBy: r.ReportedBy,
+		SubjectUri: r.SubjectUri,
+		SubjectCid: r.SubjectCid,
+		ReasonType: r.ReasonType,
+		Reason:     r.Reason,
+		Status:     r.Status,
+		AssignedTo: r.AssignedTo,
+		ResolvedBy: r.ResolvedBy,
+		Comment:    r.Comment,
+	}
+	if r.ResolvedAt != nil {
+		v.ResolvedAt = r.ResolvedAt.UTC().Format(time.RFC3339)
+	}
+	for _, l := range r.LabelSeqs {
+		v.LabelSeqs = append(v.LabelSeqs, l.Seq)
+	}
+	return v
+}
+
+type reportsRequestGet struct {
+	Cursor string `schema:"cursor"`
+	Limit  int    `schema:"limit"`
+}
+
+func (h *Handler) reports(ctx context.Context, get reportsRequestGet) convreq.HttpResponse {
+	if get.Limit <= 0 {
+		get.Limit = defaultPageSize
+	}
+	get.Limit = min(get.Limit, maxPageSize)
+	var after int64
+	if get.Cursor != "" {
+		n, err := strconv.ParseInt(get.Cursor, 10, 64)
+		if err != nil {
+			return respond.BadRequest("invalid cursor")
+		}
+		after = n
+	}
+
+	groups, err := h.server.OpenReportsBySubject(ctx, after, get.Limit)
+	if err != nil {
+		return respond.InternalServerError(err.Error())
+	}
+
+	type subjectView struct {
+		Uri     string       `json:"uri"`
+		Reports []ReportView `json:"reports"`
+	}
+	subjects := []subjectView{}
+	for _, g := range groups {
+		v := subjectView{Uri: g.SubjectUri}
+		for _, r := range g.Reports {
+			v.Reports = append(v.Reports, reportView(r))
+		}
+		subjects = append(subjects, v)
+	}
+	r := map[string]any{"subjects": subjects}
+	if len(groups) == get.Limit {
+		r["cursor"] = fmt.Sprint(groups[len(groups)-1].Reports[0].ID)
+	}
+	return respond.JSON(r)
+}
+
+type reportRequestGet struct {
+	ID int64 `schema:"id"`
+}
+
+func (h *Handler) report(ctx context.Context, get reportRequestGet) convreq.HttpResponse {
+	r, err := h.server.GetReport(ctx, get.ID)
+	if err != nil {
+		return respond.NotFound(err.Error())
+	}
+	return respond.JSON(reportView(*r))
+}
+
+type assignRequestJSON struct {
+	IDs []int64 `json:"ids"`
+	// Assignee defaults to the name of the authenticated principal.
+	Assignee *string `json:"assignee"`
+}
+
+func (h *Handler) assign(ctx context.Context, req assignRequestJSON) convreq.HttpResponse {
+	if len(req.IDs) == 0 {
+		return respond.BadRequest("missing `ids`")
+	}
+	assignee := auth.Name(ctx)
+	if req.Assignee != nil {
+		assignee = *req.Assignee
+	}
+	if err := h.server.AssignReports(ctx, req.IDs, assignee); err != nil {
+		if errors.Is(err, server.ErrReportNotOpen) {
+			return respond.BadRequest(err.Error())
+		}
+		return respond.InternalServerError(err.Error())
+	}
+	return respond.JSON(map[string]any{"ids": req.IDs, "assigned_to": assignee})
+}
+
+type resolveRequestJSON struct {
+	IDs     []int64  `json:"ids"`
+	Comment string   `json:"comment"`
+	Apply   []string `json:"apply"`
+	Negate  []string `json:"negate"`
+}
+
+func (h *Handler) resolve(ctx context.Context, req resolveRequestJSON) convreq.HttpResponse {
+	if len(req.IDs) == 0 {
+		return respond.BadRequest("missing `ids`")
+	}
+
+	labels := []comatproto.LabelDefs_Label{}
+	if len(req.Apply) > 0 || len(req.Negate) > 0 {
+		// Labels are applied to the reported subject, so all reports must be about the same one.
+		uri := ""
+		for _, id := range req.IDs {
+			r, err := h.server.GetReport(ctx, id)
+			if err != nil {
+				return respond.BadRequest(err.Error())
+			}
+			if uri != "" && r.SubjectUri != uri {
+				return respond.BadRequest("to apply labels, all reports must be about the same subject")
+			}
+			uri = r.SubjectUri
+		}
+		for _, val := range req.Negate {
+			labels = append(labels, comatproto.LabelDefs_Label{Uri: uri, Val: val, Neg: ptr(true)})
+		}
+		for _, val := range req.Apply {
+			labels = append(labels, comatproto.LabelDefs_Label{Uri: uri, Val: val})
+		}
+		for _, l := range labels {
+			if err := auth.CheckLabel(ctx, l.Val); err != nil {
+				return respond.Forbidden(err.Error())
+			}
+			if err := h.server.ValidateLabel(l); err != nil {
+				return respond.BadRequest(err.Error())
+			}
+			if h.server.RequiresApproval(l.Val) {
+				return respond.BadRequest(fmt.Sprintf("%q requires approval, apply it separately and resolve the reports after it's approved", l.Val))
+			}
+		}
+	}
+
+	results, err := h.server.ResolveReports(ctx, req.IDs, auth.Name(ctx), req.Comment, labels, server.WithActor(auth.Name(ctx)), server.RequireApproval())
+	if err != nil {
+		if errors.Is(err, server.ErrReportNotOpen) {
+			return respond.BadRequest(err.Error())
+		}
+		return respond.InternalServerError(err.Error())
+	}
+
+	items := []labelChange{}
+	for i, l := range labels {
+		v := labelChange{Uri: l.Uri, Val: l.Val, Neg: l.Neg != nil && *l.Neg}
+		switch {
+		case results[i].Err != nil:
+			v.Status = "error"
+			v.Error = results[i].Err.Error()
+		case results[i].Changed:
+			v.Status = "created"
+			v.Seq = results[i].Seq
+		default:
+			v.Status = "noop"
+		}
+		items = append(items, v)
+	}
+	return respond.JSON(map[string]any{"ids": req.IDs, "labels": items})
+}
+
+func ptr[T any](v T) *T { return &v }
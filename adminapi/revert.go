@@ -0,0 +1,113 @@
+package adminapi
+
+import (
+	"context"
+	"errors"
+
+	"github.com/Jille/convreq"
+	"github.com/Jille/convreq/respond"
+
+	"bsky.watch/labeler/auth"
+	"bsky.watch/labeler/server"
+)
+
+type revertRequestJSON struct {
+	From   int64  `json:"from"`
+	To     int64  `json:"to"`
+	Val    string `json:"val"`
+	Actor  string `json:"actor"`
+	DryRun bool   `json:"dry_run"`
+}
+
+// labelChange describes a label written as a side effect of a request.
+type labelChange struct {
+	Uri    string `json:"uri"`
+	Cid    string `json:"cid,omitempty"`
+	Val    string `json:"val"`
+	Neg    bool   `json:"neg,omitempty"`
+	Exp    string `json:"exp,omitempty"`
+	Status string `json:"status"`
+	Seq    int64  `json:"seq,omitempty"`
+	// ApprovalID is set if the label requires approval and wasn't written.
+	ApprovalID int64 `json:"approval_id,omitempty"`
+	// DelayedID is set if the label was staged to be published later.
+	DelayedID int64  `json:"delayed_id,omitempty"`
+	Error     string `json:"error,omitempty"`
+}
+
+func (h *Handler) revert(ctx context.Context, req revertRequestJSON) convreq.HttpResponse {
+	filter := server.RevertFilter{
+		FromSeq: req.From,
+		ToSeq:   req.To,
+		Val:     req.Val,
+		Actor:   req.Actor,
+	}
+	opts := []server.WriteOption{server.WithActor(auth.Name(ctx)), server.RequireApproval()}
+
+	// Always start with a dry run, to check that the principal is allowed
+	// to touch every label that would be written.
+	plan, err := h.server.Revert(ctx, filter, append(opts, server.DryRun())...)
+	if err != nil {
+		return respond.BadRequest(err.Error())
+	}
+	for _, item := range plan.Items {
+		if err := auth.CheckLabel(ctx, item.Label.Val); err != nil {
+			return respond.Forbidden(err.Error())
+		}
+	}
+
+	result := plan
+	if !req.DryRun && plan.LastSeq != 0 {
+		// Entries written after the dry run must not be reverted without
+		// being checked, so pin the range to what was selected for the plan.
+		// The same entries produce the same set of label values, or a subset
+		// if some of them got conflicting changes in the meantime.
+		filter.ToSeq = plan.LastSeq
+		result, err = h.server.Revert(ctx, filter, opts...)
+		if err != nil {
+			return respond.InternalServerError(err.Error())
+		}
+	}
+
+	items := []labelChange{}
+	for _, item := range result.Items {
+		v := labelChange{
+			Uri: item.Label.Uri,
+			Val: item.Label.Val,
+		}
+		if item.Label.Cid != nil {
+			v.Cid = *item.Label.Cid
+		}
+		if item.Label.Neg != nil {
+			v.Neg = *item.Label.Neg
+		}
+		if item.Label.Exp != nil {
+			v.Exp = *item.Label.Exp
+		}
+		pending := (*server.PendingApprovalError)(nil)
+		switch {
+		case errors.As(item.Err, &pending):
+			v.Status = "pending_approval"
+			v.ApprovalID = pending.ID
+		case item.Err != nil:
+			v.Status = "error"
+			v.Error = item.Err.Error()
+		case item.DelayedID != 0:
+			v.Status = "delayed"
+			v.DelayedID = item.DelayedID
+		case item.Changed && req.DryRun:
+			v.Status = "would_create"
+		case item.Changed:
+			v.Status = "created"
+			v.Seq = item.Seq
+		default:
+			v.Status = "noop"
+		}
+		items = append(items, v)
+	}
+	conflicts := []LabelView{}
+	for _, e := range result.Conflicts {
+		conflicts = append(conflicts, labelView(e))
+	}
+	return respond.JSON(map[string]any{"items": items, "conflicts": conflicts})
+}
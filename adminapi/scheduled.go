@@ -0,0 +1,159 @@
+package adminapi
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/Jille/convreq"
+	"github.com/Jille/convreq/respond"
+
+	comatproto "github.com/bluesky-social/indigo/api/atproto"
+
+	"bsky.watch/labeler/auth"
+	"bsky.watch/labeler/server"
+)
+
+// ScheduledView is a JSON representation of a scheduled label.
+type ScheduledView struct {
+	ID         int64  `json:"id"`
+	CreatedAt  string `json:"created_at"`
+	CreatedBy  string `json:"created_by,omitempty"`
+	At         string `json:"at"`
+	Uri        string `json:"uri"`
+	Cid        string `json:"cid,omitempty"`
+	Val        string `json:"val"`
+	Neg        bool   `json:"neg,omitempty"`
+	Exp        string `json:"exp,omitempty"`
+	Status     string `json:"status"`
+	ResolvedBy string `json:"resolved_by,omitempty"`
+	ResolvedAt string `json:"resolved_at,omitempty"`
+	Error      string `json:"error,omitempty"`
+	Seq        int64  `json:"seq,omitempty"`
+}
+
+func scheduledView(l server.ScheduledLabel) ScheduledView {
+	v := ScheduledView{
+		ID:         l.ID,
+		CreatedAt:  l.CreatedAt.UTC().Format(time.RFC3339),
+		CreatedBy:  l.CreatedBy,
+		At:         l.RunAt.UTC().Format(time.RFC3339),
+		Uri:        l.Uri,
+		Cid:        l.Cid,
+		Val:        l.Val,
+		Neg:        l.Neg,
+		Exp:        l.Exp,
+		Status:     l.Status,
+		ResolvedBy: l.ResolvedBy,
+		Error:      l.Error,
+		Seq:        l.Seq,
+	}
+	if l.ResolvedAt != nil {
+		v.ResolvedAt = l.ResolvedAt.UTC().Format(time.RFC3339)
+	}
+	return v
+}
+
+type scheduledRequestGet struct {
+	ID     int64  `schema:"id"`
+	Cursor string `schema:"cursor"`
+	Limit  int    `schema:"limit"`
+}
+
+func (h *Handler) scheduled(ctx context.Context, get scheduledRequestGet) convreq.HttpResponse {
+	if get.ID != 0 {
+		l, err := h.server.GetScheduledLabel(ctx, get.ID)
+		if err != nil {
+			return respond.NotFound(err.Error())
+		}
+		return respond.JSON(scheduledView(*l))
+	}
+
+	if get.Limit <= 0 {
+		get.Limit = defaultPageSize
+	}
+	get.Limit = min(get.Limit, maxPageSize)
+	var after int64
+	if get.Cursor != "" {
+		n, err := strconv.ParseInt(get.Cursor, 10, 64)
+		if err != nil {
+			return respond.BadRequest("invalid cursor")
+		}
+		after = n
+	}
+
+	labels, err := h.server.PendingScheduledLabels(ctx, after, get.Limit)
+	if err != nil {
+		return respond.InternalServerError(err.Error())
+	}
+	views := []ScheduledView{}
+	for _, l := range labels {
+		views = append(views, scheduledView(l))
+	}
+	r := map[string]any{"scheduled": views}
+	if len(labels) == get.Limit {
+		r["cursor"] = fmt.Sprint(labels[len(labels)-1].ID)
+	}
+	return respond.JSON(r)
+}
+
+type scheduleRequestJSON struct {
+	Uri string `json:"uri"`
+	Cid string `json:"cid"`
+	Val string `json:"val"`
+	Neg bool   `json:"neg"`
+	Exp string `json:"exp"`
+	At  string `json:"at"`
+}
+
+func (h *Handler) schedule(ctx context.Context, req scheduleRequestJSON) convreq.HttpResponse {
+	at, err := time.Parse(time.RFC3339, req.At)
+	if err != nil {
+		return respond.BadRequest(fmt.Sprintf("invalid `at`: %s", err))
+	}
+	if err := auth.CheckLabel(ctx, req.Val); err != nil {
+		return respond.Forbidden(err.Error())
+	}
+	if h.server.RequiresApproval(req.Val) {
+		return respond.BadRequest(fmt.Sprintf("%q requires approval and can't be scheduled", req.Val))
+	}
+
+	label := comatproto.LabelDefs_Label{Uri: req.Uri, Val: req.Val}
+	if req.Cid != "" {
+		label.Cid = &req.Cid
+	}
+	if req.Exp != "" {
+		label.Exp = &req.Exp
+	}
+	if req.Neg {
+		label.Neg = &req.Neg
+	}
+	l, err := h.server.ScheduleLabel(ctx, label, at, auth.Name(ctx))
+	if err != nil {
+		return respond.BadRequest(err.Error())
+	}
+	return respond.JSON(scheduledView(*l))
+}
+
+type cancelScheduledJSON struct {
+	ID int64 `json:"id"`
+}
+
+func (h *Handler) cancelScheduled(ctx context.Context, req cancelScheduledJSON) convreq.HttpResponse {
+	l, err := h.server.GetScheduledLabel(ctx, req.ID)
+	if err != nil {
+		return respond.NotFound(err.Error())
+	}
+	if err := auth.CheckLabel(ctx, l.Val); err != nil {
+		return respond.Forbidden(err.Error())
+	}
+	if err := h.server.CancelScheduledLabel(ctx, req.ID, auth.Name(ctx)); err != nil {
+		if errors.Is(err, server.ErrScheduledNotPending) {
+			return respond.BadRequest(err.Error())
+		}
+		return respond.InternalServerError(err.Error())
+	}
+	return respond.JSON(map[string]any{"id": req.ID, "status": server.ScheduledCancelled})
+}
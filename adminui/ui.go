@@ -0,0 +1,213 @@
+// Package adminui implements a minimal server-rendered web UI for moderators.
+//
+// It allows to look up a subject, see its current labels and label history,
+// apply or negate labels, and view recent activity. Like the rest of the admin API,
+// it relies on [auth.Middleware] for authentication, and on [auth.RejectCrossSite]
+// to make sure that forms are submitted from its own pages. Tokens can be entered
+// in the browser as a password for basic auth.
+package adminui
+
+import (
+	"context"
+	"embed"
+	"errors"
+	"fmt"
+	"html/template"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/rs/zerolog"
+
+	comatproto "github.com/bluesky-social/indigo/api/atproto"
+
+	"bsky.watch/labeler/auth"
+	"bsky.watch/labeler/server"
+)
+
+//go:embed templates/*.html
+var templateFS embed.FS
+
+const recentEntriesCount = 50
+
+const flashCookie = "labeler_flash"
+
+type Handler struct {
+	server *server.Server
+	prefix string
+	mux    *http.ServeMux
+	tmpl   map[string]*template.Template
+}
+
+// New returns HTTP handler for the UI. prefix is the path under which the handler
+// is mounted, e.g., "/ui".
+func New(server *server.Server, prefix string) (*Handler, error) {
+	prefix = strings.TrimSuffix(prefix, "/")
+	h := &Handler{
+		server: server,
+		prefix: prefix,
+		mux:    http.NewServeMux(),
+		tmpl:   map[string]*template.Template{},
+	}
+
+	funcs := template.FuncMap{
+		"path": func(p string) string { return prefix + p },
+	}
+	for _, name := range []string{"index.html", "subject.html"} {
+		t, err := template.New("layout.html").Funcs(funcs).ParseFS(templateFS, "templates/layout.html", "templates/"+name)
+		if err != nil {
+			return nil, fmt.Errorf("parsing template %q: %w", name, err)
+		}
+		h.tmpl[name] = t
+	}
+
+	h.mux.HandleFunc("GET "+prefix+"/{$}", h.index)
+	h.mux.HandleFunc("GET "+prefix+"/subject", h.subject)
+	h.mux.HandleFunc("POST "+prefix+"/label", h.label)
+	return h, nil
+}
+
+func (h *Handler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	h.mux.ServeHTTP(w, req)
+}
+
+func (h *Handler) render(ctx context.Context, w http.ResponseWriter, name string, data map[string]any) {
+	data["Principal"] = auth.FromContext(ctx)
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if err := h.tmpl[name].Execute(w, data); err != nil {
+		zerolog.Ctx(ctx).Error().Err(err).Msgf("Failed to render %q: %s", name, err)
+	}
+}
+
+func (h *Handler) index(w http.ResponseWriter, req *http.Request) {
+	ctx := req.Context()
+	entries, err := h.server.RecentEntries(ctx, recentEntriesCount)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	h.render(ctx, w, "index.html", map[string]any{
+		"Uri":    "",
+		"Recent": entries,
+	})
+}
+
+func (h *Handler) subject(w http.ResponseWriter, req *http.Request) {
+	ctx := req.Context()
+	uri := strings.TrimSpace(req.FormValue("uri"))
+	if !strings.HasPrefix(uri, "did:") && !strings.HasPrefix(uri, "at://") {
+		http.Error(w, "subject must be a DID or an AT URI", http.StatusBadRequest)
+		return
+	}
+
+	current, err := h.server.SubjectLabels(ctx, uri)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	history, err := h.server.SubjectHistory(ctx, uri)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	var allowed []string
+	for _, l := range h.server.AllowedLabels() {
+		if p := auth.FromContext(ctx); p == nil || p.CanLabel(l) {
+			allowed = append(allowed, l)
+		}
+	}
+
+	flash := h.takeFlash(w, req)
+	h.render(ctx, w, "subject.html", map[string]any{
+		"Uri":     uri,
+		"Current": current,
+		"History": history,
+		"Allowed": allowed,
+		"Message": flash.Get("msg"),
+		"Error":   flash.Get("err"),
+	})
+}
+
+// setFlash stores a message to be shown on the next page. It's kept in a cookie
+// rather than in the URL, so that a link from elsewhere can't show a fake one.
+func (h *Handler) setFlash(w http.ResponseWriter, key string, msg string) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     flashCookie,
+		Value:    url.Values{key: {msg}}.Encode(),
+		Path:     h.prefix + "/",
+		MaxAge:   60,
+		HttpOnly: true,
+		SameSite: http.SameSiteStrictMode,
+	})
+}
+
+// takeFlash returns the message stored by setFlash, and removes it.
+func (h *Handler) takeFlash(w http.ResponseWriter, req *http.Request) url.Values {
+	c, err := req.Cookie(flashCookie)
+	if err != nil {
+		return url.Values{}
+	}
+	http.SetCookie(w, &http.Cookie{Name: flashCookie, Path: h.prefix + "/", MaxAge: -1})
+	v, err := url.ParseQuery(c.Value)
+	if err != nil {
+		return url.Values{}
+	}
+	return v
+}
+
+func (h *Handler) label(w http.ResponseWriter, req *http.Request) {
+	ctx := req.Context()
+	log := zerolog.Ctx(ctx)
+
+	uri := strings.TrimSpace(req.FormValue("uri"))
+	val := strings.TrimSpace(req.FormValue("val"))
+	back := func(key string, msg string) {
+		h.setFlash(w, key, msg)
+		q := url.Values{"uri": {uri}}
+		http.Redirect(w, req, h.prefix+"/subject?"+q.Encode(), http.StatusSeeOther)
+	}
+
+	label := comatproto.LabelDefs_Label{Uri: uri, Val: val}
+	if cid := strings.TrimSpace(req.FormValue("cid")); cid != "" {
+		label.Cid = &cid
+	}
+	action := req.FormValue("action")
+	switch action {
+	case "apply":
+	case "negate":
+		neg := true
+		label.Neg = &neg
+	default:
+		http.Error(w, "invalid action", http.StatusBadRequest)
+		return
+	}
+
+	if err := auth.CheckLabel(ctx, val); err != nil {
+		back("err", err.Error())
+		return
+	}
+	results, err := h.server.AddLabels(ctx, []comatproto.LabelDefs_Label{label}, server.WithActor(auth.Name(ctx)), server.RequireApproval())
+	if err == nil {
+		err = results[0].Err
+	}
+	if pending := (*server.PendingApprovalError)(nil); errors.As(err, &pending) {
+		back("msg", fmt.Sprintf("Approval request %d created: %s %q needs to be approved by another moderator", pending.ID, action, val))
+		return
+	}
+	if err != nil {
+		back("err", err.Error())
+		return
+	}
+	changed := results[0].Changed
+	log.Info().Str("uri", uri).Str("val", val).Str("action", action).Bool("changed", changed).Msgf("Label %s via web UI", action)
+	if id := results[0].DelayedID; id != 0 {
+		back("msg", fmt.Sprintf("Delayed: %q will be applied after a delay, it can still be cancelled (pending label %d)", val, id))
+		return
+	}
+	if !changed {
+		back("msg", fmt.Sprintf("No changes: %q %s had no effect", val, action))
+		return
+	}
+	back("msg", fmt.Sprintf("Done: %s %q", action, val))
+}
@@ -0,0 +1,186 @@
+package adminui
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"bsky.watch/labeler/auth"
+	"bsky.watch/labeler/config"
+	"bsky.watch/labeler/server"
+)
+
+const testSubject = "did:plc:subject"
+
+func newTestServer(t *testing.T) *server.Server {
+	t.Helper()
+	s, err := server.NewWithConfig(context.Background(), &config.Config{
+		SQLiteDB:   fmt.Sprintf("file:%s?mode=memory&cache=shared", t.Name()),
+		DID:        "did:plc:labeler",
+		PrivateKey: "c6d40ec53c689ca905036e41d8c73560777e5746d1d228fd6f9db56efed8ecaf",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+// testHandler returns the UI wrapped in the same middleware as on the admin listener,
+// with requests authenticated as a principal that can use only "spam" label.
+func testHandler(t *testing.T, s *server.Server) http.Handler {
+	t.Helper()
+	ui, err := New(s, "/ui")
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := &auth.Principal{Name: "mod", Labels: []string{"spam"}}
+	return auth.RejectCrossSite(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ui.ServeHTTP(w, r.WithContext(auth.NewContext(r.Context(), p)))
+	}))
+}
+
+func postLabel(h http.Handler, form url.Values, headers map[string]string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest("POST", "/ui/label", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	return w
+}
+
+// followRedirect fetches the page the form redirected to, with the cookies it set.
+func followRedirect(t *testing.T, h http.Handler, w *httptest.ResponseRecorder) string {
+	t.Helper()
+	if w.Code != http.StatusSeeOther {
+		t.Fatalf("expected a redirect, got %d: %s", w.Code, w.Body)
+	}
+	req := httptest.NewRequest("GET", w.Header().Get("Location"), nil)
+	for _, c := range w.Result().Cookies() {
+		req.AddCookie(c)
+	}
+	page := httptest.NewRecorder()
+	h.ServeHTTP(page, req)
+	if page.Code != http.StatusOK {
+		t.Fatalf("unexpected status %d: %s", page.Code, page.Body)
+	}
+	return page.Body.String()
+}
+
+func activeLabels(t *testing.T, s *server.Server) []string {
+	t.Helper()
+	entries, err := s.SubjectLabels(context.Background(), testSubject)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := []string{}
+	for _, e := range entries {
+		r = append(r, e.Val)
+	}
+	return r
+}
+
+func TestLabelActions(t *testing.T) {
+	s := newTestServer(t)
+	h := testHandler(t, s)
+	sameOrigin := map[string]string{"Sec-Fetch-Site": "same-origin"}
+
+	tests := []struct {
+		name    string
+		form    url.Values
+		want    string
+		wantErr bool
+		labels  []string
+	}{
+		{
+			name:   "apply",
+			form:   url.Values{"uri": {testSubject}, "val": {"spam"}, "action": {"apply"}},
+			want:   "Done: apply &#34;spam&#34;",
+			labels: []string{"spam"},
+		},
+		{
+			name:   "apply again",
+			form:   url.Values{"uri": {testSubject}, "val": {"spam"}, "action": {"apply"}},
+			want:   "No changes",
+			labels: []string{"spam"},
+		},
+		{
+			name:    "not allowed",
+			form:    url.Values{"uri": {testSubject}, "val": {"porn"}, "action": {"apply"}},
+			want:    "not allowed to use label",
+			wantErr: true,
+			labels:  []string{"spam"},
+		},
+		{
+			name:   "negate",
+			form:   url.Values{"uri": {testSubject}, "val": {"spam"}, "action": {"negate"}},
+			want:   "Done: negate &#34;spam&#34;",
+			labels: []string{},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			page := followRedirect(t, h, postLabel(h, test.form, sameOrigin))
+			class := `class="msg"`
+			if test.wantErr {
+				class = `class="err"`
+			}
+			if !strings.Contains(page, class) || !strings.Contains(page, test.want) {
+				t.Errorf("expected the page to show %q, got:\n%s", test.want, page)
+			}
+			if got := activeLabels(t, s); strings.Join(got, ",") != strings.Join(test.labels, ",") {
+				t.Errorf("expected labels %v, got %v", test.labels, got)
+			}
+		})
+	}
+
+	w := postLabel(h, url.Values{"uri": {testSubject}, "val": {"spam"}, "action": {"delete"}}, sameOrigin)
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("expected an invalid action to be rejected, got %d", w.Code)
+	}
+}
+
+func TestCrossSiteRequests(t *testing.T) {
+	s := newTestServer(t)
+	h := testHandler(t, s)
+	form := url.Values{"uri": {testSubject}, "val": {"spam"}, "action": {"apply"}}
+
+	for _, headers := range []map[string]string{
+		{"Sec-Fetch-Site": "cross-site"},
+		{"Sec-Fetch-Site": "same-site"},
+		{"Origin": "https://evil.example"},
+		{"Referer": "https://evil.example/page"},
+	} {
+		if w := postLabel(h, form, headers); w.Code != http.StatusForbidden {
+			t.Errorf("%v: expected status %d, got %d", headers, http.StatusForbidden, w.Code)
+		}
+	}
+	if got := activeLabels(t, s); len(got) != 0 {
+		t.Errorf("cross-site requests wrote labels: %v", got)
+	}
+
+	// Form submitted from our own page by an older browser.
+	w := postLabel(h, form, map[string]string{"Origin": "http://example.com"})
+	if w.Code != http.StatusSeeOther {
+		t.Errorf("expected a same-origin request to succeed, got %d: %s", w.Code, w.Body)
+	}
+}
+
+func TestMessagesFromURL(t *testing.T) {
+	h := testHandler(t, newTestServer(t))
+	q := url.Values{"uri": {testSubject}, "msg": {"Done: fake"}, "err": {"fake error"}}
+	req := httptest.NewRequest("GET", "/ui/subject?"+q.Encode(), nil)
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("unexpected status %d: %s", w.Code, w.Body)
+	}
+	if body := w.Body.String(); strings.Contains(body, "fake") {
+		t.Errorf("messages from the URL are shown on the page:\n%s", body)
+	}
+}
@@ -0,0 +1,183 @@
+// Package auth implements authentication and authorization for the admin API.
+//
+// Middleware authenticates every request and attaches a [Principal] to the request
+// context. Handlers then use [CheckLabel] to verify that the principal is allowed
+// to apply or negate a particular label value.
+package auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"slices"
+
+	"github.com/rs/zerolog"
+
+	"bsky.watch/labeler/config"
+	"bsky.watch/labeler/diddoc"
+)
+
+// ErrNoCredentials is returned by an Authenticator if the request doesn't
+// contain any credentials it can handle.
+var ErrNoCredentials = errors.New("no credentials provided")
+
+// Principal is an authenticated user of the admin API.
+type Principal struct {
+	// Name identifies the principal in logs and metrics.
+	Name string
+	// Role is the name of the role from the config, if any.
+	Role string
+	// Labels is the list of label values that the principal can apply or negate.
+	// "*" allows all label values.
+	Labels []string
+}
+
+// CanLabel returns true if the principal is allowed to apply or negate the label value.
+func (p *Principal) CanLabel(val string) bool {
+	return slices.Contains(p.Labels, "*") || slices.Contains(p.Labels, val)
+}
+
+// Authenticator extracts credentials from the request and verifies them.
+type Authenticator interface {
+	Authenticate(r *http.Request) (*Principal, error)
+}
+
+type ctxKey struct{}
+
+// NewContext returns a copy of ctx with the principal attached.
+func NewContext(ctx context.Context, p *Principal) context.Context {
+	return context.WithValue(ctx, ctxKey{}, p)
+}
+
+// FromContext returns the principal attached to ctx, or nil if there's none.
+func FromContext(ctx context.Context) *Principal {
+	p, _ := ctx.Value(ctxKey{}).(*Principal)
+	return p
+}
+
+// Name returns the name of the principal attached to ctx, or an empty string if there's none.
+func Name(ctx context.Context) string {
+	if p := FromContext(ctx); p != nil {
+		return p.Name
+	}
+	return ""
+}
+
+// Middleware rejects requests that don't pass authentication by a, and attaches
+// the authenticated principal to the context of the remaining ones.
+func Middleware(a Authenticator, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		log := zerolog.Ctx(r.Context()).With().Str("remote", r.RemoteAddr).Logger()
+		if forwarded := r.Header.Get("X-Forwarded-For"); forwarded != "" {
+			log = log.With().Str("forwarded_for", forwarded).Logger()
+		}
+
+		p, err := a.Authenticate(r)
+		if err != nil {
+			reason := "invalid_credentials"
+			if errors.Is(err, ErrNoCredentials) {
+				reason = "no_credentials"
+			}
+			rejectedRequests.WithLabelValues(reason).Inc()
+			log.Warn().Err(err).Str("path", r.URL.Path).Msgf("Rejected unauthenticated admin API request: %s", err)
+
+			w.Header().Add("WWW-Authenticate", `Bearer realm="labeler"`)
+			w.Header().Add("WWW-Authenticate", `Basic realm="labeler"`)
+			http.Error(w, "authentication required", http.StatusUnauthorized)
+			return
+		}
+
+		log = log.With().Str("principal", p.Name).Logger()
+		ctx := NewContext(log.WithContext(r.Context()), p)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// RejectCrossSite rejects state-changing requests that browsers send on behalf of
+// other sites. Tokens are also accepted as basic auth passwords, and browsers attach
+// those automatically, so without this any site could write labels using
+// credentials of an operator logged into the web UI. Requests that don't look like
+// they came from a browser (no Sec-Fetch-Site and Origin headers) are let through.
+func RejectCrossSite(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet, http.MethodHead, http.MethodOptions:
+			next.ServeHTTP(w, r)
+			return
+		}
+		if !sameOrigin(r) {
+			rejectedRequests.WithLabelValues("cross_site").Inc()
+			zerolog.Ctx(r.Context()).Warn().Str("remote", r.RemoteAddr).Str("path", r.URL.Path).
+				Str("origin", r.Header.Get("Origin")).Msgf("Rejected cross-site admin API request")
+			http.Error(w, "cross-site requests are not allowed", http.StatusForbidden)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func sameOrigin(r *http.Request) bool {
+	switch r.Header.Get("Sec-Fetch-Site") {
+	case "same-origin", "none":
+		return true
+	case "":
+		// Older browser or not a browser at all, fall back to checking Origin or Referer.
+	default:
+		return false
+	}
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		origin = r.Header.Get("Referer")
+	}
+	if origin == "" {
+		return true
+	}
+	u, err := url.Parse(origin)
+	if err != nil {
+		return false
+	}
+	return u.Host == r.Host
+}
+
+// CheckLabel returns an error if the principal attached to ctx is not allowed to apply
+// or negate the label value. If there's no principal in ctx (i.e., authentication is
+// not enabled), all label values are allowed.
+func CheckLabel(ctx context.Context, val string) error {
+	p := FromContext(ctx)
+	if p == nil || p.CanLabel(val) {
+		return nil
+	}
+	rejectedRequests.WithLabelValues("forbidden_label").Inc()
+	zerolog.Ctx(ctx).Warn().Str("val", val).Msgf("%q is not allowed to use label %q", p.Name, val)
+	return fmt.Errorf("%q is not allowed to use label %q", p.Name, val)
+}
+
+// NewFromConfig returns an Authenticator that accepts all types of credentials
+// configured in cfg, or nil if none are configured.
+func NewFromConfig(cfg *config.Config, resolver diddoc.Resolver) (Authenticator, error) {
+	var authenticators []Authenticator
+	if len(cfg.AdminTokens) > 0 {
+		a, err := NewTokenAuthenticator(cfg)
+		if err != nil {
+			return nil, err
+		}
+		authenticators = append(authenticators, a)
+	}
+	if len(cfg.Moderators) > 0 {
+		a, err := NewServiceAuthAuthenticator(cfg, resolver)
+		if err != nil {
+			return nil, err
+		}
+		authenticators = append(authenticators, a)
+	}
+	switch len(authenticators) {
+	case 0:
+		return nil, nil
+	case 1:
+		return authenticators[0], nil
+	default:
+		return Any(authenticators...), nil
+	}
+}
@@ -0,0 +1,42 @@
+package auth
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestRejectCrossSite(t *testing.T) {
+	tests := []struct {
+		method  string
+		headers map[string]string
+		allowed bool
+	}{
+		{method: "POST", allowed: true},
+		{method: "GET", headers: map[string]string{"Sec-Fetch-Site": "cross-site"}, allowed: true},
+		{method: "POST", headers: map[string]string{"Sec-Fetch-Site": "same-origin"}, allowed: true},
+		{method: "POST", headers: map[string]string{"Sec-Fetch-Site": "none"}, allowed: true},
+		{method: "POST", headers: map[string]string{"Sec-Fetch-Site": "cross-site"}, allowed: false},
+		{method: "POST", headers: map[string]string{"Sec-Fetch-Site": "same-site"}, allowed: false},
+		{method: "POST", headers: map[string]string{"Origin": "http://labeler.example"}, allowed: true},
+		{method: "POST", headers: map[string]string{"Origin": "https://evil.example"}, allowed: false},
+		{method: "POST", headers: map[string]string{"Origin": "null"}, allowed: false},
+		{method: "POST", headers: map[string]string{"Referer": "https://evil.example/form.html"}, allowed: false},
+		{method: "PUT", headers: map[string]string{"Sec-Fetch-Site": "cross-site"}, allowed: false},
+	}
+
+	handler := RejectCrossSite(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	for _, test := range tests {
+		req := httptest.NewRequest(test.method, "http://labeler.example/reload", nil)
+		for k, v := range test.headers {
+			req.Header.Set(k, v)
+		}
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		if got := w.Code == http.StatusNoContent; got != test.allowed {
+			t.Errorf("%s %v: allowed = %v, expected %v", test.method, test.headers, got, test.allowed)
+		}
+	}
+}
@@ -0,0 +1,15 @@
+package auth
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var (
+	rejectedRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "labeler",
+		Subsystem: "auth",
+		Name:      "rejected_requests_total",
+		Help:      "Number of admin API requests rejected due to failed authentication or authorization.",
+	}, []string{"reason"})
+)
@@ -0,0 +1,254 @@
+package auth
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"bsky.watch/labeler/config"
+	"bsky.watch/labeler/diddoc"
+)
+
+// Allowed clock skew when checking token expiration.
+const clockSkew = 30 * time.Second
+
+// maxTokenLifetime limits how long a token can be valid for. PDSes issue
+// service auth tokens that expire within a minute by default, so there's no
+// reason to accept ones that stay valid for hours.
+const maxTokenLifetime = 5 * time.Minute
+
+// AdminMethod is the `lxm` value expected in service auth tokens used for
+// admin API endpoints that are not XRPC methods (e.g., `/label` or `/api/...`).
+const AdminMethod = "watch.bsky.labeler.admin"
+
+type jwtHeader struct {
+	Alg string `json:"alg"`
+	Typ string `json:"typ"`
+}
+
+type jwtClaims struct {
+	Iss string `json:"iss"`
+	Aud string `json:"aud"`
+	Exp int64  `json:"exp"`
+	Iat int64  `json:"iat"`
+	Lxm string `json:"lxm"`
+}
+
+// How long a cached DID document must be kept before a signature check failure
+// is allowed to trigger fetching it again.
+const minRefetchInterval = time.Minute
+
+// serviceToken is a service auth JWT with the claims checked, but not the signature.
+type serviceToken struct {
+	iss    string
+	signed []byte
+	sig    []byte
+}
+
+// VerifyServiceAuth checks an ATproto inter-service JWT and returns the DID of the issuer.
+//
+// The token must be signed by the issuer's `atproto` key, and have the audience
+// set to `aud` (either just the DID, or DID with a service fragment).
+// If the token is bound to a specific method, it must match `lxm`. The token must
+// expire within maxTokenLifetime.
+func VerifyServiceAuth(ctx context.Context, resolver diddoc.Resolver, token string, aud string, lxm string) (string, error) {
+	t, err := parseServiceAuth(token, aud, lxm)
+	if err != nil {
+		return "", err
+	}
+	if err := t.verify(ctx, resolver); err != nil {
+		return "", err
+	}
+	return t.iss, nil
+}
+
+// parseServiceAuth checks everything about the token that doesn't require
+// resolving the issuer's DID.
+func parseServiceAuth(token string, aud string, lxm string) (*serviceToken, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, fmt.Errorf("malformed JWT")
+	}
+
+	var header jwtHeader
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return nil, fmt.Errorf("decoding JWT header: %w", err)
+	}
+	if header.Alg != "ES256K" && header.Alg != "ES256" {
+		return nil, fmt.Errorf("unsupported JWT algorithm %q", header.Alg)
+	}
+
+	var claims jwtClaims
+	if err := decodeSegment(parts[1], &claims); err != nil {
+		return nil, fmt.Errorf("decoding JWT claims: %w", err)
+	}
+
+	iss, _, _ := strings.Cut(claims.Iss, "#")
+	if !strings.HasPrefix(iss, "did:") {
+		return nil, fmt.Errorf("invalid issuer %q", claims.Iss)
+	}
+	if audDID, _, _ := strings.Cut(claims.Aud, "#"); audDID != aud {
+		return nil, fmt.Errorf("token audience %q doesn't match %q", claims.Aud, aud)
+	}
+	if claims.Exp == 0 {
+		return nil, fmt.Errorf("token has no expiration time")
+	}
+	now := time.Now()
+	exp := time.Unix(claims.Exp, 0)
+	if exp.Add(clockSkew).Before(now) {
+		return nil, fmt.Errorf("token has expired")
+	}
+	if exp.Sub(now) > maxTokenLifetime+clockSkew {
+		return nil, fmt.Errorf("token expires too far in the future (%s), at most %s is allowed", exp.Sub(now).Round(time.Second), maxTokenLifetime)
+	}
+	if claims.Iat != 0 {
+		iat := time.Unix(claims.Iat, 0)
+		if iat.Add(-clockSkew).After(now) {
+			return nil, fmt.Errorf("token is issued in the future")
+		}
+		if exp.Sub(iat) > maxTokenLifetime {
+			return nil, fmt.Errorf("token lifetime (%s) is longer than %s", exp.Sub(iat), maxTokenLifetime)
+		}
+	}
+	if claims.Lxm != "" && claims.Lxm != lxm {
+		return nil, fmt.Errorf("token is for method %q, not %q", claims.Lxm, lxm)
+	}
+
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, fmt.Errorf("decoding JWT signature: %w", err)
+	}
+	return &serviceToken{
+		iss:    iss,
+		signed: []byte(parts[0] + "." + parts[1]),
+		sig:    sig,
+	}, nil
+}
+
+// verify checks the token signature against the issuer's `atproto` key.
+func (t *serviceToken) verify(ctx context.Context, resolver diddoc.Resolver) error {
+	verify := func() error {
+		doc, err := resolver.Resolve(ctx, t.iss)
+		if err != nil {
+			return fmt.Errorf("resolving %q: %w", t.iss, err)
+		}
+		key, err := doc.PublicKey("atproto")
+		if err != nil {
+			return fmt.Errorf("getting signing key of %q: %w", t.iss, err)
+		}
+		return key.HashAndVerifyLenient(t.signed, t.sig)
+	}
+
+	err := verify()
+	if err != nil {
+		// The key might have been rotated since we've cached the DID document.
+		if c, ok := resolver.(*diddoc.CachingResolver); ok && c.Purge(t.iss, minRefetchInterval) {
+			err = verify()
+		}
+	}
+	if err != nil {
+		return fmt.Errorf("verifying JWT signature: %w", err)
+	}
+	return nil
+}
+
+func decodeSegment(s string, v any) error {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(b, v)
+}
+
+type serviceAuthenticator struct {
+	did        string
+	resolver   diddoc.Resolver
+	moderators map[string]*Principal
+}
+
+// NewServiceAuthAuthenticator returns an Authenticator that accepts ATproto
+// inter-service JWTs issued by moderators listed in the config, with the audience
+// set to labeler's DID.
+func NewServiceAuthAuthenticator(cfg *config.Config, resolver diddoc.Resolver) (Authenticator, error) {
+	if cfg.DID == "" {
+		return nil, fmt.Errorf("labeler DID must be set to use service auth")
+	}
+	r := &serviceAuthenticator{
+		did:        cfg.DID,
+		resolver:   resolver,
+		moderators: map[string]*Principal{},
+	}
+	for _, m := range cfg.Moderators {
+		if !strings.HasPrefix(m.DID, "did:") {
+			return nil, fmt.Errorf("invalid moderator DID %q", m.DID)
+		}
+		role, ok := cfg.Roles[m.Role]
+		if !ok {
+			return nil, fmt.Errorf("moderator %q has unknown role %q", m.DID, m.Role)
+		}
+		r.moderators[m.DID] = &Principal{
+			Name:   m.DID,
+			Role:   m.Role,
+			Labels: role.Labels,
+		}
+	}
+	return r, nil
+}
+
+func (a *serviceAuthenticator) Authenticate(r *http.Request) (*Principal, error) {
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok || token == "" || strings.Count(token, ".") != 2 {
+		return nil, ErrNoCredentials
+	}
+
+	// Tokens bound to a method are accepted only for that method. All the other
+	// endpoints share AdminMethod.
+	lxm := AdminMethod
+	if m, ok := strings.CutPrefix(r.URL.Path, "/xrpc/"); ok {
+		lxm = m
+	}
+	t, err := parseServiceAuth(token, a.did, lxm)
+	if err != nil {
+		return nil, err
+	}
+	// Check the issuer before resolving it, so that arbitrary callers can't make us
+	// fetch arbitrary DID documents.
+	p, ok := a.moderators[t.iss]
+	if !ok {
+		return nil, fmt.Errorf("%q is not a moderator", t.iss)
+	}
+	if err := t.verify(r.Context(), a.resolver); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+type anyAuthenticator []Authenticator
+
+// Any returns an Authenticator that accepts credentials accepted by
+// at least one of the provided authenticators.
+func Any(authenticators ...Authenticator) Authenticator {
+	return anyAuthenticator(authenticators)
+}
+
+func (a anyAuthenticator) Authenticate(r *http.Request) (*Principal, error) {
+	var errs []error
+	for _, authn := range a {
+		p, err := authn.Authenticate(r)
+		if err == nil {
+			return p, nil
+		}
+		if !errors.Is(err, ErrNoCredentials) {
+			errs = append(errs, err)
+		}
+	}
+	if len(errs) == 0 {
+		return nil, ErrNoCredentials
+	}
+	return nil, errors.Join(errs...)
+}
@@ -0,0 +1,240 @@
+package auth
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bluesky-social/indigo/atproto/crypto"
+
+	"bsky.watch/labeler/config"
+	"bsky.watch/labeler/diddoc"
+)
+
+const (
+	testLabelerDID   = "did:plc:labeler"
+	testModeratorDID = "did:plc:moderator"
+	testMethod       = "tools.ozone.moderation.emitEvent"
+)
+
+type fakeResolver struct {
+	docs  map[string]*diddoc.Document
+	calls int
+}
+
+func (r *fakeResolver) Resolve(ctx context.Context, did string) (*diddoc.Document, error) {
+	r.calls++
+	doc, ok := r.docs[did]
+	if !ok {
+		return nil, fmt.Errorf("%q not found", did)
+	}
+	return doc, nil
+}
+
+func (r *fakeResolver) setKey(t *testing.T, did string, key *crypto.PrivateKeyK256) {
+	t.Helper()
+	pub, err := key.PublicKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.docs[did] = &diddoc.Document{
+		ID: did,
+		VerificationMethod: []diddoc.VerificationMethod{{
+			ID:                 did + "#atproto",
+			Type:               "Multikey",
+			Controller:         did,
+			PublicKeyMultibase: pub.Multibase(),
+		}},
+	}
+}
+
+func newKey(t *testing.T) *crypto.PrivateKeyK256 {
+	t.Helper()
+	key, err := crypto.GeneratePrivateKeyK256()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+func makeToken(t *testing.T, key *crypto.PrivateKeyK256, alg string, claims map[string]any) string {
+	t.Helper()
+	header, err := json.Marshal(map[string]string{"alg": alg, "typ": "JWT"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signed := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	sig, err := key.HashAndSign([]byte(signed))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signed + "." + base64.RawURLEncoding.EncodeToString(sig)
+}
+
+func TestVerifyServiceAuth(t *testing.T) {
+	key := newKey(t)
+	otherKey := newKey(t)
+	resolver := &fakeResolver{docs: map[string]*diddoc.Document{}}
+	resolver.setKey(t, testModeratorDID, key)
+
+	now := time.Now()
+	claims := func(modify func(c map[string]any)) map[string]any {
+		c := map[string]any{
+			"iss": testModeratorDID,
+			"aud": testLabelerDID,
+			"exp": now.Add(time.Minute).Unix(),
+			"iat": now.Unix(),
+			"lxm": testMethod,
+		}
+		if modify != nil {
+			modify(c)
+		}
+		return c
+	}
+
+	tests := []struct {
+		name  string
+		token string
+		ok    bool
+	}{
+		{"valid", makeToken(t, key, "ES256K", claims(nil)), true},
+		{"audience with service fragment", makeToken(t, key, "ES256K", claims(func(c map[string]any) { c["aud"] = testLabelerDID + "#atproto_labeler" })), true},
+		{"issuer with service fragment", makeToken(t, key, "ES256K", claims(func(c map[string]any) { c["iss"] = testModeratorDID + "#atproto_pds" })), true},
+		{"no lxm", makeToken(t, key, "ES256K", claims(func(c map[string]any) { delete(c, "lxm") })), true},
+		{"expired within clock skew", makeToken(t, key, "ES256K", claims(func(c map[string]any) { c["exp"] = now.Add(-clockSkew / 2).Unix() })), true},
+		{"wrong audience", makeToken(t, key, "ES256K", claims(func(c map[string]any) { c["aud"] = "did:plc:other" })), false},
+		{"wrong lxm", makeToken(t, key, "ES256K", claims(func(c map[string]any) { c["lxm"] = "com.atproto.moderation.createReport" })), false},
+		{"expired", makeToken(t, key, "ES256K", claims(func(c map[string]any) { c["exp"] = now.Add(-time.Hour).Unix() })), false},
+		{"no expiration", makeToken(t, key, "ES256K", claims(func(c map[string]any) { delete(c, "exp") })), false},
+		{"no iat", makeToken(t, key, "ES256K", claims(func(c map[string]any) { delete(c, "iat") })), true},
+		{"long-lived", makeToken(t, key, "ES256K", claims(func(c map[string]any) {
+			delete(c, "lxm")
+			c["exp"] = now.Add(365 * 24 * time.Hour).Unix()
+		})), false},
+		{"long-lived without iat", makeToken(t, key, "ES256K", claims(func(c map[string]any) {
+			delete(c, "iat")
+			c["exp"] = now.Add(time.Hour).Unix()
+		})), false},
+		{"lifetime longer than allowed", makeToken(t, key, "ES256K", claims(func(c map[string]any) { c["iat"] = now.Add(-time.Hour).Unix() })), false},
+		{"issued in the future", makeToken(t, key, "ES256K", claims(func(c map[string]any) { c["iat"] = now.Add(time.Hour).Unix() })), false},
+		{"issuer is not a DID", makeToken(t, key, "ES256K", claims(func(c map[string]any) { c["iss"] = "moderator.example" })), false},
+		{"unknown issuer", makeToken(t, key, "ES256K", claims(func(c map[string]any) { c["iss"] = "did:plc:unknown" })), false},
+		{"wrong key", makeToken(t, otherKey, "ES256K", claims(nil)), false},
+		{"unsupported algorithm", makeToken(t, key, "HS256", claims(nil)), false},
+		{"malformed", "not.a-jwt", false},
+		{"tampered claims", func() string {
+			parts := strings.Split(makeToken(t, key, "ES256K", claims(nil)), ".")
+			other := strings.Split(makeToken(t, key, "ES256K", claims(func(c map[string]any) { c["exp"] = now.Add(2 * time.Minute).Unix() })), ".")
+			return parts[0] + "." + other[1] + "." + parts[2]
+		}(), false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			did, err := VerifyServiceAuth(context.Background(), resolver, test.token, testLabelerDID, testMethod)
+			if (err == nil) != test.ok {
+				t.Fatalf("expected ok=%v, got error %v", test.ok, err)
+			}
+			if err == nil && did != testModeratorDID {
+				t.Errorf("expected issuer %q, got %q", testModeratorDID, did)
+			}
+		})
+	}
+}
+
+func TestServiceAuthAuthenticator(t *testing.T) {
+	key := newKey(t)
+	resolver := &fakeResolver{docs: map[string]*diddoc.Document{}}
+	resolver.setKey(t, testModeratorDID, key)
+	resolver.setKey(t, "did:plc:user", key)
+
+	cfg := &config.Config{
+		DID:        testLabelerDID,
+		Roles:      map[string]config.Role{"mod": {Labels: []string{"spam"}}},
+		Moderators: []config.Moderator{{DID: testModeratorDID, Role: "mod"}},
+	}
+	caching := diddoc.NewCachingResolver(resolver, time.Hour)
+	a, err := NewServiceAuthAuthenticator(cfg, caching)
+	if err != nil {
+		t.Fatal(err)
+	}
+	authenticateAt := func(path string, claims map[string]any, key *crypto.PrivateKeyK256) (*Principal, error) {
+		req := httptest.NewRequest("POST", path, nil)
+		req.Header.Set("Authorization", "Bearer "+makeToken(t, key, "ES256K", claims))
+		return a.Authenticate(req)
+	}
+	authenticate := func(iss string, key *crypto.PrivateKeyK256) (*Principal, error) {
+		return authenticateAt("/xrpc/"+testMethod, map[string]any{
+			"iss": iss,
+			"aud": testLabelerDID,
+			"exp": time.Now().Add(time.Minute).Unix(),
+			"lxm": testMethod,
+		}, key)
+	}
+
+	p, err := authenticate(testModeratorDID, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.Name != testModeratorDID || p.Role != "mod" || !p.CanLabel("spam") || p.CanLabel("other") {
+		t.Errorf("unexpected principal: %+v", p)
+	}
+
+	// Non-moderators are rejected before their DID is resolved.
+	resolver.calls = 0
+	if _, err := authenticate("did:plc:user", key); err == nil {
+		t.Errorf("expected a non-moderator to be rejected")
+	}
+	if _, err := authenticate("did:web:internal.example", key); err == nil {
+		t.Errorf("expected a non-moderator to be rejected")
+	}
+	if resolver.calls != 0 {
+		t.Errorf("expected no DID resolutions for non-moderators, got %d", resolver.calls)
+	}
+
+	// Bad signatures can't make us fetch a recently resolved document again.
+	if _, err := authenticate(testModeratorDID, newKey(t)); err == nil {
+		t.Errorf("expected a token signed with a wrong key to be rejected")
+	}
+	if resolver.calls != 0 {
+		t.Errorf("expected the cached document to be used, got %d resolutions", resolver.calls)
+	}
+
+	// Tokens bound to a method can only be used for that method.
+	methods := []struct {
+		path string
+		lxm  string
+		ok   bool
+	}{
+		{"/label", AdminMethod, true},
+		{"/api/reports", AdminMethod, true},
+		{"/label", "", true},
+		{"/label", "com.atproto.moderation.createReport", false},
+		{"/label/bulk", testMethod, false},
+		{"/ui/label", "com.atproto.moderation.createReport", false},
+		{"/xrpc/" + testMethod, AdminMethod, false},
+		{"/xrpc/" + testMethod, "com.atproto.moderation.createReport", false},
+	}
+	for _, m := range methods {
+		claims := map[string]any{
+			"iss": testModeratorDID,
+			"aud": testLabelerDID,
+			"exp": time.Now().Add(time.Minute).Unix(),
+		}
+		if m.lxm != "" {
+			claims["lxm"] = m.lxm
+		}
+		if _, err := authenticateAt(m.path, claims, key); (err == nil) != m.ok {
+			t.Errorf("token with lxm=%q for %s: expected ok=%v, got error %v", m.lxm, m.path, m.ok, err)
+		}
+	}
+}
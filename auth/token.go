@@ -0,0 +1,79 @@
+package auth
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+
+	"bsky.watch/labeler/config"
+)
+
+type tokenAuthenticator struct {
+	tokens []tokenEntry
+}
+
+type tokenEntry struct {
+	hash      [sha256.Size]byte
+	principal *Principal
+}
+
+// NewTokenAuthenticator returns an Authenticator that accepts bearer tokens
+// defined in the config.
+func NewTokenAuthenticator(cfg *config.Config) (Authenticator, error) {
+	r := &tokenAuthenticator{}
+	for i, t := range cfg.AdminTokens {
+		if t.Token == "" {
+			return nil, fmt.Errorf("admin token #%d (%q) has empty value", i, t.Name)
+		}
+		name := t.Name
+		if name == "" {
+			name = fmt.Sprintf("token#%d", i)
+		}
+		labels := slices.Clone(t.Labels)
+		if t.Role != "" {
+			role, ok := cfg.Roles[t.Role]
+			if !ok {
+				return nil, fmt.Errorf("admin token %q has unknown role %q", name, t.Role)
+			}
+			labels = append(labels, role.Labels...)
+		}
+		r.tokens = append(r.tokens, tokenEntry{
+			hash: sha256.Sum256([]byte(t.Token)),
+			principal: &Principal{
+				Name:   name,
+				Role:   t.Role,
+				Labels: labels,
+			},
+		})
+	}
+	return r, nil
+}
+
+func (a *tokenAuthenticator) Authenticate(r *http.Request) (*Principal, error) {
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok {
+		// Browsers can't send bearer tokens by themselves, so we also accept
+		// the token as a password for basic auth. Username is ignored.
+		_, token, ok = r.BasicAuth()
+	}
+	if !ok || token == "" {
+		return nil, ErrNoCredentials
+	}
+
+	// Comparing hashes to not leak the token length, and going through
+	// all the tokens to not leak which one was a partial match.
+	h := sha256.Sum256([]byte(token))
+	var match *Principal
+	for _, t := range a.tokens {
+		if subtle.ConstantTimeCompare(h[:], t.hash[:]) == 1 {
+			match = t.principal
+		}
+	}
+	if match == nil {
+		return nil, fmt.Errorf("unknown token")
+	}
+	return match, nil
+}
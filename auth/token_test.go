@@ -0,0 +1,105 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+
+	"bsky.watch/labeler/config"
+)
+
+func TestTokenAuthenticator(t *testing.T) {
+	cfg := &config.Config{
+		Roles: map[string]config.Role{"mod": {Labels: []string{"spam", "porn"}}},
+		AdminTokens: []config.AdminToken{
+			{Name: "ci", Token: "ci-token", Labels: []string{"spam"}},
+			{Token: "anonymous-token", Labels: []string{"*"}},
+			{Name: "alice", Token: "alice-token", Role: "mod", Labels: []string{"extra"}},
+		},
+	}
+	a, err := NewTokenAuthenticator(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		bearer   string
+		password string
+		want     *Principal
+		noCreds  bool
+	}{
+		{name: "bearer", bearer: "ci-token", want: &Principal{Name: "ci", Labels: []string{"spam"}}},
+		{name: "basic auth", password: "ci-token", want: &Principal{Name: "ci", Labels: []string{"spam"}}},
+		{name: "default name", bearer: "anonymous-token", want: &Principal{Name: "token#1", Labels: []string{"*"}}},
+		{name: "role", bearer: "alice-token", want: &Principal{Name: "alice", Role: "mod", Labels: []string{"extra", "spam", "porn"}}},
+		{name: "unknown token", bearer: "wrong"},
+		{name: "prefix of a token", bearer: "ci-"},
+		{name: "no credentials", noCreds: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			req := httptest.NewRequest("POST", "/label", nil)
+			switch {
+			case test.bearer != "":
+				req.Header.Set("Authorization", "Bearer "+test.bearer)
+			case test.password != "":
+				req.SetBasicAuth("ignored", test.password)
+			}
+			p, err := a.Authenticate(req)
+			if test.noCreds {
+				if !errors.Is(err, ErrNoCredentials) {
+					t.Errorf("expected ErrNoCredentials, got %v", err)
+				}
+				return
+			}
+			if test.want == nil {
+				if err == nil || errors.Is(err, ErrNoCredentials) {
+					t.Errorf("expected the token to be rejected, got %+v, %v", p, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if diff := cmp.Diff(test.want, p); diff != "" {
+				t.Errorf("unexpected principal (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestNewTokenAuthenticatorErrors(t *testing.T) {
+	tests := []struct {
+		name   string
+		tokens []config.AdminToken
+	}{
+		{"empty token", []config.AdminToken{{Name: "ci"}}},
+		{"unknown role", []config.AdminToken{{Name: "ci", Token: "t", Role: "admin"}}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if _, err := NewTokenAuthenticator(&config.Config{AdminTokens: test.tokens}); err == nil {
+				t.Errorf("expected an error")
+			}
+		})
+	}
+}
+
+func TestCheckLabel(t *testing.T) {
+	ctx := context.Background()
+	if err := CheckLabel(ctx, "spam"); err != nil {
+		t.Errorf("expected all labels to be allowed without a principal, got %v", err)
+	}
+	ctx = NewContext(ctx, &Principal{Name: "ci", Labels: []string{"spam"}})
+	if err := CheckLabel(ctx, "spam"); err != nil {
+		t.Errorf("expected spam to be allowed, got %v", err)
+	}
+	if err := CheckLabel(ctx, "porn"); err == nil {
+		t.Errorf("expected porn to be rejected")
+	}
+}
@@ -21,12 +21,13 @@ import (
 )
 
 var (
-	configFile = flag.String("config", "config.yaml", "Path to the config file")
-	endpoint   = flag.String("from", "", "URL of the labeler to copy the labels from")
+	configFile  = flag.String("config", "config.yaml", "Path to the config file")
+	endpoint    = flag.String("from", "", "URL of the labeler to copy the labels from")
+	checkConfig = flag.Bool("check-config", false, "Validate the config file and exit")
 )
 
 func runMain(ctx context.Context) error {
-	if *endpoint == "" {
+	if *endpoint == "" && !*checkConfig {
 		return fmt.Errorf("--from is required")
 	}
 
@@ -35,12 +36,26 @@ func runMain(ctx context.Context) error {
 		return fmt.Errorf("reading config file: %w", err)
 	}
 
-	config := &config.Config{}
-	if err := yaml.Unmarshal(b, config); err != nil {
+	cfg := &config.Config{}
+	if err := yaml.Unmarshal(b, cfg); err != nil {
 		return fmt.Errorf("parsing config file: %w", err)
 	}
+	if err := cfg.LoadSecrets(); err != nil {
+		return fmt.Errorf("loading secrets: %w", err)
+	}
+	warnings, err := cfg.Validate(config.RequireDatabase)
+	for _, w := range warnings {
+		fmt.Fprintf(os.Stderr, "Warning: %s\n", w)
+	}
+	if err != nil {
+		return fmt.Errorf("invalid config:\n%w", err)
+	}
+	if *checkConfig {
+		fmt.Fprintln(os.Stderr, "Config is valid")
+		return nil
+	}
 
-	server, err := server.NewWithConfig(ctx, config)
+	server, err := server.NewWithConfig(ctx, cfg)
 	if err != nil {
 		return fmt.Errorf("instantiating a server: %w", err)
 	}
@@ -0,0 +1,221 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+
+	"gitlab.com/yawning/secp256k1-voi/secec"
+	"gopkg.in/yaml.v3"
+
+	"github.com/bluesky-social/indigo/atproto/crypto"
+
+	"bsky.watch/labeler/config"
+	"bsky.watch/labeler/diddoc"
+	"bsky.watch/labeler/sign"
+)
+
+var (
+	configFile = flag.String("config", "", "Path to the config file to read the private key from")
+	keyFlag    = flag.String("key", "", "Private key in any supported format. Use '-' to read it from stdin")
+	format     = flag.String("format", "hex", "Output format for private keys: 'hex', 'multibase' or 'pem'")
+	didDoc     = flag.String("did-doc", "", "DID, or path or URL of a DID document to check the key against")
+	output     = flag.String("out", "", "Path to write the keystore to")
+	passFile   = flag.String("passphrase-file", "", "File with the keystore passphrase. If not set, KEYSTORE_PASSPHRASE environment variable is used")
+)
+
+func usage() {
+	fmt.Fprintf(flag.CommandLine.Output(), `Usage: %s <command> [flags]
+
+Commands:
+  generate  Generate a new private key
+  public    Print did:key form of the public key
+  convert   Convert the private key into a different format
+  check     Check that the key matches atproto_label verification method in a DID document
+  encrypt   Write the private key into a passphrase-protected keystore file
+
+Flags:
+`, os.Args[0])
+	flag.PrintDefaults()
+}
+
+func readKey() (*secec.PrivateKey, error) {
+	switch {
+	case *keyFlag == "-":
+		b, err := io.ReadAll(os.Stdin)
+		if err != nil {
+			return nil, fmt.Errorf("reading stdin: %w", err)
+		}
+		return sign.ParsePrivateKey(string(b))
+	case *keyFlag != "":
+		return sign.ParsePrivateKey(*keyFlag)
+	case *configFile != "":
+		b, err := os.ReadFile(*configFile)
+		if err != nil {
+			return nil, fmt.Errorf("reading config file: %w", err)
+		}
+		config := &config.Config{}
+		if err := yaml.Unmarshal(b, config); err != nil {
+			return nil, fmt.Errorf("parsing config file: %w", err)
+		}
+		if err := config.LoadSecrets(); err != nil {
+			return nil, fmt.Errorf("loading secrets: %w", err)
+		}
+		if config.PrivateKey == "" {
+			return nil, fmt.Errorf("private key is not specified in the config")
+		}
+		return sign.ParsePrivateKey(config.PrivateKey)
+	default:
+		return nil, fmt.Errorf("either --key or --config must be provided")
+	}
+}
+
+func readDIDDoc(ctx context.Context, location string) (*diddoc.Document, error) {
+	if strings.HasPrefix(location, "did:") {
+		return (&diddoc.HTTPResolver{}).Resolve(ctx, location)
+	}
+	if !strings.HasPrefix(location, "https://") && !strings.HasPrefix(location, "http://") {
+		b, err := os.ReadFile(location)
+		if err != nil {
+			return nil, fmt.Errorf("reading DID document: %w", err)
+		}
+		return diddoc.Parse(b)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, location, nil)
+	if err != nil {
+		return nil, fmt.Errorf("creating request object: %w", err)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("fetching DID document: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("fetching DID document: %s", resp.Status)
+	}
+	b, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("reading DID document: %w", err)
+	}
+	return diddoc.Parse(b)
+}
+
+func runMain(ctx context.Context, cmd string) error {
+	outFormat, err := sign.ParseKeyFormat(*format)
+	if err != nil {
+		return err
+	}
+
+	switch cmd {
+	case "generate":
+		key, err := sign.GenerateKey()
+		if err != nil {
+			return fmt.Errorf("generating a key: %w", err)
+		}
+		s, err := sign.EncodePrivateKey(key, outFormat)
+		if err != nil {
+			return err
+		}
+		fmt.Println(strings.TrimSpace(s))
+	case "public":
+		key, err := readKey()
+		if err != nil {
+			return fmt.Errorf("parsing private key: %w", err)
+		}
+		publicKey, err := sign.GetPublicKey(key)
+		if err != nil {
+			return fmt.Errorf("failed to get the public key: %w", err)
+		}
+		fmt.Println("did:key:" + publicKey)
+	case "convert":
+		key, err := readKey()
+		if err != nil {
+			return fmt.Errorf("parsing private key: %w", err)
+		}
+		s, err := sign.EncodePrivateKey(key, outFormat)
+		if err != nil {
+			return err
+		}
+		fmt.Println(strings.TrimSpace(s))
+	case "check":
+		if *didDoc == "" {
+			return fmt.Errorf("--did-doc is required")
+		}
+		key, err := readKey()
+		if err != nil {
+			return fmt.Errorf("parsing private key: %w", err)
+		}
+		publicKey, err := sign.GetPublicKey(key)
+		if err != nil {
+			return fmt.Errorf("failed to get the public key: %w", err)
+		}
+		expected, err := crypto.ParsePublicMultibase(publicKey)
+		if err != nil {
+			return fmt.Errorf("failed to parse our own public key: %w", err)
+		}
+
+		doc, err := readDIDDoc(ctx, *didDoc)
+		if err != nil {
+			return err
+		}
+		actual, err := doc.PublicKey("atproto_label")
+		if err != nil {
+			return fmt.Errorf("%s: %w", doc.ID, err)
+		}
+		if !expected.Equal(actual) {
+			return fmt.Errorf("key mismatch for %s:\n  private key: %s\n  DID document: %s",
+				doc.ID, expected.DIDKey(), actual.DIDKey())
+		}
+		fmt.Printf("OK: %s has atproto_label key %s\n", doc.ID, actual.DIDKey())
+	case "encrypt":
+		if *output == "" {
+			return fmt.Errorf("--out is required")
+		}
+		passphrase := os.Getenv("KEYSTORE_PASSPHRASE")
+		if *passFile != "" {
+			b, err := os.ReadFile(*passFile)
+			if err != nil {
+				return fmt.Errorf("reading passphrase file: %w", err)
+			}
+			passphrase = strings.TrimRight(string(b), "\r\n")
+		}
+		if passphrase == "" {
+			return fmt.Errorf("no passphrase provided, use --passphrase-file or KEYSTORE_PASSPHRASE environment variable")
+		}
+		key, err := readKey()
+		if err != nil {
+			return fmt.Errorf("parsing private key: %w", err)
+		}
+		b, err := sign.EncryptKeystore(key, passphrase)
+		if err != nil {
+			return fmt.Errorf("encrypting the key: %w", err)
+		}
+		if err := os.WriteFile(*output, append(b, '\n'), 0600); err != nil {
+			return fmt.Errorf("writing keystore: %w", err)
+		}
+	default:
+		usage()
+		return fmt.Errorf("unknown command %q", cmd)
+	}
+	return nil
+}
+
+func main() {
+	flag.Usage = usage
+	if len(os.Args) < 2 || strings.HasPrefix(os.Args[1], "-") {
+		usage()
+		os.Exit(2)
+	}
+	cmd := os.Args[1]
+	flag.CommandLine.Parse(os.Args[2:])
+
+	if err := runMain(context.Background(), cmd); err != nil {
+		fmt.Fprintf(os.Stderr, "%s\n", err)
+		os.Exit(1)
+	}
+}
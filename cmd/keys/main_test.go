@@ -0,0 +1,95 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const (
+	testKeyHex = "535f146a301147c72b1bf69c769d7e95d6107838dd30fdab61463b104e8426a7"
+	otherKey   = "c6d40ec53c689ca905036e41d8c73560777e5746d1d228fd6f9db56efed8ecaf"
+)
+
+func writeDIDDoc(t *testing.T, publicKey string) string {
+	t.Helper()
+	doc := `{
+  "id": "did:plc:labeler",
+  "verificationMethod": [{
+    "id": "did:plc:labeler#atproto_label",
+    "type": "Multikey",
+    "controller": "did:plc:labeler",
+    "publicKeyMultibase": "` + publicKey + `"
+  }]
+}`
+	path := filepath.Join(t.TempDir(), "did.json")
+	if err := os.WriteFile(path, []byte(doc), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// captureStdout returns what f prints to stdout.
+func captureStdout(t *testing.T, f func() error) (string, error) {
+	t.Helper()
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stdout := os.Stdout
+	os.Stdout = w
+	err = f()
+	os.Stdout = stdout
+	w.Close()
+	b := make([]byte, 64*1024)
+	n, _ := r.Read(b)
+	return string(b[:n]), err
+}
+
+func TestCheck(t *testing.T) {
+	ctx := context.Background()
+	*keyFlag = testKeyHex
+	defer func() { *keyFlag, *didDoc = "", "" }()
+
+	// Output of `keys public` is what should be in the DID document.
+	out, err := captureStdout(t, func() error { return runMain(ctx, "public") })
+	if err != nil {
+		t.Fatal(err)
+	}
+	publicKey, ok := strings.CutPrefix(strings.TrimSpace(out), "did:key:")
+	if !ok {
+		t.Fatalf("unexpected output %q", out)
+	}
+
+	*didDoc = writeDIDDoc(t, publicKey)
+	if _, err := captureStdout(t, func() error { return runMain(ctx, "check") }); err != nil {
+		t.Errorf("expected the key to match: %s", err)
+	}
+
+	*keyFlag = otherKey
+	if _, err := captureStdout(t, func() error { return runMain(ctx, "check") }); err == nil {
+		t.Errorf("expected a different key to be rejected")
+	}
+}
+
+func TestConvert(t *testing.T) {
+	ctx := context.Background()
+	defer func() { *keyFlag, *format = "", "hex" }()
+
+	// Converting between all the formats and back gives the same key.
+	key := testKeyHex
+	for _, f := range []string{"pem", "multibase", "hex"} {
+		*keyFlag = key
+		*format = f
+		out, err := captureStdout(t, func() error { return runMain(ctx, "convert") })
+		if err != nil {
+			t.Fatalf("converting to %s: %s", f, err)
+		}
+		key = strings.TrimSpace(out)
+	}
+	if key != testKeyHex {
+		t.Errorf("got %q after conversions, want %q", key, testKeyHex)
+	}
+}
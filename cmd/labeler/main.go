@@ -5,19 +5,21 @@ import (
 	"flag"
 	"fmt"
 	"net/http"
-	"os"
+	"time"
 
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"github.com/rs/zerolog"
-	"gopkg.in/yaml.v3"
 
-	"bsky.watch/utils/xrpcauth"
-
-	"bsky.watch/labeler/account"
-	"bsky.watch/labeler/config"
+	"bsky.watch/labeler/adminapi"
+	"bsky.watch/labeler/adminui"
+	"bsky.watch/labeler/auth"
+	"bsky.watch/labeler/diddoc"
+	"bsky.watch/labeler/idempotency"
 	"bsky.watch/labeler/logging"
+	"bsky.watch/labeler/ozoneapi"
 	"bsky.watch/labeler/server"
 	"bsky.watch/labeler/simpleapi"
+	"bsky.watch/labeler/sink"
 )
 
 var (
@@ -28,19 +30,19 @@ var (
 	logFile     = flag.String("log-file", "", "File to write the logs to. Will use stderr if not set")
 	logFormat   = flag.String("log-format", "text", "Log entry format, 'text' or 'json'.")
 	logLevel    = flag.Int("log-level", 1, "Log level. 0 - debug, 1 - info, 3 - error")
+	checkConfig = flag.Bool("check-config", false, "Validate the config file and exit")
 )
 
 func runMain(ctx context.Context) error {
 	log := zerolog.Ctx(ctx)
 
-	b, err := os.ReadFile(*configFile)
+	config, err := loadConfig(ctx, *configFile)
 	if err != nil {
-		return fmt.Errorf("reading config file: %w", err)
+		return err
 	}
-
-	config := &config.Config{}
-	if err := yaml.Unmarshal(b, config); err != nil {
-		return fmt.Errorf("parsing config file: %w", err)
+	if *checkConfig {
+		log.Info().Msgf("Config is valid")
+		return nil
 	}
 	server, err := server.NewWithConfig(ctx, config)
 	if err != nil {
@@ -49,21 +51,60 @@ func runMain(ctx context.Context) error {
 
 	server.SetAllowedLabels(config.LabelValues())
 
-	if config.Password != "" && len(config.Labels.LabelValueDefinitions) > 0 {
-		client := xrpcauth.NewClientWithTokenSource(ctx, xrpcauth.PasswordAuth(config.DID, config.Password))
-		err := account.UpdateLabelDefs(ctx, client, &config.Labels)
+	for i, cfg := range config.Sinks {
+		sink, err := sink.NewFromConfig(ctx, cfg)
 		if err != nil {
-			return fmt.Errorf("updating label definitions: %w", err)
+			return fmt.Errorf("creating sink #%d: %w", i+1, err)
 		}
+		if err := server.AddSink(ctx, sink); err != nil {
+			return fmt.Errorf("adding sink #%d: %w", i+1, err)
+		}
+	}
+
+	if err := updateLabelDefs(ctx, config); err != nil {
+		return err
 	}
 
+	reloader := &reloader{path: *configFile, server: server, config: config, publish: updateLabelDefs}
+	reloader.watchSignals(ctx)
+
+	// Runs even if no delays are configured, to process labels staged or scheduled before a restart.
+	go server.RunScheduler(ctx, 10*time.Second)
+
+	resolver := diddoc.NewCachingResolver(&diddoc.HTTPResolver{PLCURL: config.PLCURL}, 10*time.Minute)
+
 	if *adminAddr != "" {
 		frontend := simpleapi.New(server)
 		mux := http.NewServeMux()
 		mux.Handle("/label", frontend)
+		mux.Handle("/xrpc/tools.ozone.moderation.emitEvent", ozoneapi.NewEmitEvent(server))
+		mux.Handle("/api/", adminapi.New(server))
+		ui, err := adminui.New(server, "/ui")
+		if err != nil {
+			return fmt.Errorf("creating admin UI: %w", err)
+		}
+		mux.Handle("/ui/", ui)
+		mux.Handle("/reload", reloader)
+
+		authn, err := auth.NewFromConfig(config, resolver)
+		if err != nil {
+			return fmt.Errorf("setting up admin API authentication: %w", err)
+		}
+		// Bulk uploads are streamed, so they bypass idempotency handling that buffers
+		// whole requests and responses. Writing the same labels again is a no-op anyway.
+		root := http.NewServeMux()
+		root.Handle("/", idempotency.Middleware(server, config.IdempotencyWindow, mux))
+		root.Handle("/label/bulk", simpleapi.NewBulk(server))
+		var handler http.Handler = root
+		if authn != nil {
+			handler = auth.Middleware(authn, handler)
+		} else {
+			log.Warn().Msgf("Neither admin_tokens nor moderators are configured, admin API does not require authentication")
+		}
+		handler = auth.RejectCrossSite(handler)
 
 		go func() {
-			if err := http.ListenAndServe(*adminAddr, mux); err != nil {
+			if err := http.ListenAndServe(*adminAddr, handler); err != nil {
 				log.Fatal().Err(err).Msgf("Failed to start listening on admin API address: %s", err)
 			}
 		}()
@@ -83,6 +124,12 @@ func runMain(ctx context.Context) error {
 	mux := http.NewServeMux()
 	mux.Handle("/xrpc/com.atproto.label.subscribeLabels", server.Subscribe())
 	mux.Handle("/xrpc/com.atproto.label.queryLabels", server.Query())
+	if config.Reports.Enabled {
+		if config.DID == "" {
+			return fmt.Errorf("labeler DID must be set to accept reports")
+		}
+		mux.Handle("/xrpc/com.atproto.moderation.createReport", ozoneapi.NewCreateReport(server, config, resolver))
+	}
 
 	log.Info().Msgf("Starting HTTP listener...")
 	return http.ListenAndServe(*listenAddr, mux)
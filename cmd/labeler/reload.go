@@ -0,0 +1,187 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"os/signal"
+	"reflect"
+	"slices"
+	"sync"
+	"syscall"
+
+	"github.com/rs/zerolog"
+	"gopkg.in/yaml.v3"
+
+	"bsky.watch/utils/xrpcauth"
+
+	"bsky.watch/labeler/account"
+	"bsky.watch/labeler/auth"
+	"bsky.watch/labeler/config"
+	"bsky.watch/labeler/server"
+)
+
+func loadConfig(ctx context.Context, path string) (*config.Config, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading config file: %w", err)
+	}
+
+	cfg := &config.Config{}
+	if err := yaml.Unmarshal(b, cfg); err != nil {
+		return nil, fmt.Errorf("parsing config file: %w", err)
+	}
+	if err := cfg.LoadSecrets(); err != nil {
+		return nil, fmt.Errorf("loading secrets: %w", err)
+	}
+	warnings, err := cfg.Validate(config.RequireDatabase)
+	for _, w := range warnings {
+		zerolog.Ctx(ctx).Warn().Msgf("Config: %s", w)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("invalid config:\n%w", err)
+	}
+	cfg.UpdateLabelValues()
+	return cfg, nil
+}
+
+func updateLabelDefs(ctx context.Context, cfg *config.Config) error {
+	if cfg.Password == "" || len(cfg.Labels.LabelValueDefinitions) == 0 {
+		return nil
+	}
+	client := xrpcauth.NewClientWithTokenSource(ctx, xrpcauth.PasswordAuth(cfg.DID, cfg.Password))
+	if err := account.UpdateLabelDefs(ctx, client, &cfg.Labels); err != nil {
+		return fmt.Errorf("updating label definitions: %w", err)
+	}
+	return nil
+}
+
+// reloader re-reads the config file and applies the changes that
+// don't require a restart: allowed label values, label definitions,
+// expiration policy, subject rules, allowed system labels, exclusive groups,
+// approval policy, publication delays and negation of expired labels.
+type reloader struct {
+	path   string
+	server *server.Server
+	// publish is called with the new config when label definitions change.
+	publish func(ctx context.Context, cfg *config.Config) error
+
+	mu     sync.Mutex
+	config *config.Config
+}
+
+// reload applies the changes from the config file and returns their descriptions.
+func (r *reloader) reload(ctx context.Context) ([]string, error) {
+	log := zerolog.Ctx(ctx)
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	cfg, err := loadConfig(ctx, r.path)
+	if err != nil {
+		return nil, err
+	}
+
+	diff := config.Diff(r.config, cfg)
+	if len(diff) == 0 {
+		log.Info().Msgf("Config reloaded, no changes")
+		return diff, nil
+	}
+	for _, d := range diff {
+		log.Info().Msgf("Config change: %s", d)
+	}
+
+	if changed := config.ChangedFields(r.config, cfg); len(changed) > 0 {
+		log.Warn().Strs("fields", changed).Msgf("Changes to these fields will take effect only after a restart: %v", changed)
+	}
+
+	if config.LabelDefsChanged(r.config, cfg) {
+		if err := r.publish(ctx, cfg); err != nil {
+			return nil, err
+		}
+	}
+	if !slices.Equal(r.config.LabelValues(), cfg.LabelValues()) {
+		r.server.SetAllowedLabels(cfg.LabelValues())
+	}
+	if !reflect.DeepEqual(r.config.Expiration, cfg.Expiration) {
+		r.server.SetExpiration(cfg.Expiration)
+	}
+	if !reflect.DeepEqual(r.config.Subjects, cfg.Subjects) {
+		r.server.SetSubjectRules(cfg.Subjects)
+	}
+	if !reflect.DeepEqual(r.config.SystemLabels, cfg.SystemLabels) {
+		r.server.SetSystemLabels(cfg.SystemLabels)
+	}
+	if !reflect.DeepEqual(r.config.ExclusiveGroups, cfg.ExclusiveGroups) {
+		r.server.SetExclusiveGroups(cfg.ExclusiveGroups)
+	}
+	if !reflect.DeepEqual(r.config.Approval, cfg.Approval) {
+		r.server.SetApprovalPolicy(cfg.Approval)
+	}
+	if !reflect.DeepEqual(r.config.Delay, cfg.Delay) {
+		r.server.SetPublicationDelay(cfg.Delay)
+	}
+	if r.config.NegateExpired != cfg.NegateExpired {
+		r.server.SetNegateExpired(cfg.NegateExpired)
+	}
+
+	// Keep the fields that were not applied, so that they're reported again on the next reload.
+	updated := *r.config
+	updated.Labels = cfg.Labels
+	updated.Expiration = cfg.Expiration
+	updated.Subjects = cfg.Subjects
+	updated.SystemLabels = cfg.SystemLabels
+	updated.ExclusiveGroups = cfg.ExclusiveGroups
+	updated.Approval = cfg.Approval
+	updated.Delay = cfg.Delay
+	updated.NegateExpired = cfg.NegateExpired
+	r.config = &updated
+	return diff, nil
+}
+
+// watchSignals calls reload every time the process receives SIGHUP.
+func (r *reloader) watchSignals(ctx context.Context) {
+	log := zerolog.Ctx(ctx)
+	ch := make(chan os.Signal, 1)
+	signal.Notify(ch, syscall.SIGHUP)
+	go func() {
+		for {
+			select {
+			case <-ctx.Done():
+				signal.Stop(ch)
+				return
+			case <-ch:
+				log.Info().Msgf("Received SIGHUP, reloading config")
+				if _, err := r.reload(ctx); err != nil {
+					log.Error().Err(err).Msgf("Failed to reload config: %s", err)
+				}
+			}
+		}
+	}()
+}
+
+// ServeHTTP reloads the config on POST requests. Only principals allowed to use
+// any label can do it.
+func (r *reloader) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if p := auth.FromContext(req.Context()); p != nil && !p.CanLabel("*") {
+		http.Error(w, "not allowed to reload config", http.StatusForbidden)
+		return
+	}
+	diff, err := r.reload(req.Context())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	if len(diff) == 0 {
+		fmt.Fprintln(w, "no changes")
+		return
+	}
+	for _, d := range diff {
+		fmt.Fprintln(w, d)
+	}
+}
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"bsky.watch/labeler/config"
+	"bsky.watch/labeler/server"
+)
+
+const baseConfig = `
+private_key: c6d40ec53c689ca905036e41d8c73560777e5746d1d228fd6f9db56efed8ecaf
+did: did:plc:labeler
+sqlite_db: /data/labels.db
+labels:
+  labelvalues: [porn]
+  labelvaluedefinitions:
+    - identifier: spam
+      severity: alert
+      blurs: none
+      locales:
+        - lang: en
+          name: Spam
+          description: Spam
+`
+
+type testReloader struct {
+	*reloader
+	published int
+	fail      bool
+}
+
+func newTestReloader(t *testing.T) *testReloader {
+	t.Helper()
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(path, []byte(baseConfig), 0600); err != nil {
+		t.Fatal(err)
+	}
+	cfg, err := loadConfig(ctx, path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err := server.NewWithConfig(ctx, &config.Config{
+		SQLiteDB:   fmt.Sprintf("file:%s?mode=memory&cache=shared", t.Name()),
+		DID:        cfg.DID,
+		PrivateKey: cfg.PrivateKey,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.SetAllowedLabels(cfg.LabelValues())
+
+	r := &testReloader{}
+	r.reloader = &reloader{path: path, server: s, config: cfg, publish: func(ctx context.Context, cfg *config.Config) error {
+		if r.fail {
+			return fmt.Errorf("PDS is unavailable")
+		}
+		r.published++
+		return nil
+	}}
+	return r
+}
+
+func (r *testReloader) write(t *testing.T, s string) {
+	t.Helper()
+	if err := os.WriteFile(r.path, []byte(s), 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func (r *testReloader) reload(t *testing.T, want ...string) {
+	t.Helper()
+	diff, err := r.reloader.reload(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(diff, want) {
+		t.Errorf("got changes %q, want %q", diff, want)
+	}
+}
+
+func TestReload(t *testing.T) {
+	r := newTestReloader(t)
+
+	r.reload(t)
+	if r.published != 0 {
+		t.Errorf("definitions were published without changes")
+	}
+
+	// Label values only.
+	r.write(t, strings.Replace(baseConfig, "[porn]", "[porn, gore]", 1))
+	r.reload(t, `label value "gore" added`)
+	if r.published != 0 {
+		t.Errorf("definitions were published after changing only label values")
+	}
+	if got, want := r.server.AllowedLabels(), []string{"gore", "porn", "spam"}; !slices.Equal(got, want) {
+		t.Errorf("allowed labels are %q, want %q", got, want)
+	}
+
+	// Definitions.
+	r.write(t, strings.Replace(baseConfig, "severity: alert", "severity: inform", 1))
+	r.reload(t, `label value "gore" removed`, `label definition "spam" changed`)
+	if r.published != 1 {
+		t.Errorf("definitions were published %d times, want 1", r.published)
+	}
+	if got, want := r.server.AllowedLabels(), []string{"porn", "spam"}; !slices.Equal(got, want) {
+		t.Errorf("allowed labels are %q, want %q", got, want)
+	}
+}
+
+func TestReloadRestartRequired(t *testing.T) {
+	r := newTestReloader(t)
+
+	// Not applied, so reported on every reload until a restart.
+	r.write(t, baseConfig+"endpoint: https://labeler.example.com\n")
+	r.reload(t, "`endpoint` changed")
+	r.reload(t, "`endpoint` changed")
+	if r.config.Endpoint != "" {
+		t.Errorf("endpoint was changed without a restart")
+	}
+}
+
+func TestReloadErrors(t *testing.T) {
+	r := newTestReloader(t)
+
+	r.write(t, baseConfig+"delay:\n  spam: -1h\n")
+	if _, err := r.reloader.reload(context.Background()); err == nil {
+		t.Errorf("expected an invalid config to be rejected")
+	}
+
+	// Nothing is applied if definitions can't be published, and the change is retried on the next reload.
+	r.fail = true
+	r.write(t, strings.Replace(strings.Replace(baseConfig, "severity: alert", "severity: inform", 1), "[porn]", "[porn, gore]", 1))
+	if _, err := r.reloader.reload(context.Background()); err == nil {
+		t.Errorf("expected an error")
+	}
+	if slices.Contains(r.server.AllowedLabels(), "gore") {
+		t.Errorf("label values were changed after a failed reload")
+	}
+	r.fail = false
+	r.reload(t, `label value "gore" added`, `label definition "spam" changed`)
+	if r.published != 1 {
+		t.Errorf("definitions were published %d times, want 1", r.published)
+	}
+}
+
+func TestReloadHandler(t *testing.T) {
+	r := newTestReloader(t)
+	r.write(t, strings.Replace(baseConfig, "[porn]", "[porn, gore]", 1))
+
+	w := httptest.NewRecorder()
+	r.reloader.ServeHTTP(w, httptest.NewRequest("GET", "/reload", nil))
+	if w.Code != http.StatusMethodNotAllowed {
+		t.Errorf("expected status %d, got %d", http.StatusMethodNotAllowed, w.Code)
+	}
+
+	w = httptest.NewRecorder()
+	r.reloader.ServeHTTP(w, httptest.NewRequest("POST", "/reload", nil))
+	if w.Code != http.StatusOK || w.Body.String() != "label value \"gore\" added\n" {
+		t.Errorf("unexpected response %d: %q", w.Code, w.Body)
+	}
+}
@@ -32,6 +32,7 @@ var (
 	logFormat      = flag.String("log-format", "text", "Log entry format, 'text' or 'json'.")
 	logLevel       = flag.Int("log-level", 1, "Log level. 0 - debug, 1 - info, 3 - error")
 	updateInterval = flag.Duration("update-interval", time.Hour, "Interval between updates")
+	checkConfig    = flag.Bool("check-config", false, "Validate the config file and exit")
 )
 
 type Config struct {
@@ -48,30 +49,45 @@ func runMain(ctx context.Context) error {
 		return fmt.Errorf("reading config file: %w", err)
 	}
 
-	config := &Config{}
-	if err := yaml.Unmarshal(b, config); err != nil {
+	cfg := &Config{}
+	if err := yaml.Unmarshal(b, cfg); err != nil {
 		return fmt.Errorf("parsing config file: %w", err)
 	}
-	server, err := server.NewWithConfig(ctx, &config.Config)
+	if err := cfg.LoadSecrets(); err != nil {
+		return fmt.Errorf("loading secrets: %w", err)
+	}
+	warnings, err := cfg.Validate(config.RequireDatabase, config.RequireDID, config.RequirePassword)
+	for _, w := range warnings {
+		log.Warn().Msgf("Config: %s", w)
+	}
+	if err != nil {
+		return fmt.Errorf("invalid config:\n%w", err)
+	}
+	if *checkConfig {
+		log.Info().Msgf("Config is valid")
+		return nil
+	}
+	server, err := server.NewWithConfig(ctx, &cfg.Config)
 	if err != nil {
 		return fmt.Errorf("instantiating a server: %w", err)
 	}
-	server.SetAllowedLabels(config.LabelValues())
+	server.SetAllowedLabels(cfg.LabelValues())
+	go server.RunScheduler(ctx, 10*time.Second)
 
-	if config.Password == "" {
+	if cfg.Password == "" {
 		return fmt.Errorf("no password provided in the config file")
 	}
 
-	client := xrpcauth.NewClientWithTokenSource(ctx, xrpcauth.PasswordAuth(config.DID, config.Password))
+	client := xrpcauth.NewClientWithTokenSource(ctx, xrpcauth.PasswordAuth(cfg.DID, cfg.Password))
 
-	if config.Password != "" && len(config.Labels.LabelValueDefinitions) > 0 {
-		err := account.UpdateLabelDefs(ctx, client, &config.Labels)
+	if cfg.Password != "" && len(cfg.Labels.LabelValueDefinitions) > 0 {
+		err := account.UpdateLabelDefs(ctx, client, &cfg.Labels)
 		if err != nil {
 			return fmt.Errorf("updating label definitions: %w", err)
 		}
 	}
 
-	startListUpdates(ctx, client, config, server, *updateInterval)
+	startListUpdates(ctx, client, cfg, server, *updateInterval)
 
 	if *metricsAddr != "" {
 		mux := http.NewServeMux()
@@ -139,7 +139,7 @@ func labelWriter(ctx context.Context, labeler *server.Server) {
 		default:
 		}
 
-		subject := fmt.Sprintf("at://did:example:subject/collection/%d", rand.Int())
+		subject := fmt.Sprintf("at://did:example:subject/app.bsky.feed.post/%d", rand.Int())
 		added, err := labeler.AddLabel(ctx, atproto.LabelDefs_Label{
 			Uri: subject,
 			Val: "test",
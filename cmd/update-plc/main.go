@@ -15,8 +15,9 @@ import (
 )
 
 var (
-	configFile = flag.String("config", "config.yaml", "Path to the config file")
-	token      = flag.String("token", "", "Token that PDS requires to sign PLC operations")
+	configFile  = flag.String("config", "config.yaml", "Path to the config file")
+	token       = flag.String("token", "", "Token that PDS requires to sign PLC operations")
+	checkConfig = flag.Bool("check-config", false, "Validate the config file and exit")
 )
 
 func runMain(ctx context.Context) error {
@@ -25,16 +26,30 @@ func runMain(ctx context.Context) error {
 		return fmt.Errorf("reading config file: %w", err)
 	}
 
-	config := &config.Config{}
-	if err := yaml.Unmarshal(b, config); err != nil {
+	cfg := &config.Config{}
+	if err := yaml.Unmarshal(b, cfg); err != nil {
 		return fmt.Errorf("parsing config file: %w", err)
 	}
+	if err := cfg.LoadSecrets(); err != nil {
+		return fmt.Errorf("loading secrets: %w", err)
+	}
+	warnings, err := cfg.Validate(config.RequireDID, config.RequirePassword)
+	for _, w := range warnings {
+		fmt.Fprintf(os.Stderr, "Warning: %s\n", w)
+	}
+	if err != nil {
+		return fmt.Errorf("invalid config:\n%w", err)
+	}
+	if *checkConfig {
+		fmt.Fprintln(os.Stderr, "Config is valid")
+		return nil
+	}
 
-	if config.Password == "" {
+	if cfg.Password == "" {
 		return fmt.Errorf("password is not specified in the config")
 	}
 
-	key, err := sign.ParsePrivateKey(config.PrivateKey)
+	key, err := sign.ParsePrivateKey(cfg.PrivateKey)
 	if err != nil {
 		return fmt.Errorf("parsing private key: %w", err)
 	}
@@ -43,9 +58,9 @@ func runMain(ctx context.Context) error {
 		return fmt.Errorf("failed to get the public key: %w", err)
 	}
 
-	client := xrpcauth.NewClientWithTokenSource(ctx, xrpcauth.PasswordAuth(config.DID, config.Password))
+	client := xrpcauth.NewClientWithTokenSource(ctx, xrpcauth.PasswordAuth(cfg.DID, cfg.Password))
 
-	err = account.UpdateSigningKeyAndEndpoint(ctx, client, *token, publicKey, config.Endpoint)
+	err = account.UpdateSigningKeyAndEndpoint(ctx, client, *token, publicKey, cfg.Endpoint)
 	if err != nil {
 		if *token == "" {
 			fmt.Fprintln(os.Stderr, "If you need to provide a token, re-run this command with --token=YOUR-TOKEN flag")
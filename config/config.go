@@ -1,6 +1,10 @@
 package config
 
-import "github.com/bluesky-social/indigo/api/bsky"
+import (
+	"time"
+
+	"github.com/bluesky-social/indigo/api/bsky"
+)
 
 type Config struct {
 	DBFile      string                           `yaml:"db_file"`
@@ -11,6 +15,140 @@ type Config struct {
 	Password    string                           `yaml:"password"`
 	Endpoint    string                           `yaml:"endpoint"`
 	Labels      bsky.LabelerDefs_LabelerPolicies `yaml:"labels"`
+
+	// Expiration sets expiration policy for label values. Policy for "*"
+	// applies to all values that aren't listed explicitly.
+	Expiration map[string]Expiration `yaml:"expiration"`
+	// Subjects restricts what label values can be applied to. Rule for "*"
+	// applies to all values that aren't listed explicitly.
+	Subjects map[string]SubjectRule `yaml:"subjects"`
+	// SystemLabels lists `!`-prefixed label values that can be applied.
+	// If not set, "!hide", "!warn" and "!no-unauthenticated" are allowed.
+	// Set to an empty list to disallow all of them.
+	SystemLabels []string `yaml:"system_labels"`
+	// ExclusiveGroups are named groups of label values that a subject can have
+	// at most one of. Applying a value negates the others in the same group.
+	ExclusiveGroups map[string][]string `yaml:"exclusive_groups"`
+	// Approval configures label values that need to be approved by a second moderator.
+	Approval Approval `yaml:"approval"`
+	// Delay sets how long new labels are held back before being published, so
+	// that they can be cancelled. Delay for "*" applies to all values that
+	// aren't listed explicitly.
+	Delay map[string]time.Duration `yaml:"delay"`
+	// NegateExpired enables writing explicit negations for labels once their
+	// expiration time passes, for consumers that don't check `exp`.
+	NegateExpired bool `yaml:"negate_expired"`
+
+	// Alternative sources of secrets, see LoadSecrets.
+	PrivateKeyFile         string `yaml:"private_key_file"`
+	PasswordFile           string `yaml:"password_file"`
+	Keystore               string `yaml:"keystore"`
+	KeystorePassphrase     string `yaml:"keystore_passphrase"`
+	KeystorePassphraseFile string `yaml:"keystore_passphrase_file"`
+
+	// AdminTokens lists bearer tokens accepted by the admin API.
+	// If both AdminTokens and Moderators are empty, the admin API doesn't
+	// require authentication.
+	AdminTokens []AdminToken `yaml:"admin_tokens"`
+	// Moderators lists accounts that can access the admin API using
+	// ATproto service auth tokens.
+	Moderators []Moderator `yaml:"moderators"`
+	// Roles define permissions for admin tokens and moderators.
+	Roles map[string]Role `yaml:"roles"`
+
+	// IdempotencyWindow is how long responses to admin API requests with
+	// Idempotency-Key header are kept for replaying. Defaults to 24 hours.
+	IdempotencyWindow time.Duration `yaml:"idempotency_window"`
+
+	// Reports configures intake of user reports.
+	Reports Reports `yaml:"reports"`
+
+	// Sinks receive all label changes after they are written.
+	Sinks []Sink `yaml:"sinks"`
+
+	// PLCURL overrides the URL of PLC directory used to resolve DIDs.
+	PLCURL string `yaml:"plc_url"`
+}
+
+type Expiration struct {
+	// Default is the expiration time of labels written without one.
+	Default time.Duration `yaml:"default"`
+	// Max limits how far in the future labels can expire. Labels written
+	// with a later expiration time get it reduced to Max. If Default is not
+	// set, it is also used for labels written without expiration.
+	Max time.Duration `yaml:"max"`
+}
+
+// SubjectRule restricts what subjects a label value can be applied to.
+// Negations are not restricted.
+type SubjectRule struct {
+	// Types lists allowed subject types, "account" (DID) and/or "record" (AT URI).
+	// If empty, both are allowed.
+	Types []string `yaml:"types"`
+	// Collections lists NSIDs of the collections records must belong to.
+	// If empty, records from any collection are allowed.
+	Collections []string `yaml:"collections"`
+	// RequireCID requires labels on records to have a CID.
+	RequireCID bool `yaml:"require_cid"`
+}
+
+type Approval struct {
+	// Labels lists label values that, when written through the admin APIs, are
+	// only applied or negated after being approved by a different moderator.
+	Labels []string `yaml:"labels"`
+	// Expiry is how long requests wait for approval. Defaults to 24 hours.
+	Expiry time.Duration `yaml:"expiry"`
+}
+
+type AdminToken struct {
+	Name      string `yaml:"name"`
+	Token     string `yaml:"token"`
+	TokenFile string `yaml:"token_file"`
+	// Labels lists label values that can be applied or negated using this token.
+	// "*" allows any label value.
+	Labels []string `yaml:"labels"`
+	// Role grants permissions of the role in addition to Labels.
+	Role string `yaml:"role"`
+}
+
+type Reports struct {
+	// Enabled makes the labeler accept reports via com.atproto.moderation.createReport.
+	Enabled bool `yaml:"enabled"`
+	// RateLimits are applied to each reporter separately. If empty, default limits are used.
+	RateLimits []RateLimit `yaml:"rate_limits"`
+}
+
+// RateLimit allows up to Count requests in any time interval of length Period.
+type RateLimit struct {
+	Count  int           `yaml:"count"`
+	Period time.Duration `yaml:"period"`
+}
+
+type Sink struct {
+	// Type is either "webhook" or "file".
+	Type string `yaml:"type"`
+
+	// URL to send webhook requests to.
+	URL string `yaml:"url"`
+	// Secret is used to sign webhook requests.
+	Secret     string `yaml:"secret"`
+	SecretFile string `yaml:"secret_file"`
+	// Outbox is a path to the file where undelivered webhook requests are stored.
+	Outbox string `yaml:"outbox"`
+
+	// Path to the file to append label changes to.
+	Path string `yaml:"path"`
+}
+
+type Moderator struct {
+	DID  string `yaml:"did"`
+	Role string `yaml:"role"`
+}
+
+type Role struct {
+	// Labels lists label values that can be applied or negated.
+	// "*" allows any label value.
+	Labels []string `yaml:"labels"`
 }
 
 // UpdateLabelValues ensures that all labels defined in c.Labels.LabelValueDefinitions
@@ -0,0 +1,101 @@
+package config
+
+import (
+	"fmt"
+	"reflect"
+	"slices"
+	"strings"
+)
+
+// reloadableFields are top-level fields that can be changed without a restart.
+var reloadableFields = []string{"labels", "expiration", "subjects", "system_labels", "exclusive_groups", "approval", "delay", "negate_expired"}
+
+// Diff returns human-readable descriptions of the differences between two configs.
+// Values of the fields other than labels are not included, since they might contain secrets.
+func Diff(old *Config, new *Config) []string {
+	r := []string{}
+
+	oldValues, newValues := old.LabelValues(), new.LabelValues()
+	for _, v := range newValues {
+		if !slices.Contains(oldValues, v) {
+			r = append(r, fmt.Sprintf("label value %q added", v))
+		}
+	}
+	for _, v := range oldValues {
+		if !slices.Contains(newValues, v) {
+			r = append(r, fmt.Sprintf("label value %q removed", v))
+		}
+	}
+
+	oldDefs := map[string]any{}
+	for _, d := range old.Labels.LabelValueDefinitions {
+		oldDefs[d.Identifier] = d
+	}
+	newDefs := map[string]any{}
+	for _, d := range new.Labels.LabelValueDefinitions {
+		newDefs[d.Identifier] = d
+		o, found := oldDefs[d.Identifier]
+		switch {
+		case !found:
+			r = append(r, fmt.Sprintf("label definition %q added", d.Identifier))
+		case !reflect.DeepEqual(o, d):
+			r = append(r, fmt.Sprintf("label definition %q changed", d.Identifier))
+		}
+	}
+	for _, d := range old.Labels.LabelValueDefinitions {
+		if _, found := newDefs[d.Identifier]; !found {
+			r = append(r, fmt.Sprintf("label definition %q removed", d.Identifier))
+		}
+	}
+
+	if !reflect.DeepEqual(old.Expiration, new.Expiration) {
+		r = append(r, "`expiration` changed")
+	}
+	if !reflect.DeepEqual(old.Subjects, new.Subjects) {
+		r = append(r, "`subjects` changed")
+	}
+	if !reflect.DeepEqual(old.SystemLabels, new.SystemLabels) {
+		r = append(r, "`system_labels` changed")
+	}
+	if !reflect.DeepEqual(old.ExclusiveGroups, new.ExclusiveGroups) {
+		r = append(r, "`exclusive_groups` changed")
+	}
+	if !reflect.DeepEqual(old.Approval, new.Approval) {
+		r = append(r, "`approval` changed")
+	}
+	if !reflect.DeepEqual(old.Delay, new.Delay) {
+		r = append(r, "`delay` changed")
+	}
+	if old.NegateExpired != new.NegateExpired {
+		r = append(r, "`negate_expired` changed")
+	}
+
+	for _, name := range ChangedFields(old, new) {
+		r = append(r, fmt.Sprintf("`%s` changed", name))
+	}
+	return r
+}
+
+// LabelDefsChanged reports whether label value definitions differ between two
+// configs and need to be published again.
+func LabelDefsChanged(old *Config, new *Config) bool {
+	return !reflect.DeepEqual(old.Labels.LabelValueDefinitions, new.Labels.LabelValueDefinitions)
+}
+
+// ChangedFields returns the names of top-level fields that differ between two
+// configs and require a restart to take effect.
+func ChangedFields(old *Config, new *Config) []string {
+	r := []string{}
+	o, n := reflect.ValueOf(*old), reflect.ValueOf(*new)
+	t := o.Type()
+	for i := 0; i < t.NumField(); i++ {
+		name, _, _ := strings.Cut(t.Field(i).Tag.Get("yaml"), ",")
+		if name == "" || name == "-" || slices.Contains(reloadableFields, name) {
+			continue
+		}
+		if !reflect.DeepEqual(o.Field(i).Interface(), n.Field(i).Interface()) {
+			r = append(r, name)
+		}
+	}
+	return r
+}
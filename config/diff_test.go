@@ -0,0 +1,173 @@
+package config
+
+import (
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDiff(t *testing.T) {
+	tests := []struct {
+		name   string
+		modify func(c *Config)
+		want   []string
+	}{
+		{
+			name: "no changes",
+			want: []string{},
+		},
+		{
+			name:   "label value added",
+			modify: func(c *Config) { c.Labels.LabelValues = append(c.Labels.LabelValues, ptr("gore")) },
+			want:   []string{`label value "gore" added`},
+		},
+		{
+			name:   "label value removed",
+			modify: func(c *Config) { c.Labels.LabelValues = c.Labels.LabelValues[1:] },
+			want:   []string{`label value "spam" removed`},
+		},
+		{
+			name: "label definition added",
+			modify: func(c *Config) {
+				c.Labels.LabelValueDefinitions = append(c.Labels.LabelValueDefinitions, labelDef("rude"))
+				c.UpdateLabelValues()
+			},
+			want: []string{`label value "rude" added`, `label definition "rude" added`},
+		},
+		{
+			name:   "label definition changed",
+			modify: func(c *Config) { c.Labels.LabelValueDefinitions[1].Locales[0].Description = "Scam" },
+			want:   []string{`label definition "scam" changed`},
+		},
+		{
+			name:   "label definition removed",
+			modify: func(c *Config) { c.Labels.LabelValueDefinitions = c.Labels.LabelValueDefinitions[:1] },
+			want:   []string{`label definition "scam" removed`},
+		},
+		{
+			name: "reloadable fields",
+			modify: func(c *Config) {
+				c.Expiration = map[string]Expiration{"spam": {Max: time.Hour}}
+				c.Subjects = map[string]SubjectRule{"spam": {Types: []string{"account"}}}
+				c.SystemLabels = []string{"!hide"}
+				c.ExclusiveGroups = map[string][]string{"kind": {"spam", "scam"}}
+				c.Approval.Expiry = time.Hour
+				c.Delay = map[string]time.Duration{"*": time.Minute}
+				c.NegateExpired = true
+			},
+			want: []string{
+				"`expiration` changed", "`subjects` changed", "`system_labels` changed",
+				"`exclusive_groups` changed", "`approval` changed", "`delay` changed", "`negate_expired` changed",
+			},
+		},
+		{
+			name: "fields that require a restart",
+			modify: func(c *Config) {
+				c.Endpoint = "https://labeler.example.com"
+				c.Moderators = []Moderator{{DID: "did:plc:mod", Role: "mod"}}
+			},
+			want: []string{"`endpoint` changed", "`moderators` changed"},
+		},
+		{
+			name: "secrets",
+			modify: func(c *Config) {
+				c.Password = "hunter2"
+				c.AdminTokens = []AdminToken{{Name: "a", Token: "secret-token"}}
+			},
+			want: []string{"`password` changed", "`admin_tokens` changed"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			old, new := validConfig(), validConfig()
+			if test.modify != nil {
+				test.modify(new)
+			}
+			got := Diff(old, new)
+			if !slices.Equal(got, test.want) {
+				t.Errorf("got %q, want %q", got, test.want)
+			}
+			for _, d := range got {
+				if strings.Contains(d, "hunter2") || strings.Contains(d, "secret-token") {
+					t.Errorf("secret value in %q", d)
+				}
+			}
+		})
+	}
+}
+
+func TestChangedFields(t *testing.T) {
+	old, new := validConfig(), validConfig()
+	new.Labels.LabelValues = append(new.Labels.LabelValues, ptr("gore"))
+	new.Delay = map[string]time.Duration{"*": time.Minute}
+	new.NegateExpired = true
+	if got := ChangedFields(old, new); len(got) != 0 {
+		t.Errorf("reloadable fields are reported as requiring a restart: %q", got)
+	}
+
+	new.SQLiteDB = "/data/other.db"
+	new.Sinks = []Sink{{Type: "file", Path: "/data/labels.jsonl"}}
+	new.Reports.Enabled = true
+	want := []string{"sqlite_db", "reports", "sinks"}
+	if got := ChangedFields(old, new); !slices.Equal(got, want) {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestLabelDefsChanged(t *testing.T) {
+	tests := []struct {
+		name   string
+		modify func(c *Config)
+		want   bool
+	}{
+		{
+			name: "no changes",
+		},
+		{
+			name:   "label value added",
+			modify: func(c *Config) { c.Labels.LabelValues = append(c.Labels.LabelValues, ptr("gore")) },
+		},
+		{
+			name: "other fields",
+			modify: func(c *Config) {
+				c.Delay = map[string]time.Duration{"spam": time.Hour}
+				c.Endpoint = "https://example.com"
+			},
+		},
+		{
+			name:   "definition changed",
+			modify: func(c *Config) { c.Labels.LabelValueDefinitions[0].Severity = "inform" },
+			want:   true,
+		},
+		{
+			name: "definition added",
+			modify: func(c *Config) {
+				c.Labels.LabelValueDefinitions = append(c.Labels.LabelValueDefinitions, labelDef("rude"))
+			},
+			want: true,
+		},
+		{
+			name: "definitions reordered",
+			modify: func(c *Config) {
+				defs := c.Labels.LabelValueDefinitions
+				defs[0], defs[1] = defs[1], defs[0]
+			},
+			// Order is visible to users.
+			want: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			old, new := validConfig(), validConfig()
+			if test.modify != nil {
+				test.modify(new)
+			}
+			if got := LabelDefsChanged(old, new); got != test.want {
+				t.Errorf("got %v, want %v", got, test.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,103 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+
+	"bsky.watch/labeler/sign"
+)
+
+var envRef = regexp.MustCompile(`^\$\{([A-Za-z_][A-Za-z0-9_]*)\}$`)
+
+// LoadSecrets populates c.PrivateKey and c.Password from the alternative sources,
+// so that they don't have to be stored in the config file in plaintext:
+//
+//   - `private_key`, `password` and `keystore_passphrase` can be set to
+//     `${NAME}` to take the value from the environment variable NAME.
+//   - `private_key_file`, `password_file` and `keystore_passphrase_file`
+//     point to files containing the corresponding value.
+//   - `keystore` points to a passphrase-protected keystore file with the private key
+//     (see `keys encrypt` command).
+//
+// Admin API tokens are resolved the same way, from `token` or `token_file`,
+// as well as webhook secrets, from `secret` or `secret_file`.
+//
+// Only one source can be used for each of the values.
+func (c *Config) LoadSecrets() error {
+	var err error
+
+	c.Password, err = resolveSecret("password", c.Password, c.PasswordFile)
+	if err != nil {
+		return err
+	}
+
+	c.PrivateKey, err = resolveSecret("private_key", c.PrivateKey, c.PrivateKeyFile)
+	if err != nil {
+		return err
+	}
+
+	for i := range c.AdminTokens {
+		t := &c.AdminTokens[i]
+		t.Token, err = resolveSecret("token", t.Token, t.TokenFile)
+		if err != nil {
+			return fmt.Errorf("admin token %q: %w", t.Name, err)
+		}
+	}
+
+	for i := range c.Sinks {
+		sink := &c.Sinks[i]
+		sink.Secret, err = resolveSecret("secret", sink.Secret, sink.SecretFile)
+		if err != nil {
+			return fmt.Errorf("sink #%d: %w", i+1, err)
+		}
+	}
+
+	if c.Keystore != "" {
+		if c.PrivateKey != "" {
+			return fmt.Errorf("only one of private_key, private_key_file and keystore can be specified")
+		}
+		passphrase, err := resolveSecret("keystore_passphrase", c.KeystorePassphrase, c.KeystorePassphraseFile)
+		if err != nil {
+			return err
+		}
+		if passphrase == "" {
+			return fmt.Errorf("keystore requires keystore_passphrase or keystore_passphrase_file to be set")
+		}
+		b, err := os.ReadFile(c.Keystore)
+		if err != nil {
+			return fmt.Errorf("reading keystore: %w", err)
+		}
+		key, err := sign.DecryptKeystore(b, passphrase)
+		if err != nil {
+			return fmt.Errorf("decrypting keystore %q: %w", c.Keystore, err)
+		}
+		c.PrivateKey, err = sign.EncodePrivateKey(key, sign.FormatHex)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func resolveSecret(name string, value string, file string) (string, error) {
+	if value != "" && file != "" {
+		return "", fmt.Errorf("only one of %s and %s_file can be specified", name, name)
+	}
+	if file != "" {
+		b, err := os.ReadFile(file)
+		if err != nil {
+			return "", fmt.Errorf("reading %s_file: %w", name, err)
+		}
+		return strings.TrimRight(string(b), "\r\n"), nil
+	}
+	if m := envRef.FindStringSubmatch(value); m != nil {
+		v, ok := os.LookupEnv(m[1])
+		if !ok {
+			return "", fmt.Errorf("%s refers to environment variable %s, which is not set", name, m[1])
+		}
+		return v, nil
+	}
+	return value, nil
+}
@@ -0,0 +1,326 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"regexp"
+	"slices"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/bluesky-social/indigo/atproto/syntax"
+
+	"bsky.watch/labeler/sign"
+)
+
+// Requirement is a field that a command needs in addition to the ones
+// always required by Validate.
+type Requirement int
+
+const (
+	// RequireDatabase requires `sqlite_db` or `postgres_url` to be set.
+	RequireDatabase Requirement = iota
+	// RequirePassword requires the account password to be set.
+	RequirePassword
+	// RequireDID requires `did` to be set.
+	RequireDID
+)
+
+// Label values defined by the ATproto spec that don't need a definition.
+var globalLabelValues = []string{"!hide", "!warn", "!no-unauthenticated", "porn", "sexual", "nudity", "graphic-media", "gore"}
+
+var labelIdentifier = regexp.MustCompile(`^[a-z-]+$`)
+
+// Validate checks the config for problems and returns them as a single error,
+// with one line per problem. Warnings are returned for things that are likely
+// a mistake, but don't prevent the labeler from running.
+//
+// `private_key` is always required, and other fields are validated only if set. Validate should be called after LoadSecrets.
+func (c *Config) Validate(required ...Requirement) (warnings []string, err error) {
+	var errs []error
+	fail := func(field string, format string, args ...any) {
+		errs = append(errs, fmt.Errorf("%s: %s", field, fmt.Sprintf(format, args...)))
+	}
+	warn := func(field string, format string, args ...any) {
+		warnings = append(warnings, fmt.Sprintf("%s: %s", field, fmt.Sprintf(format, args...)))
+	}
+
+	switch {
+	case c.DID == "" && slices.Contains(required, RequireDID):
+		fail("did", "must be set to the DID of the labeler account")
+	case c.DID == "" && c.Reports.Enabled:
+		fail("did", "must be set to accept reports")
+	case c.DID == "":
+		warn("did", "not set, each label will need to have `src` set")
+	default:
+		if _, err := syntax.ParseDID(c.DID); err != nil {
+			fail("did", "%q is not a valid DID", c.DID)
+		}
+	}
+
+	if c.PrivateKey == "" {
+		fail("private_key", "must be set (or use private_key_file or keystore). Use `keys generate` to create a new key")
+	} else if _, err := sign.ParsePrivateKey(c.PrivateKey); err != nil {
+		fail("private_key", "%s. Expected a hex-encoded, multibase or PEM secp256k1 key", err)
+	}
+
+	if slices.Contains(required, RequireDatabase) && c.SQLiteDB == "" && c.PostgresURL == "" {
+		if c.DBFile != "" {
+			fail("db_file", "is only used for migrating to a new database, set sqlite_db or postgres_url too")
+		} else {
+			fail("sqlite_db", "one of sqlite_db and postgres_url must be set")
+		}
+	}
+	if c.PostgresURL != "" {
+		if c.DBFile != "" && c.SQLiteDB != "" {
+			fail("postgres_url", "both db_file and sqlite_db are set, but only one of them can be migrated into postgres")
+		} else if c.DBFile != "" || c.SQLiteDB != "" {
+			warn("postgres_url", "data from the old database will be migrated into postgres on startup, remove db_file/sqlite_db from the config after that")
+		}
+	}
+
+	if slices.Contains(required, RequirePassword) && c.Password == "" {
+		fail("password", "must be set (or use password_file)")
+	}
+	if c.Endpoint != "" {
+		if u, err := url.Parse(c.Endpoint); err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" {
+			fail("endpoint", "must be an https:// URL")
+		} else if u.Scheme == "http" {
+			warn("endpoint", "Bluesky will not connect to a plain http:// endpoint, use https://")
+		}
+	}
+	if c.PLCURL != "" {
+		if u, err := url.Parse(c.PLCURL); err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" {
+			fail("plc_url", "must be an http:// or https:// URL")
+		}
+	}
+
+	c.validateLabels(fail, warn)
+
+	roles := map[string]bool{}
+	for name := range c.Roles {
+		roles[name] = true
+	}
+	tokenNames := map[string]bool{}
+	for i, t := range c.AdminTokens {
+		field := fmt.Sprintf("admin_tokens[%d]", i)
+		if t.Name == "" {
+			fail(field+".name", "must be set")
+		} else if tokenNames[t.Name] {
+			fail(field+".name", "duplicate name %q", t.Name)
+		}
+		tokenNames[t.Name] = true
+		if t.Token == "" {
+			fail(field+".token", "must be set (or use token_file)")
+		}
+		if t.Role != "" && !roles[t.Role] {
+			fail(field+".role", "unknown role %q", t.Role)
+		}
+		if t.Role == "" && len(t.Labels) == 0 {
+			warn(field, "neither labels nor role are set, token can't be used to change any labels")
+		}
+	}
+	for i, m := range c.Moderators {
+		field := fmt.Sprintf("moderators[%d]", i)
+		if _, err := syntax.ParseDID(m.DID); err != nil {
+			fail(field+".did", "%q is not a valid DID", m.DID)
+		}
+		if !roles[m.Role] {
+			fail(field+".role", "unknown role %q", m.Role)
+		}
+	}
+
+	for val, e := range c.Expiration {
+		field := fmt.Sprintf("expiration[%q]", val)
+		if e.Default < 0 || e.Max < 0 {
+			fail(field, "durations must not be negative")
+		}
+		if e.Default > 0 && e.Max > 0 && e.Default > e.Max {
+			fail(field+".default", "must not be greater than max")
+		}
+	}
+
+	for i, v := range c.SystemLabels {
+		field := fmt.Sprintf("system_labels[%d]", i)
+		if !strings.HasPrefix(v, "!") || !labelIdentifier.MatchString(v[1:]) {
+			fail(field, "%q must be '!' followed by lowercase ASCII letters and '-'", v)
+		} else if !slices.Contains(globalLabelValues, v) {
+			warn(field, "%q is not a system label value known to Bluesky, clients will ignore it", v)
+		}
+	}
+
+	known := map[string]bool{}
+	for _, v := range c.LabelValues() {
+		known[v] = true
+	}
+	for _, def := range c.Labels.LabelValueDefinitions {
+		if def != nil {
+			known[def.Identifier] = true
+		}
+	}
+	for name, vals := range c.ExclusiveGroups {
+		field := fmt.Sprintf("exclusive_groups[%q]", name)
+		if len(vals) < 2 {
+			fail(field, "must contain at least two label values")
+		}
+		for _, v := range vals {
+			if len(known) > 0 && !known[v] {
+				warn(field, "%q is not listed in labels, it can't be applied", v)
+			}
+		}
+	}
+
+	if c.Approval.Expiry < 0 {
+		fail("approval.expiry", "must not be negative")
+	}
+	if len(c.Approval.Labels) > 0 && len(c.AdminTokens) == 0 && len(c.Moderators) == 0 {
+		fail("approval.labels", "approval requires admin API authentication, add admin_tokens or moderators")
+	}
+	for i, v := range c.Approval.Labels {
+		if len(known) > 0 && !known[v] {
+			warn(fmt.Sprintf("approval.labels[%d]", i), "%q is not listed in labels, it can't be applied", v)
+		}
+	}
+	for name, vals := range c.ExclusiveGroups {
+		for _, v := range vals {
+			if slices.Contains(c.Approval.Labels, v) {
+				// Applying another value from the group would negate it without approval.
+				fail(fmt.Sprintf("exclusive_groups[%q]", name), "%q requires approval and can't be in an exclusive group", v)
+			}
+		}
+	}
+
+	for val, d := range c.Delay {
+		field := fmt.Sprintf("delay[%q]", val)
+		if d < 0 {
+			fail(field, "must not be negative")
+		}
+		if val != "*" && len(known) > 0 && !known[val] {
+			warn(field, "%q is not listed in labels, it can't be applied", val)
+		}
+	}
+
+	for val, rule := range c.Subjects {
+		field := fmt.Sprintf("subjects[%q]", val)
+		for _, t := range rule.Types {
+			if t != "account" && t != "record" {
+				fail(field+".types", "unknown type %q, must be either account or record", t)
+			}
+		}
+		if len(rule.Types) > 0 && !slices.Contains(rule.Types, "record") && (len(rule.Collections) > 0 || rule.RequireCID) {
+			fail(field, "collections and require_cid only apply to records, but records are not allowed")
+		}
+		for _, col := range rule.Collections {
+			if _, err := syntax.ParseNSID(col); err != nil {
+				fail(field+".collections", "%q is not a valid NSID", col)
+			}
+		}
+	}
+
+	if c.IdempotencyWindow < 0 {
+		fail("idempotency_window", "must not be negative")
+	}
+	for i, l := range c.Reports.RateLimits {
+		if l.Count <= 0 || l.Period <= 0 {
+			fail(fmt.Sprintf("reports.rate_limits[%d]", i), "both count and period must be positive")
+		}
+	}
+	for i, s := range c.Sinks {
+		field := fmt.Sprintf("sinks[%d]", i)
+		switch s.Type {
+		case "webhook":
+			if u, err := url.Parse(s.URL); err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" {
+				fail(field+".url", "must be an http:// or https:// URL")
+			}
+			if s.Secret == "" {
+				fail(field+".secret", "must be set (or use secret_file)")
+			}
+			if s.Outbox == "" {
+				fail(field+".outbox", "must be set")
+			}
+		case "file":
+			if s.Path == "" {
+				fail(field+".path", "must be set")
+			}
+		default:
+			fail(field+".type", "unknown type %q, must be either webhook or file", s.Type)
+		}
+	}
+
+	return warnings, errors.Join(errs...)
+}
+
+func (c *Config) validateLabels(fail func(string, string, ...any), warn func(string, string, ...any)) {
+	defined := map[string]bool{}
+	for i, def := range c.Labels.LabelValueDefinitions {
+		field := fmt.Sprintf("labels.labelvaluedefinitions[%d]", i)
+		if def == nil {
+			fail(field, "must not be empty")
+			continue
+		}
+		if !labelIdentifier.MatchString(def.Identifier) || len(def.Identifier) > 100 {
+			fail(field+".identifier", "%q must consist of up to 100 lowercase ASCII letters and '-'", def.Identifier)
+		}
+		if defined[def.Identifier] {
+			fail(field+".identifier", "%q is defined more than once", def.Identifier)
+		}
+		defined[def.Identifier] = true
+
+		if !slices.Contains([]string{"inform", "alert", "none"}, def.Severity) {
+			fail(field+".severity", "%q must be one of inform, alert, none", def.Severity)
+		}
+		if !slices.Contains([]string{"content", "media", "none"}, def.Blurs) {
+			fail(field+".blurs", "%q must be one of content, media, none", def.Blurs)
+		}
+		if def.DefaultSetting != nil && !slices.Contains([]string{"ignore", "warn", "hide"}, *def.DefaultSetting) {
+			fail(field+".defaultsetting", "%q must be one of ignore, warn, hide", *def.DefaultSetting)
+		}
+		if len(def.Locales) == 0 {
+			warn(field+".locales", "no locales are set, clients will show %q as is", def.Identifier)
+		}
+		for j, l := range def.Locales {
+			lfield := fmt.Sprintf("%s.locales[%d]", field, j)
+			if l == nil {
+				fail(lfield, "must not be empty")
+				continue
+			}
+			if _, err := syntax.ParseLanguage(l.Lang); err != nil {
+				fail(lfield+".lang", "%q is not a valid language code", l.Lang)
+			}
+			if l.Name == "" || utf8.RuneCountInString(l.Name) > 64 || len(l.Name) > 640 {
+				fail(lfield+".name", "must be non-empty and at most 64 characters long")
+			}
+			if utf8.RuneCountInString(l.Description) > 10000 || len(l.Description) > 100000 {
+				fail(lfield+".description", "must be at most 10000 characters long")
+			}
+		}
+	}
+
+	for i, v := range c.Labels.LabelValues {
+		field := fmt.Sprintf("labels.labelvalues[%d]", i)
+		if v == nil || *v == "" {
+			fail(field, "must not be empty")
+			continue
+		}
+		if len(*v) > 128 {
+			fail(field, "%q is longer than 128 bytes", *v)
+		}
+		if !labelIdentifier.MatchString(strings.TrimPrefix(*v, "!")) {
+			fail(field, "%q must consist of lowercase ASCII letters and '-', optionally prefixed with '!'", *v)
+			continue
+		}
+		if strings.HasPrefix(*v, "!") {
+			switch {
+			case c.SystemLabels != nil && !slices.Contains(c.SystemLabels, *v):
+				fail(field, "%q is not listed in system_labels", *v)
+			case c.SystemLabels == nil && !slices.Contains(globalLabelValues, *v):
+				fail(field, "%q is not a known system label value, custom values can't start with '!'", *v)
+			}
+			continue
+		}
+		if !defined[*v] && !slices.Contains(globalLabelValues, *v) {
+			warn(field, "%q has no definition in labelvaluedefinitions, clients won't know how to display it", *v)
+		}
+	}
+}
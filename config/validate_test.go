@@ -0,0 +1,548 @@
+package config
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	comatproto "github.com/bluesky-social/indigo/api/atproto"
+	"github.com/bluesky-social/indigo/api/bsky"
+)
+
+func ptr[T any](v T) *T { return &v }
+
+func labelDef(identifier string) *comatproto.LabelDefs_LabelValueDefinition {
+	return &comatproto.LabelDefs_LabelValueDefinition{
+		Identifier: identifier,
+		Severity:   "alert",
+		Blurs:      "none",
+		Locales: []*comatproto.LabelDefs_LabelValueDefinitionStrings{
+			{Lang: "en", Name: identifier, Description: "Test label"},
+		},
+	}
+}
+
+// validConfig returns a config that passes validation without any warnings.
+func validConfig() *Config {
+	return &Config{
+		DID:        "did:plc:labeler",
+		PrivateKey: "c6d40ec53c689ca905036e41d8c73560777e5746d1d228fd6f9db56efed8ecaf",
+		SQLiteDB:   "/data/labels.db",
+		Labels: bsky.LabelerDefs_LabelerPolicies{
+			LabelValues:           []*string{ptr("spam"), ptr("scam"), ptr("porn"), ptr("!hide")},
+			LabelValueDefinitions: []*comatproto.LabelDefs_LabelValueDefinition{labelDef("spam"), labelDef("scam")},
+		},
+		Roles: map[string]Role{"mod": {Labels: []string{"*"}}},
+	}
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name     string
+		modify   func(c *Config)
+		required []Requirement
+		// Prefixes of the expected errors and warnings, one per problem.
+		errors   []string
+		warnings []string
+	}{
+		{
+			name:     "valid",
+			required: []Requirement{RequireDatabase, RequireDID},
+		},
+		{
+			name:     "no DID",
+			modify:   func(c *Config) { c.DID = "" },
+			warnings: []string{"did: not set"},
+		},
+		{
+			name:     "no DID when required",
+			modify:   func(c *Config) { c.DID = "" },
+			required: []Requirement{RequireDID},
+			errors:   []string{"did: must be set"},
+		},
+		{
+			name:   "no DID with reports",
+			modify: func(c *Config) { c.DID = ""; c.Reports.Enabled = true },
+			errors: []string{"did: must be set to accept reports"},
+		},
+		{
+			name:   "invalid DID",
+			modify: func(c *Config) { c.DID = "plc:labeler" },
+			errors: []string{`did: "plc:labeler" is not a valid DID`},
+		},
+		{
+			name:   "no private key",
+			modify: func(c *Config) { c.PrivateKey = "" },
+			errors: []string{"private_key: must be set"},
+		},
+		{
+			name:   "invalid private key",
+			modify: func(c *Config) { c.PrivateKey = "not a key" },
+			errors: []string{"private_key: "},
+		},
+		{
+			name:   "no database",
+			modify: func(c *Config) { c.SQLiteDB = "" },
+		},
+		{
+			name:     "no database when required",
+			modify:   func(c *Config) { c.SQLiteDB = "" },
+			required: []Requirement{RequireDatabase},
+			errors:   []string{"sqlite_db: one of sqlite_db and postgres_url must be set"},
+		},
+		{
+			name:     "only db_file",
+			modify:   func(c *Config) { c.SQLiteDB = ""; c.DBFile = "/data/labels.db" },
+			required: []Requirement{RequireDatabase},
+			errors:   []string{"db_file: is only used for migrating"},
+		},
+		{
+			name:     "postgres",
+			modify:   func(c *Config) { c.SQLiteDB = ""; c.PostgresURL = "postgres://localhost/labels" },
+			required: []Requirement{RequireDatabase},
+		},
+		{
+			name:     "migrating into postgres",
+			modify:   func(c *Config) { c.PostgresURL = "postgres://localhost/labels" },
+			warnings: []string{"postgres_url: data from the old database will be migrated"},
+		},
+		{
+			name:   "migrating two databases into postgres",
+			modify: func(c *Config) { c.PostgresURL = "postgres://localhost/labels"; c.DBFile = "/data/old.db" },
+			errors: []string{"postgres_url: both db_file and sqlite_db are set"},
+		},
+		{
+			name: "no password",
+		},
+		{
+			name:     "no password when required",
+			required: []Requirement{RequirePassword},
+			errors:   []string{"password: must be set"},
+		},
+		{
+			name:     "password",
+			modify:   func(c *Config) { c.Password = "hunter2" },
+			required: []Requirement{RequirePassword},
+		},
+		{
+			name:     "all requirements",
+			modify:   func(c *Config) { c.DID = ""; c.SQLiteDB = "" },
+			required: []Requirement{RequireDatabase, RequireDID, RequirePassword},
+			errors:   []string{"did: must be set", "sqlite_db: ", "password: "},
+		},
+		{
+			name:   "https endpoint",
+			modify: func(c *Config) { c.Endpoint = "https://labeler.example.com" },
+		},
+		{
+			name:     "http endpoint",
+			modify:   func(c *Config) { c.Endpoint = "http://labeler.example.com" },
+			warnings: []string{"endpoint: Bluesky will not connect"},
+		},
+		{
+			name:   "invalid endpoint",
+			modify: func(c *Config) { c.Endpoint = "labeler.example.com" },
+			errors: []string{"endpoint: must be an https:// URL"},
+		},
+		{
+			name:   "invalid plc_url",
+			modify: func(c *Config) { c.PLCURL = "ftp://plc.example.com" },
+			errors: []string{"plc_url: "},
+		},
+
+		// Label definitions.
+		{
+			name: "empty label definition",
+			modify: func(c *Config) {
+				c.Labels.LabelValueDefinitions = append(c.Labels.LabelValueDefinitions, nil)
+			},
+			errors: []string{"labels.labelvaluedefinitions[2]: must not be empty"},
+		},
+		{
+			name:   "invalid identifier",
+			modify: func(c *Config) { c.Labels.LabelValueDefinitions[0].Identifier = "Spam" },
+			errors: []string{"labels.labelvaluedefinitions[0].identifier: "},
+			// "spam" in labelvalues no longer has a definition.
+			warnings: []string{"labels.labelvalues[0]: "},
+		},
+		{
+			name:     "long identifier",
+			modify:   func(c *Config) { c.Labels.LabelValueDefinitions[0].Identifier = strings.Repeat("a", 101) },
+			errors:   []string{"labels.labelvaluedefinitions[0].identifier: "},
+			warnings: []string{"labels.labelvalues[0]: "},
+		},
+		{
+			name:     "duplicate definition",
+			modify:   func(c *Config) { c.Labels.LabelValueDefinitions[1].Identifier = "spam" },
+			errors:   []string{`labels.labelvaluedefinitions[1].identifier: "spam" is defined more than once`},
+			warnings: []string{"labels.labelvalues[1]: "},
+		},
+		{
+			name:   "invalid severity",
+			modify: func(c *Config) { c.Labels.LabelValueDefinitions[0].Severity = "high" },
+			errors: []string{"labels.labelvaluedefinitions[0].severity: "},
+		},
+		{
+			name:   "invalid blurs",
+			modify: func(c *Config) { c.Labels.LabelValueDefinitions[0].Blurs = "all" },
+			errors: []string{"labels.labelvaluedefinitions[0].blurs: "},
+		},
+		{
+			name:   "valid default setting",
+			modify: func(c *Config) { c.Labels.LabelValueDefinitions[0].DefaultSetting = ptr("hide") },
+		},
+		{
+			name:   "invalid default setting",
+			modify: func(c *Config) { c.Labels.LabelValueDefinitions[0].DefaultSetting = ptr("show") },
+			errors: []string{"labels.labelvaluedefinitions[0].defaultsetting: "},
+		},
+		{
+			name:     "no locales",
+			modify:   func(c *Config) { c.Labels.LabelValueDefinitions[0].Locales = nil },
+			warnings: []string{"labels.labelvaluedefinitions[0].locales: no locales are set"},
+		},
+		{
+			name: "empty locale",
+			modify: func(c *Config) {
+				def := c.Labels.LabelValueDefinitions[0]
+				def.Locales = append(def.Locales, nil)
+			},
+			errors: []string{"labels.labelvaluedefinitions[0].locales[1]: must not be empty"},
+		},
+		{
+			name:   "invalid language",
+			modify: func(c *Config) { c.Labels.LabelValueDefinitions[0].Locales[0].Lang = "english!" },
+			errors: []string{"labels.labelvaluedefinitions[0].locales[0].lang: "},
+		},
+		{
+			name:   "empty name",
+			modify: func(c *Config) { c.Labels.LabelValueDefinitions[0].Locales[0].Name = "" },
+			errors: []string{"labels.labelvaluedefinitions[0].locales[0].name: "},
+		},
+		{
+			name:   "long name",
+			modify: func(c *Config) { c.Labels.LabelValueDefinitions[0].Locales[0].Name = strings.Repeat("ы", 65) },
+			errors: []string{"labels.labelvaluedefinitions[0].locales[0].name: "},
+		},
+		{
+			name:   "name of 64 characters",
+			modify: func(c *Config) { c.Labels.LabelValueDefinitions[0].Locales[0].Name = strings.Repeat("ы", 64) },
+		},
+		{
+			name:   "long description",
+			modify: func(c *Config) { c.Labels.LabelValueDefinitions[0].Locales[0].Description = strings.Repeat("a", 10001) },
+			errors: []string{"labels.labelvaluedefinitions[0].locales[0].description: "},
+		},
+
+		// Label values.
+		{
+			name:   "nil label value",
+			modify: func(c *Config) { c.Labels.LabelValues = append(c.Labels.LabelValues, nil) },
+			errors: []string{"labels.labelvalues[4]: must not be empty"},
+		},
+		{
+			name:   "empty label value",
+			modify: func(c *Config) { c.Labels.LabelValues = append(c.Labels.LabelValues, ptr("")) },
+			errors: []string{"labels.labelvalues[4]: must not be empty"},
+		},
+		{
+			name:   "long label value",
+			modify: func(c *Config) { c.Labels.LabelValues = append(c.Labels.LabelValues, ptr(strings.Repeat("a", 129))) },
+			errors: []string{"labels.labelvalues[4]: "},
+			// Also has no definition.
+			warnings: []string{"labels.labelvalues[4]: "},
+		},
+		{
+			name:   "invalid label value",
+			modify: func(c *Config) { c.Labels.LabelValues = append(c.Labels.LabelValues, ptr("Spam!")) },
+			errors: []string{`labels.labelvalues[4]: "Spam!" must consist of lowercase ASCII letters`},
+		},
+		{
+			name:     "label value without a definition",
+			modify:   func(c *Config) { c.Labels.LabelValues = append(c.Labels.LabelValues, ptr("rude")) },
+			warnings: []string{`labels.labelvalues[4]: "rude" has no definition`},
+		},
+		{
+			name:   "custom system label value",
+			modify: func(c *Config) { c.Labels.LabelValues = append(c.Labels.LabelValues, ptr("!mute")) },
+			errors: []string{`labels.labelvalues[4]: "!mute" is not a known system label value`},
+		},
+		{
+			name:   "system label value not in system_labels",
+			modify: func(c *Config) { c.SystemLabels = []string{"!warn"} },
+			errors: []string{`labels.labelvalues[3]: "!hide" is not listed in system_labels`},
+		},
+		{
+			name: "system label value in system_labels",
+			modify: func(c *Config) {
+				c.SystemLabels = []string{"!hide", "!warn"}
+				c.Labels.LabelValues = append(c.Labels.LabelValues, ptr("!warn"))
+			},
+		},
+		{
+			name:     "unknown system label",
+			modify:   func(c *Config) { c.SystemLabels = []string{"!hide", "!mute"} },
+			warnings: []string{`system_labels[1]: "!mute" is not a system label value known to Bluesky`},
+		},
+		{
+			name:   "invalid system label",
+			modify: func(c *Config) { c.SystemLabels = []string{"!hide", "warn"} },
+			errors: []string{`system_labels[1]: "warn" must be '!' followed by`},
+		},
+
+		// Authentication.
+		{
+			name: "admin tokens",
+			modify: func(c *Config) {
+				c.AdminTokens = []AdminToken{
+					{Name: "a", Token: "secret", Labels: []string{"spam"}},
+					{Name: "b", Token: "secret", Role: "mod"},
+				}
+			},
+		},
+		{
+			name:   "admin token without name",
+			modify: func(c *Config) { c.AdminTokens = []AdminToken{{Token: "secret", Role: "mod"}} },
+			errors: []string{"admin_tokens[0].name: must be set"},
+		},
+		{
+			name: "duplicate admin token name",
+			modify: func(c *Config) {
+				c.AdminTokens = []AdminToken{{Name: "a", Token: "secret", Role: "mod"}, {Name: "a", Token: "other", Role: "mod"}}
+			},
+			errors: []string{`admin_tokens[1].name: duplicate name "a"`},
+		},
+		{
+			name:   "admin token without token",
+			modify: func(c *Config) { c.AdminTokens = []AdminToken{{Name: "a", Role: "mod"}} },
+			errors: []string{"admin_tokens[0].token: must be set"},
+		},
+		{
+			name:   "admin token with unknown role",
+			modify: func(c *Config) { c.AdminTokens = []AdminToken{{Name: "a", Token: "secret", Role: "admin"}} },
+			errors: []string{`admin_tokens[0].role: unknown role "admin"`},
+		},
+		{
+			name:     "admin token without permissions",
+			modify:   func(c *Config) { c.AdminTokens = []AdminToken{{Name: "a", Token: "secret"}} },
+			warnings: []string{"admin_tokens[0]: neither labels nor role are set"},
+		},
+		{
+			name:   "moderator",
+			modify: func(c *Config) { c.Moderators = []Moderator{{DID: "did:plc:mod", Role: "mod"}} },
+		},
+		{
+			name:   "moderator with invalid DID",
+			modify: func(c *Config) { c.Moderators = []Moderator{{DID: "@mod.example.com", Role: "mod"}} },
+			errors: []string{"moderators[0].did: "},
+		},
+		{
+			name:   "moderator without role",
+			modify: func(c *Config) { c.Moderators = []Moderator{{DID: "did:plc:mod"}} },
+			errors: []string{`moderators[0].role: unknown role ""`},
+		},
+
+		// Label policies.
+		{
+			name: "expiration",
+			modify: func(c *Config) {
+				c.Expiration = map[string]Expiration{"spam": {Default: time.Hour, Max: 24 * time.Hour}, "*": {Max: time.Hour}}
+			},
+		},
+		{
+			name:   "negative expiration",
+			modify: func(c *Config) { c.Expiration = map[string]Expiration{"spam": {Default: -time.Hour}} },
+			errors: []string{`expiration["spam"]: durations must not be negative`},
+		},
+		{
+			name: "default expiration over max",
+			modify: func(c *Config) {
+				c.Expiration = map[string]Expiration{"spam": {Default: 2 * time.Hour, Max: time.Hour}}
+			},
+			errors: []string{`expiration["spam"].default: must not be greater than max`},
+		},
+		{
+			name:   "exclusive group",
+			modify: func(c *Config) { c.ExclusiveGroups = map[string][]string{"kind": {"spam", "scam"}} },
+		},
+		{
+			name:   "exclusive group with one value",
+			modify: func(c *Config) { c.ExclusiveGroups = map[string][]string{"kind": {"spam"}} },
+			errors: []string{`exclusive_groups["kind"]: must contain at least two label values`},
+		},
+		{
+			name:     "exclusive group with unknown value",
+			modify:   func(c *Config) { c.ExclusiveGroups = map[string][]string{"kind": {"spam", "rude"}} },
+			warnings: []string{`exclusive_groups["kind"]: "rude" is not listed in labels`},
+		},
+		{
+			name: "approval",
+			modify: func(c *Config) {
+				c.Moderators = []Moderator{{DID: "did:plc:mod", Role: "mod"}}
+				c.Approval = Approval{Labels: []string{"porn"}, Expiry: time.Hour}
+			},
+		},
+		{
+			name: "negative approval expiry",
+			modify: func(c *Config) {
+				c.Moderators = []Moderator{{DID: "did:plc:mod", Role: "mod"}}
+				c.Approval = Approval{Labels: []string{"porn"}, Expiry: -time.Hour}
+			},
+			errors: []string{"approval.expiry: must not be negative"},
+		},
+		{
+			name:   "approval without authentication",
+			modify: func(c *Config) { c.Approval.Labels = []string{"porn"} },
+			errors: []string{"approval.labels: approval requires admin API authentication"},
+		},
+		{
+			name: "approval for unknown value",
+			modify: func(c *Config) {
+				c.Moderators = []Moderator{{DID: "did:plc:mod", Role: "mod"}}
+				c.Approval.Labels = []string{"porn", "rude"}
+			},
+			warnings: []string{`approval.labels[1]: "rude" is not listed in labels`},
+		},
+		{
+			name: "approval for a value in an exclusive group",
+			modify: func(c *Config) {
+				c.Moderators = []Moderator{{DID: "did:plc:mod", Role: "mod"}}
+				c.Approval.Labels = []string{"scam"}
+				c.ExclusiveGroups = map[string][]string{"kind": {"spam", "scam"}}
+			},
+			errors: []string{`exclusive_groups["kind"]: "scam" requires approval`},
+		},
+		{
+			name:   "delay",
+			modify: func(c *Config) { c.Delay = map[string]time.Duration{"spam": time.Hour, "*": time.Minute} },
+		},
+		{
+			name:   "negative delay",
+			modify: func(c *Config) { c.Delay = map[string]time.Duration{"*": -time.Minute} },
+			errors: []string{`delay["*"]: must not be negative`},
+		},
+		{
+			name:     "delay for unknown value",
+			modify:   func(c *Config) { c.Delay = map[string]time.Duration{"rude": time.Hour} },
+			warnings: []string{`delay["rude"]: "rude" is not listed in labels`},
+		},
+		{
+			name: "subjects",
+			modify: func(c *Config) {
+				c.Subjects = map[string]SubjectRule{
+					"spam": {Types: []string{"record"}, Collections: []string{"app.bsky.feed.post"}, RequireCID: true},
+					"*":    {Types: []string{"account"}},
+				}
+			},
+		},
+		{
+			name:   "unknown subject type",
+			modify: func(c *Config) { c.Subjects = map[string]SubjectRule{"spam": {Types: []string{"post"}}} },
+			errors: []string{`subjects["spam"].types: unknown type "post"`},
+		},
+		{
+			name: "collections without records",
+			modify: func(c *Config) {
+				c.Subjects = map[string]SubjectRule{"spam": {Types: []string{"account"}, Collections: []string{"app.bsky.feed.post"}}}
+			},
+			errors: []string{`subjects["spam"]: collections and require_cid only apply to records`},
+		},
+		{
+			name: "require_cid without records",
+			modify: func(c *Config) {
+				c.Subjects = map[string]SubjectRule{"spam": {Types: []string{"account"}, RequireCID: true}}
+			},
+			errors: []string{`subjects["spam"]: collections and require_cid only apply to records`},
+		},
+		{
+			name:   "invalid collection",
+			modify: func(c *Config) { c.Subjects = map[string]SubjectRule{"spam": {Collections: []string{"post"}}} },
+			errors: []string{`subjects["spam"].collections: "post" is not a valid NSID`},
+		},
+
+		// Other settings.
+		{
+			name:   "negative idempotency window",
+			modify: func(c *Config) { c.IdempotencyWindow = -time.Hour },
+			errors: []string{"idempotency_window: must not be negative"},
+		},
+		{
+			name: "report rate limits",
+			modify: func(c *Config) {
+				c.Reports = Reports{Enabled: true, RateLimits: []RateLimit{{Count: 10, Period: time.Hour}}}
+			},
+		},
+		{
+			name: "invalid report rate limits",
+			modify: func(c *Config) {
+				c.Reports.RateLimits = []RateLimit{{Count: 10, Period: time.Hour}, {Count: 0, Period: time.Hour}, {Count: 10}}
+			},
+			errors: []string{"reports.rate_limits[1]: ", "reports.rate_limits[2]: "},
+		},
+		{
+			name: "sinks",
+			modify: func(c *Config) {
+				c.Sinks = []Sink{
+					{Type: "webhook", URL: "https://example.com/hook", Secret: "secret", Outbox: "/data/outbox"},
+					{Type: "file", Path: "/data/labels.jsonl"},
+				}
+			},
+		},
+		{
+			name:   "incomplete webhook sink",
+			modify: func(c *Config) { c.Sinks = []Sink{{Type: "webhook", URL: "example.com/hook"}} },
+			errors: []string{"sinks[0].url: ", "sinks[0].secret: ", "sinks[0].outbox: "},
+		},
+		{
+			name:   "file sink without path",
+			modify: func(c *Config) { c.Sinks = []Sink{{Type: "file"}} },
+			errors: []string{"sinks[0].path: must be set"},
+		},
+		{
+			name:   "unknown sink type",
+			modify: func(c *Config) { c.Sinks = []Sink{{Type: "kafka"}} },
+			errors: []string{`sinks[0].type: unknown type "kafka"`},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			c := validConfig()
+			if test.modify != nil {
+				test.modify(c)
+			}
+			warnings, err := c.Validate(test.required...)
+
+			var errs []string
+			if err != nil {
+				errs = strings.Split(err.Error(), "\n")
+			}
+			checkProblems(t, "errors", errs, test.errors)
+			checkProblems(t, "warnings", warnings, test.warnings)
+		})
+	}
+}
+
+// checkProblems verifies that each of got starts with the corresponding prefix from want,
+// ignoring the order, since problems in maps are reported in random order.
+func checkProblems(t *testing.T, kind string, got []string, want []string) {
+	t.Helper()
+	if len(got) != len(want) {
+		t.Errorf("expected %d %s, got %d: %q", len(want), kind, len(got), got)
+		return
+	}
+	matched := make([]bool, len(got))
+	for _, prefix := range want {
+		found := false
+		for i, s := range got {
+			if !matched[i] && strings.HasPrefix(s, prefix) {
+				matched[i] = true
+				found = true
+				break
+			}
+		}
+		if !found {
+			t.Errorf("expected %s to include %q, got %q", kind, prefix, got)
+		}
+	}
+}
@@ -0,0 +1,91 @@
+// Package diddoc implements parsing of DID documents and extraction of
+// public keys from them.
+package diddoc
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/mr-tron/base58"
+
+	"github.com/bluesky-social/indigo/atproto/crypto"
+)
+
+// ErrKeyNotFound is returned when a DID document doesn't have
+// a verification method with the requested ID.
+var ErrKeyNotFound = errors.New("verification method not found")
+
+type Document struct {
+	ID                 string               `json:"id"`
+	AlsoKnownAs        []string             `json:"alsoKnownAs,omitempty"`
+	VerificationMethod []VerificationMethod `json:"verificationMethod,omitempty"`
+	Service            []Service            `json:"service,omitempty"`
+}
+
+type VerificationMethod struct {
+	ID                 string `json:"id"`
+	Type               string `json:"type"`
+	Controller         string `json:"controller"`
+	PublicKeyMultibase string `json:"publicKeyMultibase"`
+}
+
+type Service struct {
+	ID              string `json:"id"`
+	Type            string `json:"type"`
+	ServiceEndpoint string `json:"serviceEndpoint"`
+}
+
+// Parse decodes a JSON-encoded DID document.
+func Parse(b []byte) (*Document, error) {
+	doc := &Document{}
+	if err := json.Unmarshal(b, doc); err != nil {
+		return nil, fmt.Errorf("parsing DID document: %w", err)
+	}
+	if doc.ID == "" {
+		return nil, fmt.Errorf("DID document is missing `id`")
+	}
+	return doc, nil
+}
+
+// PublicKey returns the public key of the verification method with the given ID
+// (e.g., "atproto" or "atproto_label", without '#').
+func (d *Document) PublicKey(id string) (crypto.PublicKey, error) {
+	for _, m := range d.VerificationMethod {
+		if m.ID != "#"+id && m.ID != d.ID+"#"+id {
+			continue
+		}
+		switch m.Type {
+		case "Multikey":
+			return crypto.ParsePublicMultibase(m.PublicKeyMultibase)
+		case "EcdsaSecp256r1VerificationKey2019", "EcdsaSecp256k1VerificationKey2019":
+			// Legacy format: multibase-encoded uncompressed key, without multicodec prefix.
+			if !strings.HasPrefix(m.PublicKeyMultibase, "z") {
+				return nil, fmt.Errorf("public key is not a base58btc multibase string")
+			}
+			b, err := base58.Decode(m.PublicKeyMultibase[1:])
+			if err != nil {
+				return nil, fmt.Errorf("decoding public key: %w", err)
+			}
+			if m.Type == "EcdsaSecp256k1VerificationKey2019" {
+				return crypto.ParsePublicUncompressedBytesK256(b)
+			}
+			return crypto.ParsePublicUncompressedBytesP256(b)
+		default:
+			return nil, fmt.Errorf("unsupported verification method type %q", m.Type)
+		}
+	}
+	return nil, fmt.Errorf("%w: %q", ErrKeyNotFound, id)
+}
+
+// ServiceEndpoint returns the endpoint of the service with the given ID
+// (e.g., "atproto_pds" or "atproto_labeler", without '#').
+func (d *Document) ServiceEndpoint(id string) string {
+	for _, s := range d.Service {
+		if s.ID == "#"+id || s.ID == d.ID+"#"+id {
+			return s.ServiceEndpoint
+		}
+	}
+	return ""
+}
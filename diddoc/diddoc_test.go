@@ -0,0 +1,247 @@
+package diddoc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mr-tron/base58"
+
+	"github.com/bluesky-social/indigo/atproto/crypto"
+)
+
+// Public key of a secp256k1 key generated with openssl, in different encodings.
+const (
+	testDIDKey          = "did:key:zQ3shvmZBojA99B2xaUNhyM2FHNA5izaDNKQXtJ8oQSZaUoCu"
+	testLegacyMultibase = "zSGKitsPstaT7xcB8bwcJPSF1CZbhCKsbxQCS171sbFDtJowiYtRt9ETnNXaX9G8eNXvGPw6HW3gvHUaqmvdQP3Gc"
+)
+
+// In the same format as returned by plc.directory.
+const testDoc = `{
+  "@context": [
+    "https://www.w3.org/ns/did/v1",
+    "https://w3id.org/security/multikey/v1",
+    "https://w3id.org/security/suites/secp256k1-2019/v1"
+  ],
+  "id": "did:plc:labeler",
+  "alsoKnownAs": ["at://labeler.example.com"],
+  "verificationMethod": [
+    {
+      "id": "did:plc:labeler#atproto",
+      "type": "Multikey",
+      "controller": "did:plc:labeler",
+      "publicKeyMultibase": "zQ3shvmZBojA99B2xaUNhyM2FHNA5izaDNKQXtJ8oQSZaUoCu"
+    },
+    {
+      "id": "#atproto_label",
+      "type": "EcdsaSecp256k1VerificationKey2019",
+      "controller": "did:plc:labeler",
+      "publicKeyMultibase": "zSGKitsPstaT7xcB8bwcJPSF1CZbhCKsbxQCS171sbFDtJowiYtRt9ETnNXaX9G8eNXvGPw6HW3gvHUaqmvdQP3Gc"
+    },
+    {
+      "id": "did:plc:labeler#ed25519",
+      "type": "Ed25519VerificationKey2020",
+      "controller": "did:plc:labeler",
+      "publicKeyMultibase": "z6MkhaXgBZDvotDkL5257faiztiGiC2QtKLGpbnnEGta2doK"
+    }
+  ],
+  "service": [
+    {
+      "id": "#atproto_pds",
+      "type": "AtprotoPersonalDataServer",
+      "serviceEndpoint": "https://pds.example.com"
+    },
+    {
+      "id": "did:plc:labeler#atproto_labeler",
+      "type": "AtprotoLabeler",
+      "serviceEndpoint": "https://labeler.example.com"
+    }
+  ]
+}`
+
+func TestParse(t *testing.T) {
+	doc, err := Parse([]byte(testDoc))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if doc.ID != "did:plc:labeler" || len(doc.VerificationMethod) != 3 || len(doc.AlsoKnownAs) != 1 {
+		t.Errorf("unexpected document: %+v", doc)
+	}
+
+	for _, s := range []string{`{"alsoKnownAs": []}`, `not JSON`, `[]`} {
+		if _, err := Parse([]byte(s)); err == nil {
+			t.Errorf("expected %q to be rejected", s)
+		}
+	}
+}
+
+func TestPublicKey(t *testing.T) {
+	doc, err := Parse([]byte(testDoc))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want, err := crypto.ParsePublicDIDKey(testDIDKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Both the absolute and relative IDs, in both Multikey and legacy format.
+	for _, id := range []string{"atproto", "atproto_label"} {
+		key, err := doc.PublicKey(id)
+		if err != nil {
+			t.Errorf("%q: %s", id, err)
+			continue
+		}
+		if !key.Equal(want) {
+			t.Errorf("%q: got key %s, want %s", id, key.DIDKey(), want.DIDKey())
+		}
+	}
+
+	if _, err := doc.PublicKey("ed25519"); err == nil {
+		t.Errorf("expected an unsupported key type to be rejected")
+	}
+	if _, err := doc.PublicKey("missing"); !errors.Is(err, ErrKeyNotFound) {
+		t.Errorf("expected ErrKeyNotFound, got %v", err)
+	}
+	// Another DID's key in the document is not ours.
+	other := &Document{ID: "did:plc:other", VerificationMethod: doc.VerificationMethod}
+	if _, err := other.PublicKey("atproto"); !errors.Is(err, ErrKeyNotFound) {
+		t.Errorf("expected ErrKeyNotFound, got %v", err)
+	}
+}
+
+func TestPublicKeyP256(t *testing.T) {
+	priv, err := crypto.GeneratePrivateKeyP256()
+	if err != nil {
+		t.Fatal(err)
+	}
+	pub, err := priv.PublicKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	doc := &Document{
+		ID: "did:web:example.com",
+		VerificationMethod: []VerificationMethod{
+			{ID: "#atproto", Type: "Multikey", PublicKeyMultibase: pub.Multibase()},
+			{ID: "#legacy", Type: "EcdsaSecp256r1VerificationKey2019", PublicKeyMultibase: "z" + base58.Encode(pub.UncompressedBytes())},
+			{ID: "#not_multibase", Type: "EcdsaSecp256r1VerificationKey2019", PublicKeyMultibase: base58.Encode(pub.UncompressedBytes())},
+			{ID: "#wrong_curve", Type: "EcdsaSecp256k1VerificationKey2019", PublicKeyMultibase: "z" + base58.Encode(pub.UncompressedBytes())},
+		},
+	}
+	for _, id := range []string{"atproto", "legacy"} {
+		key, err := doc.PublicKey(id)
+		if err != nil {
+			t.Errorf("%q: %s", id, err)
+			continue
+		}
+		if !key.Equal(pub) {
+			t.Errorf("%q: got key %s, want %s", id, key.DIDKey(), pub.DIDKey())
+		}
+	}
+	for _, id := range []string{"not_multibase", "wrong_curve"} {
+		if _, err := doc.PublicKey(id); err == nil {
+			t.Errorf("%q: expected an error", id)
+		}
+	}
+}
+
+func TestServiceEndpoint(t *testing.T) {
+	doc, err := Parse([]byte(testDoc))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for id, want := range map[string]string{
+		"atproto_pds":     "https://pds.example.com",
+		"atproto_labeler": "https://labeler.example.com",
+		"missing":         "",
+	} {
+		if got := doc.ServiceEndpoint(id); got != want {
+			t.Errorf("%q: got %q, want %q", id, got, want)
+		}
+	}
+}
+
+func TestHTTPResolver(t *testing.T) {
+	plc := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/did:plc:labeler":
+			fmt.Fprint(w, testDoc)
+		case "/did:plc:impostor":
+			// Document for a different DID.
+			fmt.Fprint(w, testDoc)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer plc.Close()
+
+	r := &HTTPResolver{PLCURL: plc.URL + "/", Client: plc.Client()}
+	ctx := context.Background()
+	doc, err := r.Resolve(ctx, "did:plc:labeler")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if doc.ID != "did:plc:labeler" {
+		t.Errorf("unexpected document %+v", doc)
+	}
+
+	for _, did := range []string{"did:plc:impostor", "did:plc:missing", "did:key:" + testLegacyMultibase, "did:web:example.com:path", "did:web:"} {
+		if _, err := r.Resolve(ctx, did); err == nil {
+			t.Errorf("%q: expected an error", did)
+		}
+	}
+}
+
+type countingResolver struct {
+	calls int
+}
+
+func (r *countingResolver) Resolve(ctx context.Context, did string) (*Document, error) {
+	r.calls++
+	if !strings.HasPrefix(did, "did:plc:") {
+		return nil, fmt.Errorf("not found")
+	}
+	return &Document{ID: did}, nil
+}
+
+func TestCachingResolver(t *testing.T) {
+	ctx := context.Background()
+	counting := &countingResolver{}
+	r := NewCachingResolver(counting, time.Hour)
+
+	for i := 0; i < 2; i++ {
+		if _, err := r.Resolve(ctx, "did:plc:a"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if counting.calls != 1 {
+		t.Errorf("expected the document to be cached, got %d calls", counting.calls)
+	}
+	// Errors are not cached.
+	for i := 0; i < 2; i++ {
+		if _, err := r.Resolve(ctx, "did:web:a"); err == nil {
+			t.Errorf("expected an error")
+		}
+	}
+	if counting.calls != 3 {
+		t.Errorf("expected errors not to be cached, got %d calls", counting.calls)
+	}
+
+	if r.Purge("did:plc:a", time.Minute) {
+		t.Errorf("recently fetched document shouldn't be purged")
+	}
+	if !r.Purge("did:plc:a", 0) {
+		t.Errorf("expected the document to be purged")
+	}
+	if _, err := r.Resolve(ctx, "did:plc:a"); err != nil {
+		t.Fatal(err)
+	}
+	if counting.calls != 4 {
+		t.Errorf("expected the document to be fetched again, got %d calls", counting.calls)
+	}
+}
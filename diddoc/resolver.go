@@ -0,0 +1,145 @@
+package diddoc
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Resolver fetches DID documents.
+type Resolver interface {
+	Resolve(ctx context.Context, did string) (*Document, error)
+}
+
+const DefaultPLCURL = "https://plc.directory"
+
+// defaultClient is used when HTTPResolver.Client is not set. DIDs often come
+// from untrusted input, so don't let a slow host hold up the request forever.
+var defaultClient = &http.Client{Timeout: 10 * time.Second}
+
+// HTTPResolver resolves did:plc and did:web DIDs over HTTP.
+// Zero value is ready to use.
+type HTTPResolver struct {
+	// PLCURL is the base URL of the PLC directory. Defaults to DefaultPLCURL.
+	PLCURL string
+	// Client is used for all requests. Defaults to a client with a 10 second timeout.
+	Client *http.Client
+}
+
+func (r *HTTPResolver) Resolve(ctx context.Context, did string) (*Document, error) {
+	var u string
+	switch {
+	case strings.HasPrefix(did, "did:plc:"):
+		base := r.PLCURL
+		if base == "" {
+			base = DefaultPLCURL
+		}
+		u = strings.TrimSuffix(base, "/") + "/" + did
+	case strings.HasPrefix(did, "did:web:"):
+		id := strings.TrimPrefix(did, "did:web:")
+		if id == "" || strings.Contains(id, ":") {
+			// did:web with paths is not supported by ATproto.
+			return nil, fmt.Errorf("unsupported did:web %q", did)
+		}
+		host, err := url.PathUnescape(id)
+		if err != nil {
+			return nil, fmt.Errorf("invalid did:web %q: %w", did, err)
+		}
+		u = "https://" + host + "/.well-known/did.json"
+	default:
+		return nil, fmt.Errorf("unsupported DID method in %q", did)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return nil, fmt.Errorf("creating request object: %w", err)
+	}
+	client := r.Client
+	if client == nil {
+		client = defaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("fetching DID document: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("fetching DID document for %q: %s", did, resp.Status)
+	}
+	b, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return nil, fmt.Errorf("reading DID document: %w", err)
+	}
+	doc, err := Parse(b)
+	if err != nil {
+		return nil, err
+	}
+	if doc.ID != did {
+		return nil, fmt.Errorf("DID document for %q has mismatching id %q", did, doc.ID)
+	}
+	return doc, nil
+}
+
+// CachingResolver wraps another Resolver and caches successfully resolved documents.
+type CachingResolver struct {
+	resolver Resolver
+	ttl      time.Duration
+
+	mu    sync.Mutex
+	cache map[string]cacheEntry
+}
+
+type cacheEntry struct {
+	doc     *Document
+	fetched time.Time
+	expires time.Time
+}
+
+// NewCachingResolver returns a Resolver that caches the results of r for ttl.
+func NewCachingResolver(r Resolver, ttl time.Duration) *CachingResolver {
+	return &CachingResolver{
+		resolver: r,
+		ttl:      ttl,
+		cache:    map[string]cacheEntry{},
+	}
+}
+
+func (r *CachingResolver) Resolve(ctx context.Context, did string) (*Document, error) {
+	r.mu.Lock()
+	e, ok := r.cache[did]
+	r.mu.Unlock()
+	if ok && time.Now().Before(e.expires) {
+		return e.doc, nil
+	}
+
+	doc, err := r.resolver.Resolve(ctx, did)
+	if err != nil {
+		return nil, err
+	}
+
+	r.mu.Lock()
+	now := time.Now()
+	r.cache[did] = cacheEntry{doc: doc, fetched: now, expires: now.Add(r.ttl)}
+	r.mu.Unlock()
+	return doc, nil
+}
+
+// Purge removes the cached document if it was fetched more than minAge ago,
+// e.g., after a signature check failed and it's possible that the key was rotated.
+// Returns true if the document was removed. minAge limits how often anyone
+// presenting a bad signature can make us fetch the document again.
+func (r *CachingResolver) Purge(did string, minAge time.Duration) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	e, ok := r.cache[did]
+	if !ok || time.Since(e.fetched) < minAge {
+		return false
+	}
+	delete(r.cache, did)
+	return true
+}
@@ -0,0 +1,206 @@
+// Package idempotency implements support for the Idempotency-Key header in the admin API.
+//
+// When a non-GET request carries the header, the first response to it is stored in
+// the database and replayed verbatim for any retries with the same key within the
+// configured window. Keys are scoped to the authenticated principal. Reusing a key
+// with a different request is rejected, as are retries that arrive while the
+// original request is still being processed (for up to [ReservationTimeout]).
+package idempotency
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"github.com/rs/zerolog"
+
+	"bsky.watch/labeler/auth"
+	"bsky.watch/labeler/server"
+)
+
+const (
+	// Header is the name of the request header carrying the idempotency key.
+	Header = "Idempotency-Key"
+	// ReplayedHeader is set on responses that were replayed from a stored record.
+	ReplayedHeader = "Idempotent-Replayed"
+
+	// DefaultWindow is used if the window passed to Middleware is zero.
+	DefaultWindow = 24 * time.Hour
+
+	// ReservationTimeout is how long a request can be in progress before retries
+	// are allowed to take over its key. Without it, a key reserved right before
+	// a crash would be stuck for the whole window.
+	ReservationTimeout = 5 * time.Minute
+
+	maxKeyLength   = 255
+	maxRequestSize = 64 << 20
+)
+
+// Middleware makes requests with the Idempotency-Key header idempotent. Results
+// are stored in s for the duration of window. It should be installed after
+// [auth.Middleware], so that keys of different principals don't collide.
+//
+// Request and response bodies are buffered in memory, so it shouldn't be used
+// for endpoints that stream large amounts of data.
+func Middleware(s *server.Server, window time.Duration, next http.Handler) http.Handler {
+	if window <= 0 {
+		window = DefaultWindow
+	}
+	var lastPurge atomic.Int64
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key := r.Header.Get(Header)
+		if key == "" || r.Method == http.MethodGet || r.Method == http.MethodHead || r.Method == http.MethodOptions {
+			next.ServeHTTP(w, r)
+			return
+		}
+		ctx := r.Context()
+		log := zerolog.Ctx(ctx)
+
+		if len(key) > maxKeyLength {
+			http.Error(w, "Idempotency-Key is too long", http.StatusBadRequest)
+			return
+		}
+
+		body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxRequestSize))
+		if err != nil {
+			var tooLarge *http.MaxBytesError
+			if errors.As(err, &tooLarge) {
+				http.Error(w, "request is too large", http.StatusRequestEntityTooLarge)
+				return
+			}
+			http.Error(w, "failed to read request body", http.StatusBadRequest)
+			return
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+
+		now := time.Now()
+		if last := lastPurge.Load(); now.Sub(time.Unix(last, 0)) > time.Hour && lastPurge.CompareAndSwap(last, now.Unix()) {
+			go func() {
+				if err := s.PurgeIdempotencyKeys(context.WithoutCancel(ctx), now.Add(-window)); err != nil {
+					log.Error().Err(err).Msgf("Failed to purge expired idempotency keys: %s", err)
+				}
+			}()
+		}
+
+		storageKey := key
+		if p := auth.FromContext(ctx); p != nil {
+			storageKey = p.Name + ":" + key
+		}
+		fp := fingerprint(r, body)
+
+		existing, err := s.ReserveIdempotencyKey(ctx, storageKey, fp, now.Add(-window), now.Add(-ReservationTimeout))
+		if err != nil {
+			log.Error().Err(err).Msgf("Failed to reserve idempotency key: %s", err)
+			http.Error(w, "internal error", http.StatusInternalServerError)
+			return
+		}
+		if existing != nil {
+			switch {
+			case existing.Fingerprint != fp:
+				rejectedRequests.WithLabelValues("mismatch").Inc()
+				http.Error(w, "Idempotency-Key was already used for a different request", http.StatusUnprocessableEntity)
+			case existing.Status == 0:
+				rejectedRequests.WithLabelValues("in_progress").Inc()
+				http.Error(w, "request with this Idempotency-Key is still being processed", http.StatusConflict)
+			default:
+				replayedRequests.Inc()
+				replay(w, existing)
+			}
+			return
+		}
+
+		rec := &recorder{ResponseWriter: w}
+		completed := false
+		defer func() {
+			if completed {
+				return
+			}
+			// Either the handler panicked or returned a server error. Let the client retry.
+			if err := s.ReleaseIdempotencyKey(context.WithoutCancel(ctx), storageKey); err != nil {
+				log.Error().Err(err).Msgf("Failed to release idempotency key: %s", err)
+			}
+		}()
+
+		next.ServeHTTP(rec, r)
+
+		if rec.status == 0 {
+			rec.WriteHeader(http.StatusOK)
+		}
+		if rec.status >= 500 {
+			return
+		}
+		header, err := json.Marshal(rec.header)
+		if err != nil {
+			log.Error().Err(err).Msgf("Failed to serialize response headers: %s", err)
+			return
+		}
+		err = s.CompleteIdempotencyKey(context.WithoutCancel(ctx), storageKey, rec.status, header, rec.body.Bytes())
+		if err != nil {
+			log.Error().Err(err).Msgf("Failed to store the response for idempotency key: %s", err)
+			return
+		}
+		completed = true
+	})
+}
+
+func fingerprint(r *http.Request, body []byte) string {
+	h := sha256.New()
+	io.WriteString(h, r.Method)
+	io.WriteString(h, "\n")
+	io.WriteString(h, r.URL.RequestURI())
+	io.WriteString(h, "\n")
+	h.Write(body)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+func replay(w http.ResponseWriter, rec *server.IdempotencyRecord) {
+	header := http.Header{}
+	if len(rec.Header) > 0 {
+		// Ignoring the error, worst case we'll be missing some headers.
+		_ = json.Unmarshal(rec.Header, &header)
+	}
+	for k, v := range header {
+		w.Header()[k] = v
+	}
+	w.Header().Set(ReplayedHeader, "true")
+	w.WriteHeader(rec.Status)
+	w.Write(rec.Body)
+}
+
+// recorder passes the response through to the client, while keeping a copy of it.
+type recorder struct {
+	http.ResponseWriter
+	status int
+	header http.Header
+	body   bytes.Buffer
+}
+
+func (r *recorder) WriteHeader(status int) {
+	if r.status != 0 {
+		return
+	}
+	r.status = status
+	r.header = r.ResponseWriter.Header().Clone()
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *recorder) Write(b []byte) (int, error) {
+	if r.status == 0 {
+		r.WriteHeader(http.StatusOK)
+	}
+	r.body.Write(b)
+	return r.ResponseWriter.Write(b)
+}
+
+// Unwrap allows http.ResponseController to reach the underlying ResponseWriter.
+func (r *recorder) Unwrap() http.ResponseWriter {
+	return r.ResponseWriter
+}
@@ -0,0 +1,21 @@
+package idempotency
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var (
+	replayedRequests = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: "labeler",
+		Subsystem: "idempotency",
+		Name:      "replayed_requests_total",
+		Help:      "Number of admin API requests answered with a stored response.",
+	})
+	rejectedRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "labeler",
+		Subsystem: "idempotency",
+		Name:      "rejected_requests_total",
+		Help:      "Number of admin API requests rejected due to a conflicting use of an idempotency key.",
+	}, []string{"reason"})
+)
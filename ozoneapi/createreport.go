@@ -0,0 +1,206 @@
+package ozoneapi
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog"
+
+	comatproto "github.com/bluesky-social/indigo/api/atproto"
+
+	"bsky.watch/labeler/auth"
+	"bsky.watch/labeler/config"
+	"bsky.watch/labeler/diddoc"
+	"bsky.watch/labeler/server"
+)
+
+const (
+	createReportMethod = "com.atproto.moderation.createReport"
+	reasonAppeal       = "com.atproto.moderation.defs#reasonAppeal"
+	maxReasonLength    = 20000
+	// maxRequestSize leaves enough room for a reason of maxReasonLength
+	// with every character escaped.
+	maxRequestSize = 8 * maxReasonLength
+)
+
+// DefaultReportRateLimits are used if none are specified in the config.
+var DefaultReportRateLimits = []config.RateLimit{
+	{Count: 5, Period: time.Minute},
+	{Count: 50, Period: 24 * time.Hour},
+}
+
+type ReportHandler struct {
+	server     *server.Server
+	did        string
+	resolver   diddoc.Resolver
+	rateLimits []config.RateLimit
+}
+
+// NewCreateReport returns HTTP handler that implements
+// [com.atproto.moderation.createReport](https://github.com/bluesky-social/atproto/blob/main/lexicons/com/atproto/moderation/createReport.json)
+// XRPC method. Requests must be authenticated with a service auth token
+// issued by reporter's PDS, with the audience set to labeler's DID.
+//
+// Reports with `reasonAppeal` reason type are stored as appeals against
+// the current labels of the subject.
+func NewCreateReport(server *server.Server, cfg *config.Config, resolver diddoc.Resolver) *ReportHandler {
+	limits := cfg.Reports.RateLimits
+	if len(limits) == 0 {
+		limits = DefaultReportRateLimits
+	}
+	return &ReportHandler{
+		server:     server,
+		did:        cfg.DID,
+		resolver:   resolver,
+		rateLimits: limits,
+	}
+}
+
+func (h *ReportHandler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPost {
+		writeJSON(w, http.StatusMethodNotAllowed, &xrpcError{Name: "InvalidRequest", Message: "method not allowed"})
+		return
+	}
+	ctx := req.Context()
+	log := zerolog.Ctx(ctx)
+
+	token, ok := strings.CutPrefix(req.Header.Get("Authorization"), "Bearer ")
+	if !ok || token == "" {
+		reportsRejected.WithLabelValues("no_credentials").Inc()
+		writeJSON(w, http.StatusUnauthorized, &xrpcError{Name: "AuthenticationRequired", Message: "authentication required"})
+		return
+	}
+	reporter, err := auth.VerifyServiceAuth(ctx, h.resolver, token, h.did, createReportMethod)
+	if err != nil {
+		reportsRejected.WithLabelValues("invalid_credentials").Inc()
+		log.Info().Err(err).Str("remote", req.RemoteAddr).Msgf("Rejected report with invalid credentials: %s", err)
+		writeJSON(w, http.StatusUnauthorized, &xrpcError{Name: "AuthenticationRequired", Message: err.Error()})
+		return
+	}
+
+	input := &comatproto.ModerationCreateReport_Input{}
+	if err := json.NewDecoder(http.MaxBytesReader(w, req.Body, maxRequestSize)).Decode(input); err != nil {
+		if tooLarge := (*http.MaxBytesError)(nil); errors.As(err, &tooLarge) {
+			reportsRejected.WithLabelValues("invalid").Inc()
+			writeJSON(w, http.StatusRequestEntityTooLarge, invalidRequest("request is too large"))
+			return
+		}
+		writeJSON(w, http.StatusBadRequest, invalidRequest("parsing request body: %s", err))
+		return
+	}
+
+	out, err := h.createReport(ctx, reporter, input)
+	if err != nil {
+		if err, ok := err.(*xrpcError); ok {
+			writeJSON(w, err.status, err)
+			return
+		}
+		log.Error().Err(err).Msgf("Failed to create a report: %s", err)
+		writeJSON(w, http.StatusInternalServerError, &xrpcError{Name: "InternalServerError", Message: "failed to store the report"})
+		return
+	}
+	writeJSON(w, http.StatusOK, out)
+}
+
+func (h *ReportHandler) createReport(ctx context.Context, reporter string, input *comatproto.ModerationCreateReport_Input) (*comatproto.ModerationCreateReport_Output, error) {
+	log := zerolog.Ctx(ctx)
+
+	if input.ReasonType == nil || *input.ReasonType == "" {
+		reportsRejected.WithLabelValues("invalid").Inc()
+		return nil, invalidRequest("missing reasonType")
+	}
+	if input.Reason != nil && len(*input.Reason) > maxReasonLength {
+		reportsRejected.WithLabelValues("invalid").Inc()
+		return nil, invalidRequest("reason is too long")
+	}
+	if input.Subject == nil {
+		reportsRejected.WithLabelValues("invalid").Inc()
+		return nil, invalidRequest("missing subject")
+	}
+
+	report := &server.Report{
+		ReportedBy: reporter,
+		ReasonType: *input.ReasonType,
+	}
+	if input.Reason != nil {
+		report.Reason = *input.Reason
+	}
+	subject := &comatproto.ModerationCreateReport_Output_Subject{}
+	switch {
+	case input.Subject.AdminDefs_RepoRef != nil:
+		report.SubjectUri = input.Subject.AdminDefs_RepoRef.Did
+		if !strings.HasPrefix(report.SubjectUri, "did:") {
+			reportsRejected.WithLabelValues("invalid").Inc()
+			return nil, invalidRequest("invalid DID %q", report.SubjectUri)
+		}
+		subject.AdminDefs_RepoRef = input.Subject.AdminDefs_RepoRef
+	case input.Subject.RepoStrongRef != nil:
+		report.SubjectUri = input.Subject.RepoStrongRef.Uri
+		report.SubjectCid = input.Subject.RepoStrongRef.Cid
+		if !strings.HasPrefix(report.SubjectUri, "at://") {
+			reportsRejected.WithLabelValues("invalid").Inc()
+			return nil, invalidRequest("invalid AT URI %q", report.SubjectUri)
+		}
+		subject.RepoStrongRef = input.Subject.RepoStrongRef
+	default:
+		reportsRejected.WithLabelValues("invalid").Inc()
+		return nil, invalidRequest("unsupported subject type")
+	}
+
+	if report.ReasonType == reasonAppeal {
+		appeal := &server.Appeal{
+			ReportedBy: report.ReportedBy,
+			SubjectUri: report.SubjectUri,
+			SubjectCid: report.SubjectCid,
+			Reason:     report.Reason,
+		}
+		if err := h.server.CreateAppeal(ctx, appeal, h.rateLimits...); err != nil {
+			if errors.Is(err, server.ErrNothingToAppeal) {
+				reportsRejected.WithLabelValues("invalid").Inc()
+				return nil, invalidRequest("%s", err)
+			}
+			return nil, rateLimited(ctx, reporter, err)
+		}
+		report.ID = appeal.ID
+		report.CreatedAt = appeal.CreatedAt
+		appealsCreated.Inc()
+		log.Info().Int64("id", appeal.ID).Str("reporter", reporter).Str("subject", appeal.SubjectUri).
+			Msgf("New appeal #%d", appeal.ID)
+	} else {
+		if err := h.server.CreateReport(ctx, report, h.rateLimits...); err != nil {
+			return nil, rateLimited(ctx, reporter, err)
+		}
+		reportsCreated.Inc()
+		log.Info().Int64("id", report.ID).Str("reporter", reporter).Str("subject", report.SubjectUri).
+			Str("reason_type", report.ReasonType).Msgf("New report #%d", report.ID)
+	}
+
+	return &comatproto.ModerationCreateReport_Output{
+		Id:         report.ID,
+		CreatedAt:  report.CreatedAt.Format(time.RFC3339Nano),
+		ReasonType: input.ReasonType,
+		Reason:     input.Reason,
+		ReportedBy: reporter,
+		Subject:    subject,
+	}, nil
+}
+
+// rateLimited converts *server.RateLimitError into an XRPC error, and returns other errors as is.
+func rateLimited(ctx context.Context, reporter string, err error) error {
+	limitErr := (*server.RateLimitError)(nil)
+	if !errors.As(err, &limitErr) {
+		return err
+	}
+	reportsRejected.WithLabelValues("rate_limited").Inc()
+	zerolog.Ctx(ctx).Info().Str("reporter", reporter).
+		Msgf("Report rejected: more than %d reports in %s", limitErr.Limit.Count, limitErr.Limit.Period)
+	return &xrpcError{
+		status:  http.StatusTooManyRequests,
+		Name:    "RateLimitExceeded",
+		Message: limitErr.Error(),
+	}
+}
@@ -0,0 +1,197 @@
+// Package ozoneapi implements a subset of Ozone's XRPC methods, so that
+// existing moderation tools written for Ozone can be used with this labeler.
+//
+// Only `tools.ozone.moderation.emitEvent` with `#modEventLabel` events is supported.
+// Events are not stored anywhere, only the resulting label changes. Comments are
+// written to the log.
+//
+// User reports are accepted via `com.atproto.moderation.createReport` and stored
+// in the database.
+package ozoneapi
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog"
+
+	comatproto "github.com/bluesky-social/indigo/api/atproto"
+	"github.com/bluesky-social/indigo/api/ozone"
+
+	"bsky.watch/labeler/auth"
+	"bsky.watch/labeler/server"
+)
+
+type Handler struct {
+	server *server.Server
+}
+
+// eventView is the response of emitEvent, with an extension to the lexicon
+// for labels that are not published yet.
+type eventView struct {
+	*ozone.ModerationDefs_ModEventView
+	// DelayedLabelIDs lists IDs of the labels staged for publication later
+	// because of a configured delay. They can be cancelled until then.
+	DelayedLabelIDs []int64 `json:"delayedLabelIds,omitempty"`
+}
+
+// NewEmitEvent returns HTTP handler that implements
+// [tools.ozone.moderation.emitEvent](https://github.com/bluesky-social/atproto/blob/main/lexicons/tools/ozone/moderation/emitEvent.json)
+// XRPC method.
+func NewEmitEvent(server *server.Server) *Handler {
+	return &Handler{server: server}
+}
+
+func (h *Handler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPost {
+		writeJSON(w, http.StatusMethodNotAllowed, &xrpcError{Name: "InvalidRequest", Message: "method not allowed"})
+		return
+	}
+
+	input := &ozone.ModerationEmitEvent_Input{}
+	if err := json.NewDecoder(req.Body).Decode(input); err != nil {
+		writeJSON(w, http.StatusBadRequest, invalidRequest("parsing request body: %s", err))
+		return
+	}
+
+	view, err := h.emitEvent(req.Context(), input)
+	if err != nil {
+		if err, ok := err.(*xrpcError); ok {
+			writeJSON(w, err.status, err)
+			return
+		}
+		writeJSON(w, http.StatusInternalServerError, &xrpcError{Name: "InternalServerError", Message: err.Error()})
+		return
+	}
+	writeJSON(w, http.StatusOK, view)
+}
+
+func (h *Handler) emitEvent(ctx context.Context, input *ozone.ModerationEmitEvent_Input) (*eventView, error) {
+	log := zerolog.Ctx(ctx)
+
+	if input.Event == nil || input.Event.ModerationDefs_ModEventLabel == nil {
+		return nil, invalidRequest("only #modEventLabel events are supported")
+	}
+	event := input.Event.ModerationDefs_ModEventLabel
+	if input.Subject == nil {
+		return nil, invalidRequest("missing subject")
+	}
+
+	var uri string
+	var cid *string
+	view := &ozone.ModerationDefs_ModEventView_Subject{}
+	switch {
+	case input.Subject.AdminDefs_RepoRef != nil:
+		uri = input.Subject.AdminDefs_RepoRef.Did
+		if !strings.HasPrefix(uri, "did:") {
+			return nil, invalidRequest("invalid DID %q", uri)
+		}
+		view.AdminDefs_RepoRef = input.Subject.AdminDefs_RepoRef
+	case input.Subject.RepoStrongRef != nil:
+		uri = input.Subject.RepoStrongRef.Uri
+		if !strings.HasPrefix(uri, "at://") {
+			return nil, invalidRequest("invalid AT URI %q", uri)
+		}
+		if input.Subject.RepoStrongRef.Cid != "" {
+			cid = &input.Subject.RepoStrongRef.Cid
+		}
+		view.RepoStrongRef = input.Subject.RepoStrongRef
+	default:
+		return nil, invalidRequest("unsupported subject type")
+	}
+
+	// `createdBy` is supplied by the client, so it's only echoed back and logged.
+	// Changes are always attributed to the authenticated principal.
+	actor := auth.Name(ctx)
+	createdBy := input.CreatedBy
+	if createdBy == "" || strings.HasPrefix(actor, "did:") {
+		createdBy = actor
+	}
+
+	labels := []comatproto.LabelDefs_Label{}
+	for _, val := range event.NegateLabelVals {
+		labels = append(labels, comatproto.LabelDefs_Label{Uri: uri, Cid: cid, Val: val, Neg: ptr(true)})
+	}
+	for _, val := range event.CreateLabelVals {
+		labels = append(labels, comatproto.LabelDefs_Label{Uri: uri, Cid: cid, Val: val})
+	}
+	if len(labels) == 0 {
+		return nil, invalidRequest("at least one of createLabelVals and negateLabelVals must be non-empty")
+	}
+
+	// Validate everything upfront, so that we don't end up applying only some of the labels.
+	needApproval := 0
+	for _, label := range labels {
+		if err := auth.CheckLabel(ctx, label.Val); err != nil {
+			return nil, &xrpcError{status: http.StatusForbidden, Name: "Forbidden", Message: err.Error()}
+		}
+		if err := h.server.ValidateLabel(label); err != nil {
+			return nil, invalidRequest("%s", err)
+		}
+		if h.server.RequiresApproval(label.Val) {
+			needApproval++
+		}
+	}
+	if needApproval > 0 && needApproval < len(labels) {
+		return nil, invalidRequest("labels that require approval must be sent in a separate event")
+	}
+
+	results, err := h.server.AddLabels(ctx, labels, server.WithActor(actor), server.RequireApproval())
+	if err != nil {
+		return nil, fmt.Errorf("writing labels: %w", err)
+	}
+	var id int64
+	approvals := []string{}
+	delayed := []int64{}
+	for _, r := range results {
+		if pending := (*server.PendingApprovalError)(nil); errors.As(r.Err, &pending) {
+			approvals = append(approvals, fmt.Sprint(pending.ID))
+			continue
+		}
+		if r.Err != nil {
+			return nil, invalidRequest("%s", r.Err)
+		}
+		if r.DelayedID != 0 {
+			delayed = append(delayed, r.DelayedID)
+			continue
+		}
+		id = max(id, r.Seq)
+	}
+	if len(approvals) > 0 {
+		// Nothing was written yet, so there's no event to return. Respond with
+		// an error, so that clients don't assume that the labels were applied.
+		return nil, &xrpcError{status: http.StatusForbidden, Name: "ApprovalRequired",
+			Message: fmt.Sprintf("labels require approval by another moderator, created approval requests: %s", strings.Join(approvals, ", "))}
+	}
+
+	comment := ""
+	if event.Comment != nil {
+		comment = *event.Comment
+	}
+	log.Info().Str("subject", uri).Str("actor", actor).Str("created_by", createdBy).
+		Strs("create", event.CreateLabelVals).Strs("negate", event.NegateLabelVals).
+		Ints64("delayed", delayed).Msgf("Label event: %s", comment)
+
+	blobs := input.SubjectBlobCids
+	if blobs == nil {
+		blobs = []string{}
+	}
+	return &eventView{
+		ModerationDefs_ModEventView: &ozone.ModerationDefs_ModEventView{
+			// We don't store events, so the ID is the sequence number of the last label written,
+			// or 0 if all of them were delayed.
+			Id:              id,
+			Event:           &ozone.ModerationDefs_ModEventView_Event{ModerationDefs_ModEventLabel: event},
+			Subject:         view,
+			SubjectBlobCids: blobs,
+			CreatedBy:       createdBy,
+			CreatedAt:       time.Now().UTC().Format(time.RFC3339Nano),
+		},
+		DelayedLabelIDs: delayed,
+	}, nil
+}
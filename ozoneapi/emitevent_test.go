@@ -0,0 +1,159 @@
+package ozoneapi
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	comatproto "github.com/bluesky-social/indigo/api/atproto"
+	"github.com/bluesky-social/indigo/api/ozone"
+
+	"bsky.watch/labeler/auth"
+	"bsky.watch/labeler/config"
+	"bsky.watch/labeler/server"
+)
+
+func newTestServer(t *testing.T) *server.Server {
+	t.Helper()
+	s, err := server.NewWithConfig(context.Background(), &config.Config{
+		SQLiteDB:   fmt.Sprintf("file:%s?mode=memory&cache=shared", t.Name()),
+		DID:        "did:plc:labeler",
+		PrivateKey: "c6d40ec53c689ca905036e41d8c73560777e5746d1d228fd6f9db56efed8ecaf",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func labelEvent(subject string, createdBy string, create ...string) *ozone.ModerationEmitEvent_Input {
+	return &ozone.ModerationEmitEvent_Input{
+		CreatedBy: createdBy,
+		Event: &ozone.ModerationEmitEvent_Input_Event{ModerationDefs_ModEventLabel: &ozone.ModerationDefs_ModEventLabel{
+			CreateLabelVals: create,
+			NegateLabelVals: []string{},
+		}},
+		Subject: &ozone.ModerationEmitEvent_Input_Subject{AdminDefs_RepoRef: &comatproto.AdminDefs_RepoRef{Did: subject}},
+	}
+}
+
+func TestEmitEventActor(t *testing.T) {
+	s := newTestServer(t)
+	h := NewEmitEvent(s)
+
+	tests := []struct {
+		principal string
+		createdBy string
+		wantActor string
+	}{
+		{principal: "alice", createdBy: "", wantActor: "alice"},
+		{principal: "alice", createdBy: "bob", wantActor: "alice"},
+		{principal: "alice", createdBy: "did:plc:bob", wantActor: "alice"},
+		{principal: "did:plc:alice", createdBy: "did:plc:bob", wantActor: "did:plc:alice"},
+	}
+	for i, test := range tests {
+		subject := fmt.Sprintf("did:plc:subject%d", i)
+		ctx := auth.NewContext(context.Background(), &auth.Principal{Name: test.principal, Labels: []string{"*"}})
+		view, err := h.emitEvent(ctx, labelEvent(subject, test.createdBy, "spam"))
+		if err != nil {
+			t.Fatalf("%+v: %s", test, err)
+		}
+		if view.CreatedBy == "" {
+			t.Errorf("%+v: empty createdBy in the response", test)
+		}
+		history, err := s.SubjectHistory(context.Background(), subject)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(history) != 1 || history[0].Actor != test.wantActor {
+			t.Errorf("%+v: expected one entry written by %q, got %+v", test, test.wantActor, history)
+		}
+	}
+}
+
+func TestEmitEventSelfApproval(t *testing.T) {
+	s := newTestServer(t)
+	s.SetSystemLabels([]string{"!takedown"})
+	s.SetApprovalPolicy(config.Approval{Labels: []string{"!takedown"}})
+	h := NewEmitEvent(s)
+
+	ctx := auth.NewContext(context.Background(), &auth.Principal{Name: "alice", Labels: []string{"*"}})
+	_, err := h.emitEvent(ctx, labelEvent("did:plc:subject", "bob", "!takedown"))
+	xerr := (*xrpcError)(nil)
+	if !errors.As(err, &xerr) || xerr.Name != "ApprovalRequired" {
+		t.Fatalf("expected ApprovalRequired, got %v", err)
+	}
+
+	requests, err := s.PendingApprovalRequests(context.Background(), 0, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(requests) != 1 || requests[0].RequestedBy != "alice" {
+		t.Fatalf("expected one request by alice, got %+v", requests)
+	}
+	if _, err := s.ApproveRequest(context.Background(), requests[0].ID, "alice", ""); !errors.Is(err, server.ErrSelfApproval) {
+		t.Errorf("expected ErrSelfApproval, got %v", err)
+	}
+	if _, err := s.ApproveRequest(context.Background(), requests[0].ID, "bob", ""); err != nil {
+		t.Errorf("expected approval by another moderator to succeed, got %v", err)
+	}
+}
+
+func TestEmitEventDelayed(t *testing.T) {
+	s := newTestServer(t)
+	s.SetPublicationDelay(map[string]time.Duration{"spam": time.Hour})
+	h := NewEmitEvent(s)
+	ctx := auth.NewContext(context.Background(), &auth.Principal{Name: "alice", Labels: []string{"*"}})
+
+	input, err := json.Marshal(labelEvent("did:plc:subject", "", "spam", "rude"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req := httptest.NewRequest("POST", "/xrpc/tools.ozone.moderation.emitEvent", bytes.NewReader(input)).WithContext(ctx)
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("unexpected status %d: %s", w.Code, w.Body)
+	}
+	resp := struct {
+		ID              int64   `json:"id"`
+		DelayedLabelIDs []int64 `json:"delayedLabelIds"`
+	}{}
+	if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+		t.Fatal(err)
+	}
+
+	// Only the label that wasn't delayed is published.
+	history, err := s.SubjectHistory(context.Background(), "did:plc:subject")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(history) != 1 || history[0].Val != "rude" || resp.ID != history[0].Seq {
+		t.Errorf("expected id %d to be the sequence number of %+v", resp.ID, history)
+	}
+	if len(resp.DelayedLabelIDs) != 1 {
+		t.Fatalf("expected one delayed label in the response, got %v", resp.DelayedLabelIDs)
+	}
+	delayed, err := s.GetDelayedLabel(context.Background(), resp.DelayedLabelIDs[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if delayed.Val != "spam" {
+		t.Errorf("unexpected delayed label %+v", delayed)
+	}
+
+	// All labels delayed.
+	view, err := h.emitEvent(ctx, labelEvent("did:plc:other", "", "spam"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if view.Id != 0 || len(view.DelayedLabelIDs) != 1 {
+		t.Errorf("expected no id and one delayed label, got %d and %v", view.Id, view.DelayedLabelIDs)
+	}
+}
@@ -0,0 +1,27 @@
+package ozoneapi
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var (
+	reportsCreated = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: "labeler",
+		Subsystem: "reports",
+		Name:      "created_total",
+		Help:      "Number of user reports accepted.",
+	})
+	appealsCreated = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: "labeler",
+		Subsystem: "reports",
+		Name:      "appeals_created_total",
+		Help:      "Number of appeals accepted.",
+	})
+	reportsRejected = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "labeler",
+		Subsystem: "reports",
+		Name:      "rejected_total",
+		Help:      "Number of user reports rejected.",
+	}, []string{"reason"})
+)
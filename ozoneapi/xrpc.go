@@ -0,0 +1,29 @@
+package ozoneapi
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+)
+
+type xrpcError struct {
+	status  int
+	Name    string `json:"error"`
+	Message string `json:"message"`
+}
+
+func (err *xrpcError) Error() string {
+	return fmt.Sprintf("%s: %s", err.Name, err.Message)
+}
+
+func invalidRequest(format string, args ...any) *xrpcError {
+	return &xrpcError{status: http.StatusBadRequest, Name: "InvalidRequest", Message: fmt.Sprintf(format, args...)}
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func ptr[T any](v T) *T { return &v }
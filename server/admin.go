@@ -0,0 +1,58 @@
+package server
+
+import (
+	"context"
+	"fmt"
+)
+
+// SubjectsWithLabel returns current (i.e., not negated) labels with the value `val`,
+// ordered by sequence number. Only entries with sequence number greater than `after`
+// are returned, so the last returned Seq can be used as a cursor for the next page.
+// Does not filter out expired entries.
+func (s *Server) SubjectsWithLabel(ctx context.Context, val string, after int64, limit int) ([]Entry, error) {
+	var entries []Entry
+	err := s.db.WithContext(ctx).Model(&Entry{}).
+		Where("val = ? and seq > ? and neg = ?", val, after, false).
+		Where("not exists (?)", s.db.Model(&Entry{}).Select("1").Table("log as newer").
+			Where("newer.src = log.src and newer.val = log.val and newer.uri = log.uri and newer.cid = log.cid and newer.seq > log.seq")).
+		Order("seq asc").
+		Limit(limit).
+		Find(&entries).Error
+	if err != nil {
+		return nil, fmt.Errorf("querying labeled subjects: %w", err)
+	}
+	return entries, nil
+}
+
+// SubjectHistory returns all entries ever written for the subject `uri`, including
+// negations, ordered by sequence number.
+func (s *Server) SubjectHistory(ctx context.Context, uri string) ([]Entry, error) {
+	var entries []Entry
+	err := s.db.WithContext(ctx).Model(&Entry{}).
+		Where("uri = ?", uri).
+		Order("seq asc").
+		Find(&entries).Error
+	if err != nil {
+		return nil, fmt.Errorf("querying subject history: %w", err)
+	}
+	return entries, nil
+}
+
+// SubjectLabels returns current (i.e., not negated) labels of the subject `uri`.
+// Does not filter out expired entries.
+func (s *Server) SubjectLabels(ctx context.Context, uri string) ([]Entry, error) {
+	return s.query(ctx, queryRequestGet{UriPatterns: []string{uri}})
+}
+
+// RecentEntries returns up to `limit` most recently written entries, newest first.
+func (s *Server) RecentEntries(ctx context.Context, limit int) ([]Entry, error) {
+	var entries []Entry
+	err := s.db.WithContext(ctx).Model(&Entry{}).
+		Order("seq desc").
+		Limit(limit).
+		Find(&entries).Error
+	if err != nil {
+		return nil, fmt.Errorf("querying recent entries: %w", err)
+	}
+	return entries, nil
+}
@@ -0,0 +1,874 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+
+	comatproto "github.com/bluesky-social/indigo/api/atproto"
+
+	"bsky.watch/labeler/config"
+)
+
+func TestSubjectsWithLabel(t *testing.T) {
+	ctx := context.Background()
+	server, err := NewTestServer(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	labels := []comatproto.LabelDefs_Label{
+		{Uri: "did:plc:a", Val: "x"},
+		{Uri: "did:plc:b", Val: "x"},
+		{Uri: "did:plc:c", Val: "x"},
+		{Uri: "did:plc:d", Val: "y"},
+		{Uri: "did:plc:b", Val: "x", Neg: ptr(true)},
+		{Uri: "did:plc:c", Val: "x", Neg: ptr(true)},
+		{Uri: "did:plc:c", Val: "x"},
+		{Uri: "did:plc:e", Val: "x"},
+	}
+	for _, l := range labels {
+		if _, err := server.AddLabel(ctx, l); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	got := []string{}
+	after := int64(0)
+	for {
+		entries, err := server.SubjectsWithLabel(ctx, "x", after, 2)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, e := range entries {
+			got = append(got, e.Uri)
+			after = e.Seq
+		}
+		if len(entries) < 2 {
+			break
+		}
+	}
+	if diff := cmp.Diff([]string{"did:plc:a", "did:plc:c", "did:plc:e"}, got); diff != "" {
+		t.Errorf("SubjectsWithLabel: %s", diff)
+	}
+
+	history, err := server.SubjectHistory(ctx, "did:plc:c")
+	if err != nil {
+		t.Fatal(err)
+	}
+	negs := []bool{}
+	for _, e := range history {
+		negs = append(negs, e.Neg)
+	}
+	if diff := cmp.Diff([]bool{false, true, false}, negs); diff != "" {
+		t.Errorf("SubjectHistory: %s", diff)
+	}
+}
+
+func TestRevert(t *testing.T) {
+	ctx := context.Background()
+	server, err := NewTestServer(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	writes := []struct {
+		actor string
+		label comatproto.LabelDefs_Label
+	}{
+		{"alice", comatproto.LabelDefs_Label{Uri: "did:plc:a", Val: "x"}},
+		{"bot", comatproto.LabelDefs_Label{Uri: "did:plc:a", Val: "x", Neg: ptr(true)}},
+		{"bot", comatproto.LabelDefs_Label{Uri: "did:plc:b", Val: "x"}},
+		{"bot", comatproto.LabelDefs_Label{Uri: "did:plc:c", Val: "y"}},
+		{"alice", comatproto.LabelDefs_Label{Uri: "did:plc:c", Val: "y", Neg: ptr(true)}},
+		{"bot", comatproto.LabelDefs_Label{Uri: "did:plc:d", Val: "x"}},
+		{"bot", comatproto.LabelDefs_Label{Uri: "did:plc:d", Val: "x", Neg: ptr(true)}},
+	}
+	for _, w := range writes {
+		if _, err := server.AddLabel(ctx, w.label, WithActor(w.actor)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, dryRun := range []bool{true, false} {
+		opts := []WriteOption{WithActor("admin")}
+		if dryRun {
+			opts = append(opts, DryRun())
+		}
+		r, err := server.Revert(ctx, RevertFilter{FromSeq: 2, Actor: "bot"}, opts...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got := []string{}
+		for _, item := range r.Items {
+			if item.Err != nil || !item.Changed {
+				t.Errorf("unexpected result for %+v: %+v", item.Label, item.LabelResult)
+			}
+			s := "+" + item.Label.Uri
+			if item.Label.Neg != nil && *item.Label.Neg {
+				s = "-" + item.Label.Uri
+			}
+			got = append(got, s)
+		}
+		if diff := cmp.Diff([]string{"+did:plc:a", "-did:plc:b"}, got); diff != "" {
+			t.Errorf("unexpected reverts (dry run: %v) (-want +got):\n%s", dryRun, diff)
+		}
+		if len(r.Conflicts) != 1 || r.Conflicts[0].Uri != "did:plc:c" {
+			t.Errorf("unexpected conflicts: %+v", r.Conflicts)
+		}
+		if r.LastSeq != int64(len(writes)) {
+			t.Errorf("LastSeq = %d, want %d", r.LastSeq, len(writes))
+		}
+	}
+
+	current := []string{}
+	for _, uri := range []string{"did:plc:a", "did:plc:b", "did:plc:c", "did:plc:d"} {
+		entries, err := server.SubjectLabels(ctx, uri)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, e := range entries {
+			current = append(current, e.Uri+" "+e.Val+" "+e.Actor)
+		}
+	}
+	if diff := cmp.Diff([]string{"did:plc:a x admin"}, current); diff != "" {
+		t.Errorf("unexpected state after revert (-want +got):\n%s", diff)
+	}
+}
+
+func TestReports(t *testing.T) {
+	ctx := context.Background()
+	server, err := NewTestServer(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, uri := range []string{"did:plc:a", "did:plc:b", "did:plc:a"} {
+		r := &Report{ReportedBy: "did:plc:reporter", SubjectUri: uri, ReasonType: "spam"}
+		if err := server.CreateReport(ctx, r); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	groups, err := server.OpenReportsBySubject(ctx, 0, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := map[string][]int64{}
+	for _, g := range groups {
+		for _, r := range g.Reports {
+			got[g.SubjectUri] = append(got[g.SubjectUri], r.ID)
+		}
+	}
+	if diff := cmp.Diff(map[string][]int64{"did:plc:a": {1, 3}, "did:plc:b": {2}}, got); diff != "" {
+		t.Errorf("unexpected groups (-want +got):\n%s", diff)
+	}
+
+	if err := server.AssignReports(ctx, []int64{1, 3}, "mod"); err != nil {
+		t.Fatal(err)
+	}
+	results, err := server.ResolveReports(ctx, []int64{1, 3}, "mod", "yep",
+		[]comatproto.LabelDefs_Label{{Uri: "did:plc:a", Val: "spam"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 1 || !results[0].Changed {
+		t.Fatalf("unexpected results: %+v", results)
+	}
+	r, err := server.GetReport(ctx, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.Status != ReportResolved || r.AssignedTo != "mod" || len(r.LabelSeqs) != 1 || r.LabelSeqs[0].Seq != results[0].Seq {
+		t.Errorf("unexpected report after resolving: %+v", r)
+	}
+
+	// Resolving the same report again must fail without writing any labels.
+	_, err = server.ResolveReports(ctx, []int64{1}, "mod", "",
+		[]comatproto.LabelDefs_Label{{Uri: "did:plc:a", Val: "other"}})
+	if !errors.Is(err, ErrReportNotOpen) {
+		t.Errorf("expected ErrReportNotOpen, got %v", err)
+	}
+	entries, err := server.SubjectHistory(ctx, "did:plc:a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 {
+		t.Errorf("expected a single entry, got %+v", entries)
+	}
+
+	groups, err = server.OpenReportsBySubject(ctx, 0, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(groups) != 1 || groups[0].SubjectUri != "did:plc:b" {
+		t.Errorf("unexpected open reports: %+v", groups)
+	}
+}
+
+func TestReportRateLimits(t *testing.T) {
+	ctx := context.Background()
+	server, err := NewTestServer(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := server.AddLabel(ctx, comatproto.LabelDefs_Label{Uri: "did:plc:reporter", Val: "spam"}); err != nil {
+		t.Fatal(err)
+	}
+	limits := []config.RateLimit{{Count: 3, Period: time.Hour}}
+
+	for i := 0; i < 2; i++ {
+		if err := server.CreateReport(ctx, &Report{ReportedBy: "did:plc:reporter", SubjectUri: "did:plc:a", ReasonType: "spam"}, limits...); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Appeals count towards the same limit.
+	if err := server.CreateAppeal(ctx, &Appeal{ReportedBy: "did:plc:reporter", SubjectUri: "did:plc:reporter"}, limits...); err != nil {
+		t.Fatal(err)
+	}
+	limitErr := (*RateLimitError)(nil)
+	if err := server.CreateReport(ctx, &Report{ReportedBy: "did:plc:reporter", SubjectUri: "did:plc:a", ReasonType: "spam"}, limits...); !errors.As(err, &limitErr) {
+		t.Errorf("expected RateLimitError, got %v", err)
+	}
+	if err := server.CreateAppeal(ctx, &Appeal{ReportedBy: "did:plc:reporter", SubjectUri: "did:plc:reporter"}, limits...); !errors.As(err, &limitErr) {
+		t.Errorf("expected RateLimitError, got %v", err)
+	}
+	if err := server.CreateReport(ctx, &Report{ReportedBy: "did:plc:other", SubjectUri: "did:plc:a", ReasonType: "spam"}, limits...); err != nil {
+		t.Errorf("other reporters must not be limited, got %v", err)
+	}
+	if n, err := server.CountReports(ctx, "did:plc:reporter", time.Now().Add(-time.Hour)); err != nil || n != 3 {
+		t.Errorf("expected 3 reports and appeals to be stored, got %d, %v", n, err)
+	}
+}
+
+func TestAppeals(t *testing.T) {
+	ctx := context.Background()
+	server, err := NewTestServer(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, val := range []string{"x", "y"} {
+		if _, err := server.AddLabel(ctx, comatproto.LabelDefs_Label{Uri: "did:plc:a", Val: val}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := server.CreateAppeal(ctx, &Appeal{ReportedBy: "did:plc:b", SubjectUri: "did:plc:b"}); !errors.Is(err, ErrNothingToAppeal) {
+		t.Errorf("expected ErrNothingToAppeal, got %v", err)
+	}
+
+	appeal := &Appeal{ReportedBy: "did:plc:a", SubjectUri: "did:plc:a", Reason: "please"}
+	if err := server.CreateAppeal(ctx, appeal); err != nil {
+		t.Fatal(err)
+	}
+	if len(appeal.Labels) != 2 {
+		t.Fatalf("expected the appeal to be linked to 2 labels, got %+v", appeal.Labels)
+	}
+
+	results, err := server.GrantAppeal(ctx, appeal.ID, "mod", "ok")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, r := range results {
+		if !r.Changed {
+			t.Errorf("unexpected result: %+v", r)
+		}
+	}
+	entries, err := server.SubjectLabels(ctx, "did:plc:a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 0 {
+		t.Errorf("expected no labels after granting the appeal, got %+v", entries)
+	}
+	got, err := server.GetAppeal(ctx, appeal.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Status != AppealGranted {
+		t.Errorf("unexpected status %q", got.Status)
+	}
+	for _, l := range got.Labels {
+		if l.NegationSeq == 0 {
+			t.Errorf("negation of %q is not linked to the appeal", l.Val)
+		}
+	}
+
+	if _, err := server.GrantAppeal(ctx, appeal.ID, "mod", "again"); !errors.Is(err, ErrAppealNotOpen) {
+		t.Errorf("expected ErrAppealNotOpen, got %v", err)
+	}
+}
+
+func TestGrantAppealRejectedLabels(t *testing.T) {
+	ctx := context.Background()
+	server, err := NewTestServer(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, val := range []string{"x", "y"} {
+		if _, err := server.AddLabel(ctx, comatproto.LabelDefs_Label{Uri: "did:plc:a", Val: val}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	appeal := &Appeal{ReportedBy: "did:plc:a", SubjectUri: "did:plc:a"}
+	if err := server.CreateAppeal(ctx, appeal); err != nil {
+		t.Fatal(err)
+	}
+
+	// "x" was removed from the config after the appeal was made.
+	server.SetAllowedLabels([]string{"y"})
+	if _, err := server.GrantAppeal(ctx, appeal.ID, "mod", ""); !errors.Is(err, ErrAppealedLabelRejected) {
+		t.Errorf("expected ErrAppealedLabelRejected, got %v", err)
+	}
+	got, err := server.GetAppeal(ctx, appeal.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Status != AppealOpen {
+		t.Errorf("expected the appeal to stay open, got %q", got.Status)
+	}
+	entries, err := server.SubjectLabels(ctx, "did:plc:a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 2 {
+		t.Errorf("expected both labels to stay, got %+v", entries)
+	}
+}
+
+func TestApprovals(t *testing.T) {
+	ctx := context.Background()
+	server, err := NewTestServer(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server.SetApprovalPolicy(config.Approval{Labels: []string{"!hide"}, Expiry: time.Hour})
+
+	label := comatproto.LabelDefs_Label{Uri: "did:plc:a", Val: "!hide"}
+	_, err = server.AddLabel(ctx, label, RequireApproval(), WithActor("alice"))
+	pending := (*PendingApprovalError)(nil)
+	if !errors.As(err, &pending) || pending.ID == 0 {
+		t.Fatalf("expected PendingApprovalError, got %v", err)
+	}
+	if _, err := server.AddLabel(ctx, label, RequireApproval()); !errors.Is(err, ErrApprovalRequired) || errors.As(err, new(*PendingApprovalError)) {
+		t.Errorf("expected approval to be rejected without an actor, got %v", err)
+	}
+	if changed, err := server.AddLabel(ctx, comatproto.LabelDefs_Label{Uri: "did:plc:a", Val: "x"}, RequireApproval(), WithActor("alice")); err != nil || !changed {
+		t.Errorf("expected label without approval policy to be written, got %v, %v", changed, err)
+	}
+
+	labels, err := server.SubjectLabels(ctx, "did:plc:a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(labels) != 1 {
+		t.Fatalf("expected only one label to be written before approval, got %+v", labels)
+	}
+
+	if _, err := server.ApproveRequest(ctx, pending.ID, "alice", ""); !errors.Is(err, ErrSelfApproval) {
+		t.Errorf("expected ErrSelfApproval, got %v", err)
+	}
+	result, err := server.ApproveRequest(ctx, pending.ID, "bob", "ok")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Err != nil || !result.Changed {
+		t.Fatalf("unexpected result: %+v", result)
+	}
+	r, err := server.GetApprovalRequest(ctx, pending.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.Status != ApprovalApproved || r.ResolvedBy != "bob" || r.Seq != result.Seq {
+		t.Errorf("unexpected approval request state: %+v", r)
+	}
+	history, err := server.SubjectHistory(ctx, "did:plc:a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if last := history[len(history)-1]; last.Val != "!hide" || last.Actor != "alice" {
+		t.Errorf("unexpected entry: %+v", last)
+	}
+	if _, err := server.ApproveRequest(ctx, pending.ID, "carol", ""); !errors.Is(err, ErrApprovalNotPending) {
+		t.Errorf("expected ErrApprovalNotPending, got %v", err)
+	}
+
+	// Appeals can't be used to negate labels that need approval.
+	appeal := &Appeal{ReportedBy: "did:plc:a", SubjectUri: "did:plc:a"}
+	if err := server.CreateAppeal(ctx, appeal); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := server.GrantAppeal(ctx, appeal.ID, "bob", "", WithActor("bob"), RequireApproval()); !errors.Is(err, ErrApprovalRequired) {
+		t.Errorf("expected ErrApprovalRequired, got %v", err)
+	}
+	if got, err := server.GetAppeal(ctx, appeal.ID); err != nil || got.Status != AppealOpen {
+		t.Errorf("expected the appeal to remain open, got %+v, %v", got, err)
+	}
+	if labels, err := server.SubjectLabels(ctx, "did:plc:a"); err != nil || len(labels) != 2 {
+		t.Errorf("expected labels to remain, got %+v, %v", labels, err)
+	}
+
+	// Stale requests expire.
+	stale, err := server.CreateApprovalRequest(ctx, comatproto.LabelDefs_Label{Uri: "did:plc:b", Val: "!hide"}, "alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := server.db.Model(stale).Update("created_at", time.Now().UTC().Add(-2*time.Hour)).Error; err != nil {
+		t.Fatal(err)
+	}
+	requests, err := server.PendingApprovalRequests(ctx, 0, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(requests) != 0 {
+		t.Errorf("expected no pending requests, got %+v", requests)
+	}
+	if _, err := server.ApproveRequest(ctx, stale.ID, "bob", ""); !errors.Is(err, ErrApprovalNotPending) {
+		t.Errorf("expected ErrApprovalNotPending for an expired request, got %v", err)
+	}
+}
+
+func TestDelayedPublication(t *testing.T) {
+	ctx := context.Background()
+	server, err := NewTestServer(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server.SetPublicationDelay(map[string]time.Duration{"spam": time.Hour})
+
+	stage := func(uri string) int64 {
+		t.Helper()
+		results, err := server.AddLabels(ctx, []comatproto.LabelDefs_Label{{Uri: uri, Val: "spam"}}, WithActor("alice"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !results[0].Changed || results[0].DelayedID == 0 || results[0].Seq != 0 {
+			t.Fatalf("expected the label to be staged, got %+v", results[0])
+		}
+		return results[0].DelayedID
+	}
+
+	first := stage("did:plc:a")
+	if changed, err := server.AddLabel(ctx, comatproto.LabelDefs_Label{Uri: "did:plc:a", Val: "spam"}, DryRun()); err != nil || !changed {
+		t.Errorf("expected dry run to report a change, got %v, %v", changed, err)
+	}
+	if changed, err := server.AddLabel(ctx, comatproto.LabelDefs_Label{Uri: "did:plc:a", Val: "other"}); err != nil || !changed {
+		t.Errorf("expected label without delay to be written, got %v, %v", changed, err)
+	}
+	labels, err := server.SubjectLabels(ctx, "did:plc:a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(labels) != 1 || labels[0].Val != "other" {
+		t.Errorf("expected only the label without delay to be written, got %+v", labels)
+	}
+
+	// Cancelled explicitly.
+	second := stage("did:plc:b")
+	if err := server.CancelDelayedLabel(ctx, second, "bob"); err != nil {
+		t.Fatal(err)
+	}
+	if err := server.CancelDelayedLabel(ctx, second, "bob"); !errors.Is(err, ErrDelayedNotPending) {
+		t.Errorf("expected ErrDelayedNotPending, got %v", err)
+	}
+	// Cancelled by a negation.
+	third := stage("did:plc:c")
+	if _, err := server.AddLabel(ctx, comatproto.LabelDefs_Label{Uri: "did:plc:c", Val: "spam", Neg: ptr(true)}, WithActor("bob")); err != nil {
+		t.Fatal(err)
+	}
+	if d, err := server.GetDelayedLabel(ctx, third); err != nil || d.Status != DelayedCancelled || d.ResolvedBy != "bob" {
+		t.Errorf("expected the label to be cancelled by negation, got %+v, %v", d, err)
+	}
+
+	pending, err := server.PendingDelayedLabels(ctx, 0, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pending) != 1 || pending[0].ID != first {
+		t.Errorf("unexpected pending labels: %+v", pending)
+	}
+
+	if n, err := server.PublishDelayedLabels(ctx); err != nil || n != 0 {
+		t.Errorf("expected nothing to be published yet, got %d, %v", n, err)
+	}
+	err = server.db.Model(&DelayedLabel{}).Where("id in ?", []int64{first, second, third}).
+		Update("publish_at", time.Now().UTC().Add(-time.Minute)).Error
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n, err := server.PublishDelayedLabels(ctx); err != nil || n != 1 {
+		t.Errorf("expected one label to be published, got %d, %v", n, err)
+	}
+	if n, err := server.PublishDelayedLabels(ctx); err != nil || n != 0 {
+		t.Errorf("expected nothing to be published again, got %d, %v", n, err)
+	}
+
+	d, err := server.GetDelayedLabel(ctx, first)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d.Status != DelayedPublished || d.Seq == 0 {
+		t.Errorf("unexpected delayed label state: %+v", d)
+	}
+	history, err := server.SubjectHistory(ctx, "did:plc:a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if last := history[len(history)-1]; last.Val != "spam" || last.Seq != d.Seq || last.Actor != "alice" {
+		t.Errorf("unexpected entry: %+v", last)
+	}
+	for _, uri := range []string{"did:plc:b", "did:plc:c"} {
+		labels, err := server.SubjectLabels(ctx, uri)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(labels) != 0 {
+			t.Errorf("expected cancelled label on %s not to be published, got %+v", uri, labels)
+		}
+	}
+}
+
+func TestScheduledLabels(t *testing.T) {
+	ctx := context.Background()
+	server, err := NewTestServer(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Now()
+	if _, err := server.ScheduleLabel(ctx, comatproto.LabelDefs_Label{Uri: "did:plc:a", Val: "x"}, now.Add(-time.Minute), "alice"); err == nil {
+		t.Errorf("expected scheduling in the past to fail")
+	}
+	if _, err := server.ScheduleLabel(ctx, comatproto.LabelDefs_Label{Uri: "did:plc:a", Val: "x", Exp: ptr(now.Add(time.Minute).UTC().Format(time.RFC3339))}, now.Add(time.Hour), "alice"); err == nil {
+		t.Errorf("expected expiration before the scheduled time to fail")
+	}
+
+	apply, err := server.ScheduleLabel(ctx, comatproto.LabelDefs_Label{Uri: "did:plc:a", Val: "x"}, now.Add(time.Hour), "alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	negate, err := server.ScheduleLabel(ctx, comatproto.LabelDefs_Label{Uri: "did:plc:a", Val: "x", Neg: ptr(true)}, now.Add(2*time.Hour), "alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cancelled, err := server.ScheduleLabel(ctx, comatproto.LabelDefs_Label{Uri: "did:plc:b", Val: "x"}, now.Add(time.Hour), "alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := server.CancelScheduledLabel(ctx, cancelled.ID, "bob"); err != nil {
+		t.Fatal(err)
+	}
+	if err := server.CancelScheduledLabel(ctx, cancelled.ID, "bob"); !errors.Is(err, ErrScheduledNotPending) {
+		t.Errorf("expected ErrScheduledNotPending, got %v", err)
+	}
+
+	pending, err := server.PendingScheduledLabels(ctx, 0, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pending) != 2 {
+		t.Errorf("expected two pending labels, got %+v", pending)
+	}
+	if n, err := server.ApplyScheduledLabels(ctx); err != nil || n != 0 {
+		t.Errorf("expected nothing to be written yet, got %d, %v", n, err)
+	}
+
+	makeDue := func(ids ...int64) {
+		t.Helper()
+		err := server.db.Model(&ScheduledLabel{}).Where("id in ?", ids).Update("run_at", time.Now().UTC().Add(-time.Minute)).Error
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	labelsOn := func(uri string) []Entry {
+		t.Helper()
+		labels, err := server.SubjectLabels(ctx, uri)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return labels
+	}
+
+	makeDue(apply.ID, cancelled.ID)
+	if n, err := server.ApplyScheduledLabels(ctx); err != nil || n != 1 {
+		t.Errorf("expected one label to be written, got %d, %v", n, err)
+	}
+	if n, err := server.ApplyScheduledLabels(ctx); err != nil || n != 0 {
+		t.Errorf("expected nothing to be written again, got %d, %v", n, err)
+	}
+	if labels := labelsOn("did:plc:a"); len(labels) != 1 || labels[0].Val != "x" {
+		t.Errorf("expected the label to be applied, got %+v", labels)
+	}
+	if labels := labelsOn("did:plc:b"); len(labels) != 0 {
+		t.Errorf("expected cancelled label not to be applied, got %+v", labels)
+	}
+	l, err := server.GetScheduledLabel(ctx, apply.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if l.Status != ScheduledDone || l.Seq == 0 {
+		t.Errorf("unexpected scheduled label state: %+v", l)
+	}
+
+	makeDue(negate.ID)
+	if n, err := server.ApplyScheduledLabels(ctx); err != nil || n != 1 {
+		t.Errorf("expected one label to be written, got %d, %v", n, err)
+	}
+	if labels := labelsOn("did:plc:a"); len(labels) != 0 {
+		t.Errorf("expected the label to be negated, got %+v", labels)
+	}
+	history, err := server.SubjectHistory(ctx, "did:plc:a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(history) != 2 || history[1].Actor != "alice" {
+		t.Errorf("expected each scheduled label to be written exactly once, got %+v", history)
+	}
+
+	// Approval policy is checked again when the label is due.
+	sensitive, err := server.ScheduleLabel(ctx, comatproto.LabelDefs_Label{Uri: "did:plc:c", Val: "y"}, now.Add(time.Hour), "alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server.SetApprovalPolicy(config.Approval{Labels: []string{"y"}})
+	makeDue(sensitive.ID)
+	if n, err := server.ApplyScheduledLabels(ctx); err != nil || n != 1 {
+		t.Errorf("expected one label to be processed, got %d, %v", n, err)
+	}
+	if labels := labelsOn("did:plc:c"); len(labels) != 0 {
+		t.Errorf("expected the label not to be applied without approval, got %+v", labels)
+	}
+	if l, err := server.GetScheduledLabel(ctx, sensitive.ID); err != nil || l.Status != ScheduledFailed {
+		t.Errorf("expected the scheduled label to fail, got %+v, %v", l, err)
+	}
+}
+
+type testSink struct {
+	seqs []int64
+	fail bool
+	// onPublish, if set, is called once before the first Publish call returns.
+	onPublish func()
+}
+
+func (s *testSink) Name() string { return "test" }
+
+func (s *testSink) Publish(ctx context.Context, entries []Entry) error {
+	if s.fail {
+		return errors.New("failed")
+	}
+	if f := s.onPublish; f != nil {
+		s.onPublish = nil
+		f()
+	}
+	for _, e := range entries {
+		s.seqs = append(s.seqs, e.Seq)
+	}
+	return nil
+}
+
+func TestSinkBackfill(t *testing.T) {
+	ctx := context.Background()
+	server, err := NewTestServer(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	write := func(uri string) {
+		t.Helper()
+		if _, err := server.AddLabel(ctx, comatproto.LabelDefs_Label{Uri: uri, Val: "x"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	write("did:plc:a")
+	sink := &testSink{}
+	if err := server.AddSink(ctx, sink); err != nil {
+		t.Fatal(err)
+	}
+	write("did:plc:b")
+	sink.fail = true
+	write("did:plc:c")
+	sink.fail = false
+	write("did:plc:d")
+	if diff := cmp.Diff([]int64{2, 4}, sink.seqs); diff != "" {
+		t.Errorf("new sink should receive only new entries (-want +got):\n%s", diff)
+	}
+
+	// Simulate a restart.
+	server.sinks = nil
+	sink = &testSink{}
+	if err := server.AddSink(ctx, sink); err != nil {
+		t.Fatal(err)
+	}
+	write("did:plc:e")
+	if diff := cmp.Diff([]int64{3, 4, 5}, sink.seqs); diff != "" {
+		t.Errorf("sink should receive entries since the failure (-want +got):\n%s", diff)
+	}
+
+	server.sinks = nil
+	sink = &testSink{}
+	if err := server.AddSink(ctx, sink); err != nil {
+		t.Fatal(err)
+	}
+	if len(sink.seqs) != 0 {
+		t.Errorf("expected nothing to be sent again, got %v", sink.seqs)
+	}
+}
+
+func sinkCursor(t *testing.T, server *Server) int64 {
+	t.Helper()
+	cursor := &SinkCursor{}
+	if err := server.db.Where("name = ?", "test").Take(cursor).Error; err != nil {
+		t.Fatal(err)
+	}
+	return cursor.Seq
+}
+
+func TestSinkOutOfOrder(t *testing.T) {
+	ctx := context.Background()
+	server, err := NewTestServer(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sink := &testSink{}
+	if err := server.AddSink(ctx, sink); err != nil {
+		t.Fatal(err)
+	}
+
+	// Write entries without publishing them, to publish them out of order below,
+	// as concurrent transactions can.
+	entries := []Entry{}
+	for _, uri := range []string{"did:plc:a", "did:plc:b", "did:plc:c"} {
+		e := Entry{Uri: uri, Val: "x", Src: server.did, Cts: time.Now().Format(time.RFC3339)}
+		if err := server.db.Create(&e).Error; err != nil {
+			t.Fatal(err)
+		}
+		entries = append(entries, e)
+	}
+
+	server.publish(ctx, entries[2:3])
+	if got := sinkCursor(t, server); got != 0 {
+		t.Errorf("cursor was advanced over undelivered entries to %d", got)
+	}
+	server.publish(ctx, entries[0:1])
+	if got := sinkCursor(t, server); got != entries[0].Seq {
+		t.Errorf("expected the cursor to be at %d, got %d", entries[0].Seq, got)
+	}
+	// Entries that were already sent are skipped.
+	server.publish(ctx, entries)
+	if diff := cmp.Diff([]int64{3, 1, 2}, sink.seqs); diff != "" {
+		t.Errorf("unexpected entries sent (-want +got):\n%s", diff)
+	}
+	if got := sinkCursor(t, server); got != entries[2].Seq {
+		t.Errorf("expected the cursor to be at %d, got %d", entries[2].Seq, got)
+	}
+}
+
+func TestSinkRestartWithUndeliveredEntries(t *testing.T) {
+	ctx := context.Background()
+	server, err := NewTestServer(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := server.AddSink(ctx, &testSink{}); err != nil {
+		t.Fatal(err)
+	}
+	entries := []Entry{}
+	for _, uri := range []string{"did:plc:a", "did:plc:b", "did:plc:c"} {
+		e := Entry{Uri: uri, Val: "x", Src: server.did, Cts: time.Now().Format(time.RFC3339)}
+		if err := server.db.Create(&e).Error; err != nil {
+			t.Fatal(err)
+		}
+		entries = append(entries, e)
+	}
+	// The process crashes after a later transaction has published its entries,
+	// but before an earlier one did.
+	server.publish(ctx, entries[1:])
+
+	server.sinks = nil
+	sink := &testSink{}
+	if err := server.AddSink(ctx, sink); err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff([]int64{1, 2, 3}, sink.seqs); diff != "" {
+		t.Errorf("sink should receive all entries after the first undelivered one (-want +got):\n%s", diff)
+	}
+}
+
+func TestSinkWritesDuringBackfill(t *testing.T) {
+	ctx := context.Background()
+	server, err := NewTestServer(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := server.AddSink(ctx, &testSink{}); err != nil {
+		t.Fatal(err)
+	}
+	server.sinks = nil
+	for _, uri := range []string{"did:plc:a", "did:plc:b", "did:plc:c"} {
+		if _, err := server.AddLabel(ctx, comatproto.LabelDefs_Label{Uri: uri, Val: "x"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// A label written while the sink is catching up must still reach it.
+	sink := &testSink{}
+	sink.onPublish = func() {
+		if _, err := server.AddLabel(ctx, comatproto.LabelDefs_Label{Uri: "did:plc:d", Val: "x"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := server.AddSink(ctx, sink); err != nil {
+		t.Fatal(err)
+	}
+	got := slices.Clone(sink.seqs)
+	slices.Sort(got)
+	if diff := cmp.Diff([]int64{1, 2, 3, 4}, got); diff != "" {
+		t.Errorf("unexpected entries sent (-want +got):\n%s", diff)
+	}
+	if got := sinkCursor(t, server); got != 4 {
+		t.Errorf("expected the cursor to be at 4, got %d", got)
+	}
+}
+
+func TestIdempotencyKeyReservation(t *testing.T) {
+	ctx := context.Background()
+	server, err := NewTestServer(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	window := now.Add(-24 * time.Hour)
+	stale := now.Add(-5 * time.Minute)
+
+	if r, err := server.ReserveIdempotencyKey(ctx, "a", "fp", window, stale); err != nil || r != nil {
+		t.Fatalf("expected the key to be reserved, got %+v, %v", r, err)
+	}
+	if r, err := server.ReserveIdempotencyKey(ctx, "a", "fp", window, stale); err != nil || r == nil || r.Status != 0 {
+		t.Fatalf("expected an in-progress record, got %+v, %v", r, err)
+	}
+
+	// The request that made the reservation never completed.
+	err = server.db.Model(&IdempotencyRecord{}).Where("request_key = ?", "a").Update("created_at", now.Add(-10*time.Minute).UTC()).Error
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r, err := server.ReserveIdempotencyKey(ctx, "a", "fp", window, stale); err != nil || r != nil {
+		t.Fatalf("expected a stale reservation to be replaced, got %+v, %v", r, err)
+	}
+
+	// Completed records are kept for the whole window.
+	if err := server.CompleteIdempotencyKey(ctx, "a", 200, nil, []byte("ok")); err != nil {
+		t.Fatal(err)
+	}
+	err = server.db.Model(&IdempotencyRecord{}).Where("request_key = ?", "a").Update("created_at", now.Add(-10*time.Minute).UTC()).Error
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r, err := server.ReserveIdempotencyKey(ctx, "a", "fp", window, stale); err != nil || r == nil || r.Status != 200 {
+		t.Fatalf("expected the completed record, got %+v, %v", r, err)
+	}
+}
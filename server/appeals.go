@@ -0,0 +1,234 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"gorm.io/gorm"
+
+	comatproto "github.com/bluesky-social/indigo/api/atproto"
+
+	"bsky.watch/labeler/config"
+)
+
+// Appeal statuses.
+const (
+	AppealOpen    = "open"
+	AppealGranted = "granted"
+	AppealDenied  = "denied"
+)
+
+// Appeal is a request from a user to remove labels from a subject.
+type Appeal struct {
+	ID        int64     `gorm:"primaryKey"`
+	CreatedAt time.Time `gorm:"not null;index"`
+
+	ReportedBy string `gorm:"not null;index"`
+	SubjectUri string `gorm:"not null;index"`
+	SubjectCid string `gorm:"not null;default:''"`
+	Reason     string `gorm:"not null;default:''"`
+
+	Status     string `gorm:"not null;default:'open';index"`
+	ResolvedBy string `gorm:"not null;default:''"`
+	ResolvedAt *time.Time
+	Comment    string `gorm:"not null;default:''"`
+	// Labels are the labels that were on the subject when the appeal was filed.
+	Labels []AppealLabel `gorm:"foreignKey:AppealID"`
+}
+
+// AppealLabel links an appeal to a label entry it is about.
+type AppealLabel struct {
+	AppealID int64  `gorm:"primaryKey"`
+	Seq      int64  `gorm:"primaryKey"`
+	Val      string `gorm:"not null"`
+	// NegationSeq is the sequence number of the negation written when
+	// the appeal was granted, if any.
+	NegationSeq int64 `gorm:"not null;default:0"`
+}
+
+var (
+	// ErrNothingToAppeal is returned by CreateAppeal if the subject has no labels.
+	ErrNothingToAppeal = errors.New("subject has no labels to appeal")
+	// ErrAppealNotOpen is returned when trying to resolve an appeal that either
+	// doesn't exist or is already resolved.
+	ErrAppealNotOpen = errors.New("appeal doesn't exist or is already resolved")
+	// ErrAppealedLabelRejected is returned by GrantAppeal if some of the appealed
+	// labels can't be negated anymore (e.g., the value is no longer allowed).
+	ErrAppealedLabelRejected = errors.New("appealed label can't be negated")
+)
+
+// CreateAppeal stores a new appeal, linked to the current labels of its subject.
+// ID, CreatedAt and Labels fields are populated by this method. Limits are
+// checked the same way as in CreateReport, counting both reports and appeals.
+func (s *Server) CreateAppeal(ctx context.Context, appeal *Appeal, limits ...config.RateLimit) error {
+	entries, err := s.SubjectLabels(ctx, appeal.SubjectUri)
+	if err != nil {
+		return err
+	}
+	now := time.Now().UTC()
+	appeal.Labels = nil
+	for _, e := range entries {
+		if e.Exp != "" {
+			if exp, err := time.Parse(time.RFC3339, e.Exp); err == nil && exp.Before(now) {
+				continue
+			}
+		}
+		appeal.Labels = append(appeal.Labels, AppealLabel{Seq: e.Seq, Val: e.Val})
+	}
+	if len(appeal.Labels) == 0 {
+		return ErrNothingToAppeal
+	}
+
+	appeal.CreatedAt = now
+	appeal.Status = AppealOpen
+	return s.createWithinLimits(ctx, appeal.ReportedBy, limits, func(tx *gorm.DB) error {
+		appeal.ID = 0
+		if err := tx.Create(appeal).Error; err != nil {
+			return fmt.Errorf("storing appeal: %w", err)
+		}
+		return nil
+	})
+}
+
+// GetAppeal returns the appeal with the given ID.
+func (s *Server) GetAppeal(ctx context.Context, id int64) (*Appeal, error) {
+	a := &Appeal{}
+	err := s.db.WithContext(ctx).Preload("Labels").Where("id = ?", id).Take(a).Error
+	if err != nil {
+		return nil, fmt.Errorf("fetching appeal %d: %w", id, err)
+	}
+	return a, nil
+}
+
+// OpenAppeals returns open appeals with ID greater than `after`, oldest first.
+func (s *Server) OpenAppeals(ctx context.Context, after int64, limit int) ([]Appeal, error) {
+	var appeals []Appeal
+	err := s.db.WithContext(ctx).Preload("Labels").
+		Where("status = ? and id > ?", AppealOpen, after).
+		Order("id asc").
+		Limit(limit).
+		Find(&appeals).Error
+	if err != nil {
+		return nil, fmt.Errorf("querying open appeals: %w", err)
+	}
+	return appeals, nil
+}
+
+func closeAppeal(tx *gorm.DB, id int64, status string, resolvedBy string, comment string) error {
+	r := tx.Model(&Appeal{}).
+		Where("id = ? and status = ?", id, AppealOpen).
+		Updates(map[string]any{
+			"status":      status,
+			"resolved_by": resolvedBy,
+			"resolved_at": time.Now().UTC(),
+			"comment":     comment,
+		})
+	if r.Error != nil {
+		return fmt.Errorf("updating appeal: %w", r.Error)
+	}
+	if r.RowsAffected != 1 {
+		return ErrAppealNotOpen
+	}
+	return nil
+}
+
+// DenyAppeal closes the appeal without changing any labels.
+func (s *Server) DenyAppeal(ctx context.Context, id int64, resolvedBy string, comment string) error {
+	return s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		return closeAppeal(tx, id, AppealDenied, resolvedBy, comment)
+	})
+}
+
+// AppealedLabels returns negations of the labels an appeal is about.
+func (s *Server) AppealedLabels(ctx context.Context, appeal *Appeal) ([]comatproto.LabelDefs_Label, error) {
+	seqs := []int64{}
+	for _, l := range appeal.Labels {
+		seqs = append(seqs, l.Seq)
+	}
+	var entries []Entry
+	err := s.db.WithContext(ctx).Model(&Entry{}).Where("seq in ?", seqs).Order("seq asc").Find(&entries).Error
+	if err != nil {
+		return nil, fmt.Errorf("fetching appealed labels: %w", err)
+	}
+	r := []comatproto.LabelDefs_Label{}
+	for _, e := range entries {
+		r = append(r, comatproto.LabelDefs_Label{Src: e.Src, Uri: e.Uri, Cid: optional(e.Cid), Val: e.Val, Neg: ptr(true)})
+	}
+	return r, nil
+}
+
+// GrantAppeal negates all the labels the appeal is about and closes it, in
+// a single transaction. Labels that were already negated are left as is.
+// Returned results correspond to the labels returned by AppealedLabels.
+// If any of the labels can't be negated, nothing is written and the appeal
+// stays open.
+//
+// With RequireApproval option, appeals against labels that need approval are
+// rejected with ErrApprovalRequired: such labels have to be negated separately.
+func (s *Server) GrantAppeal(ctx context.Context, id int64, resolvedBy string, comment string, opts ...WriteOption) ([]LabelResult, error) {
+	appeal, err := s.GetAppeal(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if appeal.Status != AppealOpen {
+		return nil, ErrAppealNotOpen
+	}
+	labels, err := s.AppealedLabels(ctx, appeal)
+	if err != nil {
+		return nil, err
+	}
+	if applyWriteOptions(opts).requireApproval {
+		for _, l := range labels {
+			if s.RequiresApproval(l.Val) {
+				return nil, fmt.Errorf("%w: %q can't be negated by granting an appeal, negate it separately and grant the appeal after it's approved", ErrApprovalRequired, l.Val)
+			}
+		}
+	}
+	for _, l := range labels {
+		if err := s.ValidateLabel(l); err != nil {
+			return nil, fmt.Errorf("%w: %q: %s", ErrAppealedLabelRejected, l.Val, err)
+		}
+	}
+	if len(labels) == 0 {
+		return nil, s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+			return closeAppeal(tx, id, AppealGranted, resolvedBy, comment)
+		})
+	}
+
+	opts = append(opts, func(o *writeOptions) {
+		o.txHook = func(tx *gorm.DB, entries []*Entry, updated []bool) error {
+			// Labels rejected after the check above (e.g., because of a concurrent
+			// config reload) don't get here, roll back instead of a partial grant.
+			if len(entries) != len(labels) {
+				return fmt.Errorf("%w: some of the labels were rejected", ErrAppealedLabelRejected)
+			}
+			if err := closeAppeal(tx, id, AppealGranted, resolvedBy, comment); err != nil {
+				return err
+			}
+			for i, e := range entries {
+				if !updated[i] {
+					continue
+				}
+				err := tx.Model(&AppealLabel{}).
+					Where("appeal_id = ? and val = ?", id, e.Val).
+					Update("negation_seq", e.Seq).Error
+				if err != nil {
+					return fmt.Errorf("updating appeal labels: %w", err)
+				}
+			}
+			return nil
+		}
+	})
+	results, err := s.AddLabels(ctx, labels, opts...)
+	if err != nil {
+		return nil, err
+	}
+	for i, r := range results {
+		if r.Err != nil {
+			return nil, fmt.Errorf("%w: %q: %s", ErrAppealedLabelRejected, labels[i].Val, r.Err)
+		}
+	}
+	return results, nil
+}
@@ -0,0 +1,270 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"gorm.io/gorm"
+
+	comatproto "github.com/bluesky-social/indigo/api/atproto"
+
+	"bsky.watch/labeler/config"
+)
+
+// DefaultApprovalExpiry is used if config.Approval.Expiry is not set.
+const DefaultApprovalExpiry = 24 * time.Hour
+
+// Approval request statuses.
+const (
+	ApprovalPending  = "pending"
+	ApprovalApproved = "approved"
+	ApprovalRejected = "rejected"
+	ApprovalExpired  = "expired"
+)
+
+// ApprovalRequest is a label write waiting for approval by a second moderator.
+type ApprovalRequest struct {
+	ID          int64     `gorm:"primaryKey"`
+	CreatedAt   time.Time `gorm:"not null;index"`
+	RequestedBy string    `gorm:"not null"`
+
+	Uri string `gorm:"not null"`
+	Cid string `gorm:"not null;default:''"`
+	Val string `gorm:"not null"`
+	Neg bool   `gorm:"not null;default:false"`
+	Exp string `gorm:"not null;default:''"`
+
+	Status     string `gorm:"not null;default:'pending';index"`
+	ResolvedBy string `gorm:"not null;default:''"`
+	ResolvedAt *time.Time
+	Comment    string `gorm:"not null;default:''"`
+	// Seq is the sequence number of the written label, if the request was
+	// approved and the label had any effect.
+	Seq int64 `gorm:"not null;default:0"`
+}
+
+// Label returns the label that will be written once the request is approved.
+func (r *ApprovalRequest) Label() comatproto.LabelDefs_Label {
+	l := comatproto.LabelDefs_Label{Uri: r.Uri, Cid: optional(r.Cid), Val: r.Val, Exp: optional(r.Exp)}
+	if r.Neg {
+		l.Neg = ptr(true)
+	}
+	return l
+}
+
+var (
+	// ErrApprovalRequired is returned for labels that need approval, but
+	// can't be deferred until it's given.
+	ErrApprovalRequired = errors.New("label requires approval by another moderator")
+	// ErrApprovalNotPending is returned when trying to resolve an approval
+	// request that doesn't exist, or is already approved, rejected or expired.
+	ErrApprovalNotPending = errors.New("approval request doesn't exist or is not pending anymore")
+	// ErrSelfApproval is returned when a moderator tries to approve their own request.
+	ErrSelfApproval = errors.New("approval request can't be approved by the same moderator who made it")
+)
+
+// PendingApprovalError is returned instead of writing labels that need approval
+// when RequireApproval option is used.
+type PendingApprovalError struct {
+	// ID of the created approval request. 0 in dry-run mode.
+	ID  int64
+	Val string
+}
+
+func (e *PendingApprovalError) Error() string {
+	if e.ID == 0 {
+		return fmt.Sprintf("label %q requires approval by another moderator", e.Val)
+	}
+	return fmt.Sprintf("label %q requires approval by another moderator, created approval request %d", e.Val, e.ID)
+}
+
+func (e *PendingApprovalError) Is(target error) bool { return target == ErrApprovalRequired }
+
+// RequireApproval makes AddLabel and AddLabels create approval requests for
+// labels that need approval, instead of writing them. Requests are attributed
+// to the actor set with WithActor.
+func RequireApproval() WriteOption {
+	return func(o *writeOptions) { o.requireApproval = true }
+}
+
+// SetApprovalPolicy sets label values that need approval and how long
+// approval requests remain valid.
+func (s *Server) SetApprovalPolicy(cfg config.Approval) {
+	s.mu.Lock()
+	s.approvalLabels = map[string]bool{}
+	for _, v := range cfg.Labels {
+		s.approvalLabels[v] = true
+	}
+	s.approvalExpiry = cfg.Expiry
+	if s.approvalExpiry <= 0 {
+		s.approvalExpiry = DefaultApprovalExpiry
+	}
+	s.mu.Unlock()
+}
+
+// RequiresApproval returns true if writing the label value through the admin
+// APIs requires approval.
+func (s *Server) RequiresApproval(val string) bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.approvalLabels[val]
+}
+
+// requestApproval is called by AddLabels for labels that need approval.
+// It always returns an error, since the label is not going to be written.
+func (s *Server) requestApproval(ctx context.Context, label comatproto.LabelDefs_Label, o writeOptions) error {
+	switch {
+	case o.txHook != nil:
+		// Can't be deferred, since it's a part of a bigger change.
+		return fmt.Errorf("%w: %q can't be applied together with other changes", ErrApprovalRequired, label.Val)
+	case o.actor == "":
+		return fmt.Errorf("%w: %q needs authentication to be enabled", ErrApprovalRequired, label.Val)
+	case o.dryRun:
+		return &PendingApprovalError{Val: label.Val}
+	}
+	r, err := s.CreateApprovalRequest(ctx, label, o.actor)
+	if err != nil {
+		return err
+	}
+	return &PendingApprovalError{ID: r.ID, Val: label.Val}
+}
+
+// CreateApprovalRequest stores a new approval request for the label.
+func (s *Server) CreateApprovalRequest(ctx context.Context, label comatproto.LabelDefs_Label, requestedBy string) (*ApprovalRequest, error) {
+	if err := s.ValidateLabel(label); err != nil {
+		return nil, err
+	}
+	r := &ApprovalRequest{
+		CreatedAt:   time.Now().UTC(),
+		RequestedBy: requestedBy,
+		Uri:         label.Uri,
+		Val:         label.Val,
+		Status:      ApprovalPending,
+	}
+	if label.Cid != nil {
+		r.Cid = *label.Cid
+	}
+	if label.Exp != nil {
+		r.Exp = *label.Exp
+	}
+	if label.Neg != nil {
+		r.Neg = *label.Neg
+	}
+	if err := s.db.WithContext(ctx).Create(r).Error; err != nil {
+		return nil, fmt.Errorf("storing approval request: %w", err)
+	}
+	return r, nil
+}
+
+// expireApprovalRequests marks pending requests older than the expiry as expired.
+func (s *Server) expireApprovalRequests(ctx context.Context) error {
+	s.mu.RLock()
+	expiry := s.approvalExpiry
+	s.mu.RUnlock()
+	if expiry <= 0 {
+		expiry = DefaultApprovalExpiry
+	}
+	err := s.db.WithContext(ctx).Model(&ApprovalRequest{}).
+		Where("status = ? and created_at < ?", ApprovalPending, time.Now().UTC().Add(-expiry)).
+		Update("status", ApprovalExpired).Error
+	if err != nil {
+		return fmt.Errorf("expiring approval requests: %w", err)
+	}
+	return nil
+}
+
+// GetApprovalRequest returns the approval request with the given ID.
+func (s *Server) GetApprovalRequest(ctx context.Context, id int64) (*ApprovalRequest, error) {
+	if err := s.expireApprovalRequests(ctx); err != nil {
+		return nil, err
+	}
+	r := &ApprovalRequest{}
+	if err := s.db.WithContext(ctx).Where("id = ?", id).Take(r).Error; err != nil {
+		return nil, fmt.Errorf("fetching approval request %d: %w", id, err)
+	}
+	return r, nil
+}
+
+// PendingApprovalRequests returns pending approval requests with ID greater
+// than `after`, oldest first.
+func (s *Server) PendingApprovalRequests(ctx context.Context, after int64, limit int) ([]ApprovalRequest, error) {
+	if err := s.expireApprovalRequests(ctx); err != nil {
+		return nil, err
+	}
+	var r []ApprovalRequest
+	err := s.db.WithContext(ctx).
+		Where("status = ? and id > ?", ApprovalPending, after).
+		Order("id asc").
+		Limit(limit).
+		Find(&r).Error
+	if err != nil {
+		return nil, fmt.Errorf("querying approval requests: %w", err)
+	}
+	return r, nil
+}
+
+func closeApprovalRequest(tx *gorm.DB, id int64, status string, resolvedBy string, comment string, seq int64) error {
+	r := tx.Model(&ApprovalRequest{}).
+		Where("id = ? and status = ?", id, ApprovalPending).
+		Updates(map[string]any{
+			"status":      status,
+			"resolved_by": resolvedBy,
+			"resolved_at": time.Now().UTC(),
+			"comment":     comment,
+			"seq":         seq,
+		})
+	if r.Error != nil {
+		return fmt.Errorf("updating approval request: %w", r.Error)
+	}
+	if r.RowsAffected != 1 {
+		return ErrApprovalNotPending
+	}
+	return nil
+}
+
+// ApproveRequest writes the label from the approval request and marks it as
+// approved, in a single transaction. `approvedBy` must be different from
+// the moderator who created the request.
+func (s *Server) ApproveRequest(ctx context.Context, id int64, approvedBy string, comment string, opts ...WriteOption) (LabelResult, error) {
+	r, err := s.GetApprovalRequest(ctx, id)
+	if err != nil {
+		return LabelResult{}, err
+	}
+	if r.Status != ApprovalPending {
+		return LabelResult{}, ErrApprovalNotPending
+	}
+	if approvedBy == "" || approvedBy == r.RequestedBy {
+		return LabelResult{}, ErrSelfApproval
+	}
+
+	opts = append(opts, WithActor(r.RequestedBy), func(o *writeOptions) {
+		o.requireApproval = false
+		o.atomic = true
+		o.txHook = func(tx *gorm.DB, entries []*Entry, updated []bool) error {
+			seq := int64(0)
+			for i, e := range entries {
+				if updated[i] && e.Val == r.Val && e.Uri == r.Uri {
+					seq = e.Seq
+				}
+			}
+			return closeApprovalRequest(tx, id, ApprovalApproved, approvedBy, comment, seq)
+		}
+	})
+	results, err := s.AddLabels(ctx, []comatproto.LabelDefs_Label{r.Label()}, opts...)
+	if err != nil {
+		return LabelResult{}, err
+	}
+	return results[0], nil
+}
+
+// RejectRequest marks the approval request as rejected without writing anything.
+func (s *Server) RejectRequest(ctx context.Context, id int64, rejectedBy string, comment string) error {
+	if err := s.expireApprovalRequests(ctx); err != nil {
+		return err
+	}
+	return s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		return closeApprovalRequest(tx, id, ApprovalRejected, rejectedBy, comment, 0)
+	})
+}
@@ -5,87 +5,221 @@ import (
 	"database/sql"
 	"errors"
 	"fmt"
+	"time"
 
 	"github.com/rs/zerolog"
 	"gorm.io/gorm"
 )
 
-func (s *Server) writeLabel(ctx context.Context, newLabel Entry) (bool, error) {
+// errDryRun is used to roll back the transaction in dry-run mode.
+var errDryRun = errors.New("dry run")
+
+// txHookError wraps errors returned by writeOptions.txHook, and other errors
+// that retrying the transaction won't fix.
+type txHookError struct {
+	err error
+}
+
+func (e *txHookError) Error() string { return e.err.Error() }
+func (e *txHookError) Unwrap() error { return e.err }
+
+func (s *Server) writeLabel(ctx context.Context, newLabel Entry, opts writeOptions) (bool, error) {
+	updated, errs, err := s.writeLabels(ctx, []*Entry{&newLabel}, opts)
+	if err != nil {
+		return false, err
+	}
+	if errs[0] != nil {
+		return false, errs[0]
+	}
+	return updated[0], nil
+}
+
+// writeLabels writes all the entries in a single transaction, skipping the ones
+// that would have no effect. Seq field of written entries is populated with the
+// assigned sequence number. Applying a label from an exclusive group also negates
+// the other values of the group, see SetExclusiveGroups. If the principal from
+// ctx is not allowed to use any of the values that would be negated, the label
+// is not written and the corresponding element of the second return value is set.
+//
+// In dry-run mode the transaction is rolled back at the end, so the return value
+// still reflects the effects of earlier entries in the batch on the later ones.
+func (s *Server) writeLabels(ctx context.Context, labels []*Entry, opts writeOptions) ([]bool, []error, error) {
 	log := zerolog.Ctx(ctx)
-	updated := false
+
+	// Labels that need an expiration time set according to the policy.
+	labelExp := make([]time.Duration, len(labels))
+	for idx, newLabel := range labels {
+		if newLabel.Neg || newLabel.Exp != "" {
+			continue
+		}
+		policy := s.expirationFor(newLabel.Val)
+		labelExp[idx] = policy.Default
+		if labelExp[idx] <= 0 {
+			labelExp[idx] = policy.Max
+		}
+	}
+
+	// Negations for exclusive groups are added inside the transaction, so they
+	// are different for every attempt.
+	var newLabels []*Entry
+	var pos []int
+	var errs []error
+	var updated []bool
+
 	lastKey := int64(0)
 	var lastErr error
 	for i := 0; i < 5; i++ {
 		err := s.db.Transaction(func(tx *gorm.DB) error {
-			updated = false
 			lastKey = 0
 			err := tx.Model(&Entry{}).Select("seq").Order("seq desc").Limit(1).Pluck("seq", &lastKey).Error
 			if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
 				return fmt.Errorf("failed to query last existing key: %w", err)
 			}
 
-			var entries []Entry
-			err = tx.Model(&Entry{}).
-				Where("src = ? and val = ? and uri = ? and cid = ? and seq <= ?",
-					newLabel.Src, newLabel.Val, newLabel.Uri, newLabel.Cid, lastKey).
-				Order("seq desc").Limit(1).Find(&entries).Error
-			if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
-				return fmt.Errorf("failed to query existing labels: %w", err)
+			newLabels, pos, errs, err = s.withExclusiveNegations(ctx, tx, labels)
+			if err != nil {
+				return err
+			}
+			if opts.atomic {
+				for _, err := range errs {
+					if err != nil {
+						return &txHookError{err: err}
+					}
+				}
 			}
+			updated = make([]bool, len(newLabels))
 
-			noOp := false // default for the case we don't find any matches.
-			if newLabel.Neg {
-				// If the label is a negation - default to not writing it, since we don't
-				// have anything to negate in the first place.
-				noOp = true
+			defaultExp := make([]time.Duration, len(newLabels))
+			for i, p := range pos {
+				if p >= 0 {
+					defaultExp[p] = labelExp[i]
+				}
 			}
-			if len(entries) > 0 {
-				e := entries[0]
-				noOp = true
-				if e.Neg != newLabel.Neg {
-					noOp = false
+
+			written := []int64{}
+			for idx, newLabel := range newLabels {
+				newLabel.Seq = 0
+
+				// Since we're in the same transaction, this will also see
+				// entries written earlier in this batch.
+				var entries []Entry
+				err = tx.Model(&Entry{}).
+					Where("src = ? and val = ? and uri = ? and cid = ?",
+						newLabel.Src, newLabel.Val, newLabel.Uri, newLabel.Cid).
+					Where(tx.Where("seq <= ?", lastKey).Or("seq in ?", written)).
+					Order("seq desc").Limit(1).Find(&entries).Error
+				if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+					return fmt.Errorf("failed to query existing labels: %w", err)
 				}
-				if e.Exp != newLabel.Exp {
-					noOp = false
+
+				if defaultExp[idx] > 0 {
+					newLabel.Exp = ""
+					if len(entries) > 0 && !entries[0].Neg && entries[0].Exp != "" {
+						if exp, err := time.Parse(time.RFC3339, entries[0].Exp); err == nil && exp.After(time.Now()) {
+							// The label is still active, keep its expiration time.
+							continue
+						}
+					}
+					newLabel.Exp = time.Now().Add(defaultExp[idx]).UTC().Format(time.RFC3339)
 				}
-			}
 
-			if noOp {
-				return nil
-			}
-			updated = true
+				noOp := false // default for the case we don't find any matches.
+				if newLabel.Neg {
+					// If the label is a negation - default to not writing it, since we don't
+					// have anything to negate in the first place.
+					noOp = true
+				}
+				if len(entries) > 0 {
+					e := entries[0]
+					noOp = true
+					if e.Neg != newLabel.Neg {
+						noOp = false
+					}
+					if e.Exp != newLabel.Exp {
+						noOp = false
+					}
+				}
 
-			if err := tx.Create(&newLabel).Error; err != nil {
-				return fmt.Errorf("creating new entry: %w", err)
-			}
+				if noOp {
+					continue
+				}
+				updated[idx] = true
 
-			// XXX: it's still possible to end up with redundant/duplicate entries:
-			// concurrent transactions will not see each other's writes in the next
-			// query, but still can be both committed successfully.
-			var newEntries int64
-			err = tx.Model(&Entry{}).
-				Where("src = ? and val = ? and uri = ? and cid = ? and seq > ? and seq < ?",
-					newLabel.Src, newLabel.Val, newLabel.Uri, newLabel.Cid, lastKey, newLabel.Seq).
-				Count(&newEntries).Error
-			if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
-				return fmt.Errorf("failed to query existing labels: %w", err)
+				if err := tx.Create(newLabel).Error; err != nil {
+					return fmt.Errorf("creating new entry: %w", err)
+				}
+				written = append(written, newLabel.Seq)
+
+				// XXX: it's still possible to end up with redundant/duplicate entries:
+				// concurrent transactions will not see each other's writes in the next
+				// query, but still can be both committed successfully.
+				var newEntries int64
+				err = tx.Model(&Entry{}).
+					Where("src = ? and val = ? and uri = ? and cid = ? and seq > ? and seq < ? and seq not in ?",
+						newLabel.Src, newLabel.Val, newLabel.Uri, newLabel.Cid, lastKey, newLabel.Seq, written).
+					Count(&newEntries).Error
+				if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+					return fmt.Errorf("failed to query existing labels: %w", err)
+				}
+				if newEntries > 0 {
+					return fmt.Errorf("new labels for the same subject were written concurrently, rolling back")
+				}
 			}
-			if newEntries > 0 {
-				return fmt.Errorf("new labels for the same subject were written concurrently, rolling back")
+			if opts.txHook != nil {
+				if err := opts.txHook(tx, newLabels, updated); err != nil {
+					return &txHookError{err: err}
+				}
+			}
+			if opts.dryRun {
+				return errDryRun
 			}
 			return nil
 		}, &sql.TxOptions{Isolation: sql.LevelReadCommitted})
+		if errors.Is(err, errDryRun) {
+			for _, e := range newLabels {
+				e.Seq = 0
+			}
+			return originalResults(updated, pos), errs, nil
+		}
+		if hookErr := (*txHookError)(nil); errors.As(err, &hookErr) {
+			// Retrying won't help here.
+			return nil, nil, hookErr.err
+		}
 		lastErr = err
 		if err != nil {
 			log.Info().Err(err).Msgf("Transaction failed: %s", err)
 			continue
 		}
-		if updated {
-			highestKey.WithLabelValues(s.did).Set(float64(lastKey + 1))
+		maxSeq := int64(0)
+		for idx, e := range newLabels {
+			if updated[idx] && e.Seq > maxSeq {
+				maxSeq = e.Seq
+			}
+		}
+		if maxSeq > 0 {
+			highestKey.WithLabelValues(s.did).Set(float64(maxSeq))
+		}
+		written := []Entry{}
+		for idx, e := range newLabels {
+			if updated[idx] {
+				written = append(written, *e)
+			}
 		}
-		return updated, nil
+		s.publish(ctx, written)
+		return originalResults(updated, pos), errs, nil
 	}
-	return false, fmt.Errorf("failed to write the new label: %w", lastErr)
+	return nil, nil, fmt.Errorf("failed to write the new label: %w", lastErr)
+}
+
+// originalResults picks the results for the original entries passed to writeLabels.
+func originalResults(updated []bool, pos []int) []bool {
+	r := make([]bool, len(pos))
+	for i, p := range pos {
+		if p >= 0 {
+			r[i] = updated[p]
+		}
+	}
+	return r
 }
 
 func dedupeAndNegateEntries(entries []Entry) []Entry {
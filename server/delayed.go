@@ -0,0 +1,260 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"golang.org/x/exp/maps"
+	"gorm.io/gorm"
+
+	comatproto "github.com/bluesky-social/indigo/api/atproto"
+)
+
+// Delayed label statuses.
+const (
+	DelayedPending   = "pending"
+	DelayedPublished = "published"
+	DelayedCancelled = "cancelled"
+	// DelayedFailed is set for labels that were rejected at publication time,
+	// e.g., because the label value is not allowed anymore.
+	DelayedFailed = "failed"
+)
+
+// DelayedLabel is a label staged for publication at a later time.
+type DelayedLabel struct {
+	ID        int64     `gorm:"primaryKey"`
+	CreatedAt time.Time `gorm:"not null"`
+	PublishAt time.Time `gorm:"not null;index"`
+	Actor     string    `gorm:"not null;default:''"`
+
+	Uri string `gorm:"not null"`
+	Cid string `gorm:"not null;default:''"`
+	Val string `gorm:"not null"`
+	Src string `gorm:"not null"`
+	Exp string `gorm:"not null;default:''"`
+
+	Status     string `gorm:"not null;default:'pending';index"`
+	ResolvedBy string `gorm:"not null;default:''"`
+	ResolvedAt *time.Time
+	Error      string `gorm:"not null;default:''"`
+	// Seq is the sequence number of the published label, if it had any effect.
+	Seq int64 `gorm:"not null;default:0"`
+}
+
+// Label returns the label that will be written once the delay is over.
+func (d *DelayedLabel) Label() comatproto.LabelDefs_Label {
+	return comatproto.LabelDefs_Label{Uri: d.Uri, Cid: optional(d.Cid), Val: d.Val, Src: d.Src, Exp: optional(d.Exp)}
+}
+
+// ErrDelayedNotPending is returned when trying to cancel a delayed label that
+// doesn't exist or was already published or cancelled.
+var ErrDelayedNotPending = errors.New("delayed label doesn't exist or is not pending anymore")
+
+// SetPublicationDelay sets how long new labels are kept in the pending state
+// before being written. Keys are label values, with "*" matching all values
+// that aren't listed.
+//
+// Delays apply only to applying labels, negations are written immediately and
+// also cancel matching pending labels. Labels written together with other changes
+// (resolving reports and appeals, approving requests) are not delayed either.
+func (s *Server) SetPublicationDelay(delays map[string]time.Duration) {
+	s.mu.Lock()
+	s.delays = maps.Clone(delays)
+	s.mu.Unlock()
+}
+
+func (s *Server) delayFor(val string) time.Duration {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if d, ok := s.delays[val]; ok {
+		return d
+	}
+	return s.delays["*"]
+}
+
+// shouldDelay returns true if the entry needs to be staged instead of written.
+func (s *Server) shouldDelay(e *Entry, o writeOptions) bool {
+	return o.txHook == nil && !e.Neg && s.delayFor(e.Val) > 0
+}
+
+// stageLabels stores delayed entries for publication later. Entries that
+// would have no effect if written now, or would be rejected, are skipped.
+// Returns which of the entries were staged (or would be in dry-run mode),
+// their IDs, and the reasons for rejected ones.
+func (s *Server) stageLabels(ctx context.Context, entries []*Entry, o writeOptions) ([]bool, []int64, []error, error) {
+	// Entries are modified by writeLabels, so check the copies.
+	check := make([]*Entry, len(entries))
+	for i, e := range entries {
+		c := *e
+		check[i] = &c
+	}
+	changed, errs, err := s.writeLabels(ctx, check, writeOptions{dryRun: true})
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	ids := make([]int64, len(entries))
+	if o.dryRun {
+		return changed, ids, errs, nil
+	}
+
+	now := time.Now().UTC()
+	rows := []*DelayedLabel{}
+	for i, e := range entries {
+		if !changed[i] {
+			continue
+		}
+		rows = append(rows, &DelayedLabel{
+			CreatedAt: now,
+			PublishAt: now.Add(s.delayFor(e.Val)),
+			Actor:     o.actor,
+			Uri:       e.Uri,
+			Cid:       e.Cid,
+			Val:       e.Val,
+			Src:       e.Src,
+			Exp:       e.Exp,
+			Status:    DelayedPending,
+		})
+	}
+	if len(rows) == 0 {
+		return changed, ids, errs, nil
+	}
+	if err := s.db.WithContext(ctx).Create(&rows).Error; err != nil {
+		return nil, nil, nil, fmt.Errorf("storing delayed labels: %w", err)
+	}
+	j := 0
+	for i := range entries {
+		if changed[i] {
+			ids[i] = rows[j].ID
+			j++
+		}
+	}
+	return changed, ids, errs, nil
+}
+
+// cancelNegatedLabels returns options that also cancel pending delayed labels
+// that would be negated by the entries, in the same transaction that writes them.
+func cancelNegatedLabels(entries []*Entry, o writeOptions) writeOptions {
+	if o.dryRun || o.keepDelayed {
+		return o
+	}
+	negs := []*Entry{}
+	for _, e := range entries {
+		if e.Neg {
+			negs = append(negs, e)
+		}
+	}
+	if len(negs) == 0 {
+		return o
+	}
+	hook := o.txHook
+	o.txHook = func(tx *gorm.DB, entries []*Entry, updated []bool) error {
+		for _, e := range negs {
+			err := tx.Model(&DelayedLabel{}).
+				Where("status = ? and uri = ? and cid = ? and val = ? and src = ?", DelayedPending, e.Uri, e.Cid, e.Val, e.Src).
+				Updates(map[string]any{
+					"status":      DelayedCancelled,
+					"resolved_by": o.actor,
+					"resolved_at": time.Now().UTC(),
+				}).Error
+			if err != nil {
+				return fmt.Errorf("cancelling delayed labels: %w", err)
+			}
+		}
+		if hook != nil {
+			return hook(tx, entries, updated)
+		}
+		return nil
+	}
+	return o
+}
+
+// GetDelayedLabel returns the delayed label with the given ID.
+func (s *Server) GetDelayedLabel(ctx context.Context, id int64) (*DelayedLabel, error) {
+	r := &DelayedLabel{}
+	if err := s.db.WithContext(ctx).Where("id = ?", id).Take(r).Error; err != nil {
+		return nil, fmt.Errorf("fetching delayed label %d: %w", id, err)
+	}
+	return r, nil
+}
+
+// PendingDelayedLabels returns delayed labels that are not published yet,
+// with ID greater than `after`, oldest first.
+func (s *Server) PendingDelayedLabels(ctx context.Context, after int64, limit int) ([]DelayedLabel, error) {
+	var r []DelayedLabel
+	err := s.db.WithContext(ctx).
+		Where("status = ? and id > ?", DelayedPending, after).
+		Order("id asc").
+		Limit(limit).
+		Find(&r).Error
+	if err != nil {
+		return nil, fmt.Errorf("querying delayed labels: %w", err)
+	}
+	return r, nil
+}
+
+func closeDelayedLabel(tx *gorm.DB, id int64, updates map[string]any) error {
+	updates["resolved_at"] = time.Now().UTC()
+	r := tx.Model(&DelayedLabel{}).Where("id = ? and status = ?", id, DelayedPending).Updates(updates)
+	if r.Error != nil {
+		return fmt.Errorf("updating delayed label: %w", r.Error)
+	}
+	if r.RowsAffected != 1 {
+		return ErrDelayedNotPending
+	}
+	return nil
+}
+
+// CancelDelayedLabel cancels publication of a pending delayed label.
+func (s *Server) CancelDelayedLabel(ctx context.Context, id int64, cancelledBy string) error {
+	return s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		return closeDelayedLabel(tx, id, map[string]any{"status": DelayedCancelled, "resolved_by": cancelledBy})
+	})
+}
+
+// PublishDelayedLabels writes pending delayed labels that are due, and
+// returns how many of them were processed. Each label is marked as published
+// in the same transaction that writes it, so concurrent calls and cancellations
+// can't result in the label being written twice or after being cancelled.
+func (s *Server) PublishDelayedLabels(ctx context.Context) (int, error) {
+	var due []DelayedLabel
+	err := s.db.WithContext(ctx).
+		Where("status = ? and publish_at <= ?", DelayedPending, time.Now().UTC()).
+		Order("publish_at asc, id asc").
+		Limit(schedulerBatchSize).
+		Find(&due).Error
+	if err != nil {
+		return 0, fmt.Errorf("querying delayed labels: %w", err)
+	}
+
+	n := 0
+	for _, d := range due {
+		results, err := s.AddLabels(ctx, []comatproto.LabelDefs_Label{d.Label()}, WithActor(d.Actor), func(o *writeOptions) {
+			o.txHook = func(tx *gorm.DB, entries []*Entry, updated []bool) error {
+				seq := int64(0)
+				for i, e := range entries {
+					if updated[i] && e.Val == d.Val && e.Uri == d.Uri {
+						seq = e.Seq
+					}
+				}
+				return closeDelayedLabel(tx, d.ID, map[string]any{"status": DelayedPublished, "seq": seq})
+			}
+		})
+		switch {
+		case errors.Is(err, ErrDelayedNotPending):
+			continue
+		case err != nil:
+			return n, fmt.Errorf("publishing delayed label %d: %w", d.ID, err)
+		case results[0].Err != nil:
+			err := s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+				return closeDelayedLabel(tx, d.ID, map[string]any{"status": DelayedFailed, "error": results[0].Err.Error()})
+			})
+			if err != nil && !errors.Is(err, ErrDelayedNotPending) {
+				return n, err
+			}
+		}
+		n++
+	}
+	return n, nil
+}
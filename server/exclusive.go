@@ -0,0 +1,132 @@
+package server
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+
+	"gorm.io/gorm"
+
+	"bsky.watch/labeler/auth"
+)
+
+// ErrForbiddenNegation is returned for labels from an exclusive group that would
+// negate an active value the principal is not allowed to use.
+var ErrForbiddenNegation = errors.New("label would negate a value that can't be negated")
+
+// SetExclusiveGroups sets groups of label values that a subject can have at most
+// one of at a time. Applying a label from a group negates the other values of
+// the group in the same transaction.
+func (s *Server) SetExclusiveGroups(groups map[string][]string) {
+	others := map[string][]string{}
+	for _, vals := range groups {
+		for _, v := range vals {
+			for _, other := range vals {
+				if other != v && !slices.Contains(others[v], other) {
+					others[v] = append(others[v], other)
+				}
+			}
+		}
+	}
+	for _, vals := range others {
+		slices.Sort(vals)
+	}
+
+	s.mu.Lock()
+	s.exclusiveGroups = others
+	s.mu.Unlock()
+}
+
+// withExclusiveNegations returns the entries with negations of the other values
+// from the same exclusive groups inserted before each label that belongs to any.
+// Values are negated for every CID they were applied with on the same uri, not
+// just for the CID of the new label. It must be called inside the transaction
+// that writes the result.
+//
+// The second return value contains positions of the original entries in the result.
+// Labels that would negate an active value that the principal from ctx isn't allowed
+// to use are left out (with position -1), and the third return value contains the
+// reason for each of them.
+func (s *Server) withExclusiveNegations(ctx context.Context, tx *gorm.DB, entries []*Entry) ([]*Entry, []int, []error, error) {
+	s.mu.RLock()
+	groups := s.exclusiveGroups
+	s.mu.RUnlock()
+	p := auth.FromContext(ctx)
+
+	r := make([]*Entry, 0, len(entries))
+	pos := make([]int, len(entries))
+	errs := make([]error, len(entries))
+	for i, e := range entries {
+		if others := groups[e.Val]; !e.Neg && len(others) > 0 {
+			type valCid struct{ Val, Cid string }
+			var existing []valCid
+			err := tx.Model(&Entry{}).Distinct("val", "cid").
+				Where("src = ? and uri = ? and val in ?", e.Src, e.Uri, others).
+				Find(&existing).Error
+			if err != nil {
+				return nil, nil, nil, fmt.Errorf("querying labels in exclusive groups: %w", err)
+			}
+			// Labels earlier in the batch are not written yet.
+			inBatch := map[valCid]bool{}
+			for j, prev := range entries[:i] {
+				if pos[j] >= 0 && !prev.Neg && prev.Src == e.Src && prev.Uri == e.Uri && slices.Contains(others, prev.Val) {
+					existing = append(existing, valCid{prev.Val, prev.Cid})
+					inBatch[valCid{prev.Val, prev.Cid}] = true
+				}
+			}
+			for _, other := range others {
+				existing = append(existing, valCid{other, e.Cid})
+			}
+			slices.SortFunc(existing, func(a, b valCid) int {
+				return cmp.Or(strings.Compare(a.Val, b.Val), strings.Compare(a.Cid, b.Cid))
+			})
+			existing = slices.Compact(existing)
+
+			// Negations of values that aren't active are no-ops, so they don't
+			// need a permission check.
+			for _, vc := range existing {
+				if p == nil || p.CanLabel(vc.Val) {
+					continue
+				}
+				active := inBatch[vc]
+				if !active {
+					var last []Entry
+					err := tx.Model(&Entry{}).
+						Where("src = ? and val = ? and uri = ? and cid = ?", e.Src, vc.Val, e.Uri, vc.Cid).
+						Order("seq desc").Limit(1).Find(&last).Error
+					if err != nil {
+						return nil, nil, nil, fmt.Errorf("querying labels in exclusive groups: %w", err)
+					}
+					active = len(last) > 0 && !last[0].Neg
+				}
+				if active {
+					err := auth.CheckLabel(ctx, vc.Val)
+					errs[i] = fmt.Errorf("%w: %s, and applying %q would negate it", ErrForbiddenNegation, err, e.Val)
+					break
+				}
+			}
+			if errs[i] != nil {
+				pos[i] = -1
+				continue
+			}
+
+			for _, vc := range existing {
+				r = append(r, &Entry{
+					Cts:   e.Cts,
+					Uri:   e.Uri,
+					Val:   vc.Val,
+					Src:   e.Src,
+					Cid:   vc.Cid,
+					Neg:   true,
+					Actor: e.Actor,
+				})
+			}
+		}
+		pos[i] = len(r)
+		r = append(r, e)
+	}
+	return r, pos, errs, nil
+}
@@ -0,0 +1,111 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/rs/zerolog"
+	"gorm.io/gorm"
+
+	comatproto "github.com/bluesky-social/indigo/api/atproto"
+	"github.com/bluesky-social/indigo/atproto/syntax"
+)
+
+// ExpiryActor is recorded as the actor of negations written for expired labels.
+const ExpiryActor = "expiry"
+
+// errExpiredLabelChanged is used to roll back a negation if the label was
+// re-applied after it was found to be expired.
+var errExpiredLabelChanged = errors.New("label was changed concurrently")
+
+// SetNegateExpired enables or disables writing negations for expired labels
+// in RunScheduler.
+func (s *Server) SetNegateExpired(enabled bool) {
+	s.mu.Lock()
+	s.negateExpired = enabled
+	s.mu.Unlock()
+}
+
+// expiredLabels returns current labels that have expired, with sequence number
+// greater than `after`. Expiration times are compared as strings first, so
+// labels with a non-UTC `exp` might be found later than they expire.
+func (s *Server) expiredLabels(ctx context.Context, after int64, now time.Time) ([]Entry, error) {
+	q := s.db.WithContext(ctx).Model(&Entry{}).
+		Where("seq > ? and neg = ? and exp != '' and exp <= ?", after, false, now.UTC().Format(time.RFC3339)).
+		Where("not exists (?)", s.db.Model(&Entry{}).Select("1").Table("log as newer").
+			Where("newer.src = log.src and newer.val = log.val and newer.uri = log.uri and newer.cid = log.cid and newer.seq > log.seq"))
+	if allowed := s.AllowedLabels(); len(allowed) > 0 {
+		// Negations of other values would be rejected anyway.
+		q = q.Where("val in ?", allowed)
+	}
+	var entries []Entry
+	if err := q.Order("seq asc").Limit(schedulerBatchSize).Find(&entries).Error; err != nil {
+		return nil, fmt.Errorf("querying expired labels: %w", err)
+	}
+	return entries, nil
+}
+
+// NegateExpiredLabels writes negations for all current labels whose expiration
+// time has passed, so that consumers ignoring `exp` also see them removed.
+// Returns the number of negations written.
+func (s *Server) NegateExpiredLabels(ctx context.Context) (int, error) {
+	log := zerolog.Ctx(ctx)
+	now := time.Now()
+	n := 0
+	after := int64(0)
+	for {
+		entries, err := s.expiredLabels(ctx, after, now)
+		if err != nil {
+			return n, err
+		}
+		for _, e := range entries {
+			after = e.Seq
+			if exp, err := syntax.ParseDatetimeTime(e.Exp); err != nil || exp.After(now) {
+				continue
+			}
+
+			negation := comatproto.LabelDefs_Label{Uri: e.Uri, Cid: optional(e.Cid), Val: e.Val, Src: e.Src, Neg: ptr(true)}
+			results, err := s.AddLabels(ctx, []comatproto.LabelDefs_Label{negation}, WithActor(ExpiryActor), func(o *writeOptions) {
+				o.keepDelayed = true
+				o.txHook = func(tx *gorm.DB, entries []*Entry, updated []bool) error {
+					if !updated[0] {
+						return nil
+					}
+					// Make sure we're negating the same entry, and not the label
+					// that was re-applied in the meantime.
+					var prev int64
+					err := tx.Model(&Entry{}).
+						Where("src = ? and val = ? and uri = ? and cid = ? and seq < ?", e.Src, e.Val, e.Uri, e.Cid, entries[0].Seq).
+						Order("seq desc").Limit(1).Pluck("seq", &prev).Error
+					if err != nil {
+						return fmt.Errorf("querying previous entry: %w", err)
+					}
+					if prev != e.Seq {
+						return errExpiredLabelChanged
+					}
+					return nil
+				}
+			})
+			switch {
+			case errors.Is(err, errExpiredLabelChanged):
+				expiredLabels.WithLabelValues(s.did, "skipped").Inc()
+			case err != nil:
+				expiredLabels.WithLabelValues(s.did, "error").Inc()
+				return n, fmt.Errorf("negating expired label %d: %w", e.Seq, err)
+			case results[0].Err != nil:
+				expiredLabels.WithLabelValues(s.did, "error").Inc()
+				log.Warn().Err(results[0].Err).Int64("seq", e.Seq).Msgf("Failed to negate expired label: %s", results[0].Err)
+			case results[0].Changed:
+				expiredLabels.WithLabelValues(s.did, "negated").Inc()
+				n++
+			default:
+				expiredLabels.WithLabelValues(s.did, "skipped").Inc()
+			}
+		}
+		if len(entries) < schedulerBatchSize {
+			return n, nil
+		}
+	}
+}
@@ -0,0 +1,94 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// IdempotencyRecord stores the response to an admin API request made with
+// an idempotency key, so that it can be replayed if the request is retried.
+type IdempotencyRecord struct {
+	RequestKey  string `gorm:"primaryKey"`
+	Fingerprint string `gorm:"not null"`
+	// Status is the HTTP status code of the response, or 0 if the request
+	// is still being processed.
+	Status    int `gorm:"not null;default:0"`
+	Header    []byte
+	Body      []byte
+	CreatedAt time.Time `gorm:"not null;index"`
+}
+
+func (IdempotencyRecord) TableName() string {
+	return "idempotency_keys"
+}
+
+// ReserveIdempotencyKey atomically claims the key for a new request. If the key
+// was already claimed after notBefore, the existing record is returned instead
+// and nothing is modified. Older records with the same key are replaced, as are
+// reservations made before staleBefore that were never completed (e.g., because
+// the process was restarted while handling the request).
+func (s *Server) ReserveIdempotencyKey(ctx context.Context, key string, fingerprint string, notBefore time.Time, staleBefore time.Time) (*IdempotencyRecord, error) {
+	var existing *IdempotencyRecord
+	err := s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		err := tx.Where("request_key = ?", key).
+			Where(tx.Where("created_at < ?", notBefore.UTC()).Or("status = ? and created_at < ?", 0, staleBefore.UTC())).
+			Delete(&IdempotencyRecord{}).Error
+		if err != nil {
+			return fmt.Errorf("deleting expired record: %w", err)
+		}
+		r := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&IdempotencyRecord{
+			RequestKey:  key,
+			Fingerprint: fingerprint,
+			CreatedAt:   time.Now().UTC(),
+		})
+		if r.Error != nil {
+			return fmt.Errorf("inserting record: %w", r.Error)
+		}
+		if r.RowsAffected > 0 {
+			return nil
+		}
+		existing = &IdempotencyRecord{}
+		if err := tx.Where("request_key = ?", key).Take(existing).Error; err != nil {
+			return fmt.Errorf("fetching existing record: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return existing, nil
+}
+
+// CompleteIdempotencyKey stores the response to the request that has reserved the key.
+func (s *Server) CompleteIdempotencyKey(ctx context.Context, key string, status int, header []byte, body []byte) error {
+	err := s.db.WithContext(ctx).Model(&IdempotencyRecord{}).
+		Where("request_key = ?", key).
+		Updates(map[string]any{"status": status, "header": header, "body": body}).Error
+	if err != nil {
+		return fmt.Errorf("updating idempotency record: %w", err)
+	}
+	return nil
+}
+
+// ReleaseIdempotencyKey removes the reservation made by ReserveIdempotencyKey,
+// allowing the request to be retried. Completed records are not affected.
+func (s *Server) ReleaseIdempotencyKey(ctx context.Context, key string) error {
+	err := s.db.WithContext(ctx).Where("request_key = ? and status = ?", key, 0).Delete(&IdempotencyRecord{}).Error
+	if err != nil {
+		return fmt.Errorf("deleting idempotency record: %w", err)
+	}
+	return nil
+}
+
+// PurgeIdempotencyKeys deletes all records created before the given time.
+func (s *Server) PurgeIdempotencyKeys(ctx context.Context, before time.Time) error {
+	err := s.db.WithContext(ctx).Where("created_at < ?", before.UTC()).Delete(&IdempotencyRecord{}).Error
+	if err != nil {
+		return fmt.Errorf("deleting expired idempotency records: %w", err)
+	}
+	return nil
+}
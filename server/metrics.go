@@ -29,6 +29,20 @@ var (
 		Help:      "Last cursor value sent to a subscriber.",
 	}, []string{"did", "remote"})
 
+	sinkPublished = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "labeler",
+		Subsystem: "server",
+		Name:      "sink_published_entries_total",
+		Help:      "Number of entries passed to sinks.",
+	}, []string{"did", "sink", "status"})
+
+	expiredLabels = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "labeler",
+		Subsystem: "server",
+		Name:      "expired_labels_total",
+		Help:      "Number of expired labels processed by the sweeper, by outcome.",
+	}, []string{"did", "status"})
+
 	highestKey = promauto.NewGaugeVec(prometheus.GaugeOpts{
 		Namespace: "labeler",
 		Subsystem: "server",
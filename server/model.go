@@ -13,6 +13,10 @@ type Entry struct {
 
 	Exp string
 	Neg bool `gorm:"default:false"`
+
+	// Actor identifies who has written the entry, e.g., the name of an admin
+	// API user. It is not a part of the label and is not published.
+	Actor string `gorm:"not null;default:''"`
 }
 
 func (Entry) TableName() string {
@@ -28,6 +32,7 @@ func (e *Entry) FromLabel(seq int64, other comatproto.LabelDefs_Label) *Entry {
 	e.Cid = ""
 	e.Exp = ""
 	e.Neg = false
+	e.Actor = ""
 
 	if other.Cid != nil {
 		e.Cid = *other.Cid
@@ -0,0 +1,281 @@
+package server
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"gorm.io/gorm"
+
+	comatproto "github.com/bluesky-social/indigo/api/atproto"
+
+	"bsky.watch/labeler/config"
+)
+
+// Report statuses.
+const (
+	ReportOpen     = "open"
+	ReportResolved = "resolved"
+)
+
+// Report is a user report submitted via com.atproto.moderation.createReport.
+type Report struct {
+	ID        int64     `gorm:"primaryKey"`
+	CreatedAt time.Time `gorm:"not null;index:idx_reports_reporter,priority:2"`
+
+	ReportedBy string `gorm:"not null;index:idx_reports_reporter,priority:1"`
+	SubjectUri string `gorm:"not null;index"`
+	SubjectCid string `gorm:"not null;default:''"`
+	ReasonType string `gorm:"not null"`
+	Reason     string `gorm:"not null;default:''"`
+
+	Status     string `gorm:"not null;default:'open';index"`
+	AssignedTo string `gorm:"not null;default:''"`
+	ResolvedBy string `gorm:"not null;default:''"`
+	ResolvedAt *time.Time
+	// Comment is the moderator's note left when resolving the report.
+	Comment string `gorm:"not null;default:''"`
+	// LabelSeqs are sequence numbers of the labels written when resolving the report.
+	LabelSeqs []ReportLabel `gorm:"foreignKey:ReportID"`
+}
+
+// ReportLabel links a report to a label entry written as a result of it.
+type ReportLabel struct {
+	ReportID int64 `gorm:"primaryKey"`
+	Seq      int64 `gorm:"primaryKey"`
+}
+
+// ReportGroup is a set of reports about the same subject.
+type ReportGroup struct {
+	SubjectUri string
+	Reports    []Report
+}
+
+// CreateReport stores a new report. ID and CreatedAt fields are populated
+// by this method. If the reporter has exceeded any of the limits, a
+// *RateLimitError is returned and nothing is stored.
+func (s *Server) CreateReport(ctx context.Context, report *Report, limits ...config.RateLimit) error {
+	report.CreatedAt = time.Now().UTC()
+	report.Status = ReportOpen
+	return s.createWithinLimits(ctx, report.ReportedBy, limits, func(tx *gorm.DB) error {
+		report.ID = 0
+		if err := tx.Create(report).Error; err != nil {
+			return fmt.Errorf("storing report: %w", err)
+		}
+		return nil
+	})
+}
+
+// CountReports returns the number of reports and appeals submitted by `reporter`
+// since the given time.
+func (s *Server) CountReports(ctx context.Context, reporter string, since time.Time) (int64, error) {
+	return countReports(s.db.WithContext(ctx), reporter, since)
+}
+
+func countReports(tx *gorm.DB, reporter string, since time.Time) (int64, error) {
+	var reports, appeals int64
+	err := tx.Model(&Report{}).
+		Where("reported_by = ? and created_at >= ?", reporter, since.UTC()).
+		Count(&reports).Error
+	if err != nil {
+		return 0, fmt.Errorf("counting reports: %w", err)
+	}
+	err = tx.Model(&Appeal{}).
+		Where("reported_by = ? and created_at >= ?", reporter, since.UTC()).
+		Count(&appeals).Error
+	if err != nil {
+		return 0, fmt.Errorf("counting appeals: %w", err)
+	}
+	return reports + appeals, nil
+}
+
+// RateLimitError is returned when a reporter has submitted too many reports and appeals.
+type RateLimitError struct {
+	Limit config.RateLimit
+}
+
+func (e *RateLimitError) Error() string {
+	return fmt.Sprintf("too many reports, at most %d are allowed per %s", e.Limit.Count, e.Limit.Period)
+}
+
+// createWithinLimits calls create in the same transaction that checks the limits,
+// so that concurrent requests can't exceed them. The transaction is serializable,
+// and is retried if it conflicts with another one.
+func (s *Server) createWithinLimits(ctx context.Context, reporter string, limits []config.RateLimit, create func(tx *gorm.DB) error) error {
+	var err error
+	for i := 0; i < 5; i++ {
+		err = s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+			now := time.Now()
+			for _, limit := range limits {
+				count, err := countReports(tx, reporter, now.Add(-limit.Period))
+				if err != nil {
+					return err
+				}
+				if count >= int64(limit.Count) {
+					return &RateLimitError{Limit: limit}
+				}
+			}
+			return create(tx)
+		}, &sql.TxOptions{Isolation: sql.LevelSerializable})
+		if err == nil || errors.As(err, new(*RateLimitError)) || ctx.Err() != nil {
+			return err
+		}
+	}
+	return err
+}
+
+// GetReport returns the report with the given ID.
+func (s *Server) GetReport(ctx context.Context, id int64) (*Report, error) {
+	r := &Report{}
+	err := s.db.WithContext(ctx).Preload("LabelSeqs").Where("id = ?", id).Take(r).Error
+	if err != nil {
+		return nil, fmt.Errorf("fetching report %d: %w", id, err)
+	}
+	return r, nil
+}
+
+// OpenReportsBySubject returns open reports grouped by subject. Groups are ordered
+// by the ID of their oldest open report, and only groups where that ID is greater
+// than `after` are returned, so it can be used as a cursor.
+func (s *Server) OpenReportsBySubject(ctx context.Context, after int64, limit int) ([]ReportGroup, error) {
+	var subjects []struct {
+		SubjectUri string
+		FirstID    int64
+	}
+	err := s.db.WithContext(ctx).Model(&Report{}).
+		Select("subject_uri, min(id) as first_id").
+		Where("status = ?", ReportOpen).
+		Group("subject_uri").
+		Having("min(id) > ?", after).
+		Order("first_id asc").
+		Limit(limit).
+		Scan(&subjects).Error
+	if err != nil {
+		return nil, fmt.Errorf("querying reported subjects: %w", err)
+	}
+	if len(subjects) == 0 {
+		return nil, nil
+	}
+
+	uris := []string{}
+	for _, subj := range subjects {
+		uris = append(uris, subj.SubjectUri)
+	}
+	var reports []Report
+	err = s.db.WithContext(ctx).Model(&Report{}).
+		Where("status = ? and subject_uri in ?", ReportOpen, uris).
+		Order("id asc").
+		Find(&reports).Error
+	if err != nil {
+		return nil, fmt.Errorf("querying open reports: %w", err)
+	}
+
+	groups := make([]ReportGroup, len(subjects))
+	idx := map[string]int{}
+	for i, subj := range subjects {
+		groups[i].SubjectUri = subj.SubjectUri
+		idx[subj.SubjectUri] = i
+	}
+	for _, r := range reports {
+		i := idx[r.SubjectUri]
+		groups[i].Reports = append(groups[i].Reports, r)
+	}
+	return groups, nil
+}
+
+// AssignReports assigns open reports to a moderator. Empty `assignee` unassigns them.
+func (s *Server) AssignReports(ctx context.Context, ids []int64, assignee string) error {
+	return s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		r := tx.Model(&Report{}).
+			Where("id in ? and status = ?", ids, ReportOpen).
+			Update("assigned_to", assignee)
+		if r.Error != nil {
+			return fmt.Errorf("updating reports: %w", r.Error)
+		}
+		if r.RowsAffected != int64(len(ids)) {
+			return ErrReportNotOpen
+		}
+		return nil
+	})
+}
+
+// ErrReportNotOpen is returned when trying to modify reports that either don't
+// exist or are already resolved.
+var ErrReportNotOpen = errors.New("some of the reports don't exist or are already resolved")
+
+// ResolveReports marks open reports as resolved, and writes the provided labels.
+// Sequence numbers of written labels are linked to each of the reports. Both
+// happen in the same transaction, so either all of it succeeds or nothing.
+// Returned results correspond to the labels.
+func (s *Server) ResolveReports(ctx context.Context, ids []int64, resolvedBy string, comment string, labels []comatproto.LabelDefs_Label, opts ...WriteOption) ([]LabelResult, error) {
+	if len(ids) == 0 {
+		return nil, fmt.Errorf("no reports to resolve")
+	}
+	resolve := func(tx *gorm.DB, seqs []int64) error {
+		now := time.Now().UTC()
+		r := tx.Model(&Report{}).
+			Where("id in ? and status = ?", ids, ReportOpen).
+			Updates(map[string]any{
+				"status":      ReportResolved,
+				"resolved_by": resolvedBy,
+				"resolved_at": now,
+				"comment":     comment,
+			})
+		if r.Error != nil {
+			return fmt.Errorf("updating reports: %w", r.Error)
+		}
+		if r.RowsAffected != int64(len(ids)) {
+			return ErrReportNotOpen
+		}
+		links := []ReportLabel{}
+		for _, id := range ids {
+			for _, seq := range seqs {
+				links = append(links, ReportLabel{ReportID: id, Seq: seq})
+			}
+		}
+		if len(links) > 0 {
+			if err := tx.Create(&links).Error; err != nil {
+				return fmt.Errorf("linking labels to reports: %w", err)
+			}
+		}
+		return nil
+	}
+
+	if len(labels) == 0 {
+		o := applyWriteOptions(opts)
+		err := s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+			if err := resolve(tx, nil); err != nil {
+				return err
+			}
+			if o.dryRun {
+				return errDryRun
+			}
+			return nil
+		})
+		if err != nil && !errors.Is(err, errDryRun) {
+			return nil, err
+		}
+		return nil, nil
+	}
+
+	for _, l := range labels {
+		if err := s.ValidateLabel(l); err != nil {
+			return nil, err
+		}
+	}
+	opts = append(opts, func(o *writeOptions) {
+		o.atomic = true
+		o.txHook = func(tx *gorm.DB, entries []*Entry, updated []bool) error {
+			seqs := []int64{}
+			for i, e := range entries {
+				if updated[i] {
+					seqs = append(seqs, e.Seq)
+				}
+			}
+			return resolve(tx, seqs)
+		}
+	})
+	return s.AddLabels(ctx, labels, opts...)
+}
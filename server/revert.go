@@ -0,0 +1,148 @@
+package server
+
+import (
+	"context"
+	"fmt"
+
+	comatproto "github.com/bluesky-social/indigo/api/atproto"
+)
+
+// RevertFilter selects entries to revert.
+type RevertFilter struct {
+	// FromSeq and ToSeq specify the range of sequence numbers, inclusive.
+	// ToSeq of 0 means no upper bound.
+	FromSeq int64
+	ToSeq   int64
+	// If set, only entries with this label value are reverted.
+	Val string
+	// If set, only entries written by this actor are reverted.
+	Actor string
+}
+
+// RevertItem describes a compensating label written (or, in dry-run mode,
+// that would be written) by Revert.
+type RevertItem struct {
+	Label comatproto.LabelDefs_Label
+	LabelResult
+}
+
+// RevertResult is the outcome of Revert.
+type RevertResult struct {
+	Items []RevertItem
+	// Conflicts lists the latest entries of labels that were modified after
+	// the first matching entry by something not selected by the filter. Such
+	// labels are left untouched, to avoid undoing unrelated changes.
+	Conflicts []Entry
+	// LastSeq is the highest sequence number among the selected entries, or 0
+	// if none were selected. Use it as ToSeq to repeat the same revert later
+	// without picking up entries written in between.
+	LastSeq int64
+}
+
+type labelKey struct {
+	Src, Val, Uri, Cid string
+}
+
+func keyOf(e *Entry) labelKey {
+	return labelKey{Src: e.Src, Val: e.Val, Uri: e.Uri, Cid: e.Cid}
+}
+
+// Revert writes labels that return each label modified by the selected entries
+// to the state it had before the first of them. Options are passed to AddLabels.
+func (s *Server) Revert(ctx context.Context, filter RevertFilter, opts ...WriteOption) (*RevertResult, error) {
+	if filter.FromSeq <= 0 {
+		return nil, fmt.Errorf("start of the range must be positive")
+	}
+	if filter.ToSeq != 0 && filter.ToSeq < filter.FromSeq {
+		return nil, fmt.Errorf("end of the range must not be less than the start")
+	}
+
+	q := s.db.WithContext(ctx).Model(&Entry{}).Where("seq >= ?", filter.FromSeq)
+	if filter.ToSeq != 0 {
+		q = q.Where("seq <= ?", filter.ToSeq)
+	}
+	if filter.Val != "" {
+		q = q.Where("val = ?", filter.Val)
+	}
+	if filter.Actor != "" {
+		q = q.Where("actor = ?", filter.Actor)
+	}
+	var selected []Entry
+	if err := q.Order("seq asc").Find(&selected).Error; err != nil {
+		return nil, fmt.Errorf("querying entries to revert: %w", err)
+	}
+
+	r := &RevertResult{}
+	firstSeq := map[labelKey]int64{}
+	selectedSeqs := map[int64]bool{}
+	keys := []labelKey{}
+	for _, e := range selected {
+		r.LastSeq = max(r.LastSeq, e.Seq)
+		selectedSeqs[e.Seq] = true
+		k := keyOf(&e)
+		if _, found := firstSeq[k]; !found {
+			firstSeq[k] = e.Seq
+			keys = append(keys, k)
+		}
+	}
+
+	labels := []comatproto.LabelDefs_Label{}
+	for _, k := range keys {
+		var history []Entry
+		err := s.db.WithContext(ctx).Model(&Entry{}).
+			Where("src = ? and val = ? and uri = ? and cid = ?", k.Src, k.Val, k.Uri, k.Cid).
+			Order("seq asc").
+			Find(&history).Error
+		if err != nil {
+			return nil, fmt.Errorf("querying label history: %w", err)
+		}
+
+		var before *Entry
+		conflict := false
+		for i := range history {
+			e := &history[i]
+			if e.Seq < firstSeq[k] {
+				before = e
+				continue
+			}
+			if !selectedSeqs[e.Seq] {
+				conflict = true
+			}
+		}
+		current := &history[len(history)-1]
+		if conflict {
+			r.Conflicts = append(r.Conflicts, *current)
+			continue
+		}
+
+		wasActive := before != nil && !before.Neg
+		isActive := !current.Neg
+		switch {
+		case !wasActive && !isActive:
+			continue
+		case !wasActive:
+			labels = append(labels, comatproto.LabelDefs_Label{Src: k.Src, Val: k.Val, Uri: k.Uri, Cid: optional(k.Cid), Neg: ptr(true)})
+		case !isActive || before.Exp != current.Exp:
+			labels = append(labels, comatproto.LabelDefs_Label{Src: k.Src, Val: k.Val, Uri: k.Uri, Cid: optional(k.Cid), Exp: optional(before.Exp)})
+		}
+	}
+	if len(labels) == 0 {
+		return r, nil
+	}
+
+	results, err := s.AddLabels(ctx, labels, opts...)
+	if err != nil {
+		return nil, err
+	}
+	for i, l := range labels {
+		r.Items = append(r.Items, RevertItem{Label: l, LabelResult: results[i]})
+	}
+	return r, nil
+}
+
+func optional(s string) *string {
+	if s == "" {
+		return nil
+	}
+	return &s
+}
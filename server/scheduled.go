@@ -0,0 +1,244 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/rs/zerolog"
+	"gorm.io/gorm"
+
+	comatproto "github.com/bluesky-social/indigo/api/atproto"
+)
+
+// Scheduled label statuses.
+const (
+	ScheduledPending   = "pending"
+	ScheduledDone      = "done"
+	ScheduledCancelled = "cancelled"
+	// ScheduledFailed is set for labels that were rejected at the scheduled time.
+	ScheduledFailed = "failed"
+)
+
+// schedulerBatchSize limits how many due items are processed in one go.
+const schedulerBatchSize = 100
+
+// ScheduledLabel is a label to be applied or negated at a given time.
+type ScheduledLabel struct {
+	ID        int64     `gorm:"primaryKey"`
+	CreatedAt time.Time `gorm:"not null"`
+	CreatedBy string    `gorm:"not null;default:''"`
+	RunAt     time.Time `gorm:"not null;index"`
+
+	Uri string `gorm:"not null"`
+	Cid string `gorm:"not null;default:''"`
+	Val string `gorm:"not null"`
+	Src string `gorm:"not null"`
+	Neg bool   `gorm:"not null;default:false"`
+	Exp string `gorm:"not null;default:''"`
+
+	Status     string `gorm:"not null;default:'pending';index"`
+	ResolvedBy string `gorm:"not null;default:''"`
+	ResolvedAt *time.Time
+	Error      string `gorm:"not null;default:''"`
+	// Seq is the sequence number of the written label, if it had any effect.
+	Seq int64 `gorm:"not null;default:0"`
+}
+
+// Label returns the label that will be written at the scheduled time.
+func (l *ScheduledLabel) Label() comatproto.LabelDefs_Label {
+	r := comatproto.LabelDefs_Label{Uri: l.Uri, Cid: optional(l.Cid), Val: l.Val, Src: l.Src, Exp: optional(l.Exp)}
+	if l.Neg {
+		r.Neg = ptr(true)
+	}
+	return r
+}
+
+// ErrScheduledNotPending is returned when trying to cancel a scheduled label
+// that doesn't exist or was already written or cancelled.
+var ErrScheduledNotPending = errors.New("scheduled label doesn't exist or is not pending anymore")
+
+// ScheduleLabel stores the label to be written at the given time. The label is
+// validated now, and once again when it's written.
+func (s *Server) ScheduleLabel(ctx context.Context, label comatproto.LabelDefs_Label, at time.Time, createdBy string) (*ScheduledLabel, error) {
+	if !at.After(time.Now()) {
+		return nil, fmt.Errorf("scheduled time must be in the future")
+	}
+	entry, err := s.prepareLabel(label)
+	if err != nil {
+		return nil, err
+	}
+	if entry.Exp != "" && !entry.Neg {
+		exp, err := time.Parse(time.RFC3339, entry.Exp)
+		if err == nil && !exp.After(at) {
+			return nil, fmt.Errorf("`exp` must be after the scheduled time")
+		}
+	}
+	l := &ScheduledLabel{
+		CreatedAt: time.Now().UTC(),
+		CreatedBy: createdBy,
+		RunAt:     at.UTC(),
+		Uri:       entry.Uri,
+		Cid:       entry.Cid,
+		Val:       entry.Val,
+		Src:       entry.Src,
+		Neg:       entry.Neg,
+		Exp:       entry.Exp,
+		Status:    ScheduledPending,
+	}
+	if err := s.db.WithContext(ctx).Create(l).Error; err != nil {
+		return nil, fmt.Errorf("storing scheduled label: %w", err)
+	}
+	return l, nil
+}
+
+// GetScheduledLabel returns the scheduled label with the given ID.
+func (s *Server) GetScheduledLabel(ctx context.Context, id int64) (*ScheduledLabel, error) {
+	r := &ScheduledLabel{}
+	if err := s.db.WithContext(ctx).Where("id = ?", id).Take(r).Error; err != nil {
+		return nil, fmt.Errorf("fetching scheduled label %d: %w", id, err)
+	}
+	return r, nil
+}
+
+// PendingScheduledLabels returns scheduled labels that are not written yet,
+// with ID greater than `after`, in the order they were created.
+func (s *Server) PendingScheduledLabels(ctx context.Context, after int64, limit int) ([]ScheduledLabel, error) {
+	var r []ScheduledLabel
+	err := s.db.WithContext(ctx).
+		Where("status = ? and id > ?", ScheduledPending, after).
+		Order("id asc").
+		Limit(limit).
+		Find(&r).Error
+	if err != nil {
+		return nil, fmt.Errorf("querying scheduled labels: %w", err)
+	}
+	return r, nil
+}
+
+func closeScheduledLabel(tx *gorm.DB, id int64, updates map[string]any) error {
+	updates["resolved_at"] = time.Now().UTC()
+	r := tx.Model(&ScheduledLabel{}).Where("id = ? and status = ?", id, ScheduledPending).Updates(updates)
+	if r.Error != nil {
+		return fmt.Errorf("updating scheduled label: %w", r.Error)
+	}
+	if r.RowsAffected != 1 {
+		return ErrScheduledNotPending
+	}
+	return nil
+}
+
+// CancelScheduledLabel cancels a pending scheduled label.
+func (s *Server) CancelScheduledLabel(ctx context.Context, id int64, cancelledBy string) error {
+	return s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		return closeScheduledLabel(tx, id, map[string]any{"status": ScheduledCancelled, "resolved_by": cancelledBy})
+	})
+}
+
+// ApplyScheduledLabels writes scheduled labels that are due, and returns how
+// many of them were processed. Like with PublishDelayedLabels, each label is
+// marked as done in the same transaction that writes it, so it's safe to call
+// this concurrently, including from multiple replicas sharing the database.
+// Labels that require approval by the time they're due are marked as failed.
+func (s *Server) ApplyScheduledLabels(ctx context.Context) (int, error) {
+	var due []ScheduledLabel
+	err := s.db.WithContext(ctx).
+		Where("status = ? and run_at <= ?", ScheduledPending, time.Now().UTC()).
+		Order("run_at asc, id asc").
+		Limit(schedulerBatchSize).
+		Find(&due).Error
+	if err != nil {
+		return 0, fmt.Errorf("querying scheduled labels: %w", err)
+	}
+
+	n := 0
+	for _, l := range due {
+		fail := func(reason error) error {
+			err := s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+				return closeScheduledLabel(tx, l.ID, map[string]any{"status": ScheduledFailed, "error": reason.Error()})
+			})
+			if err != nil && !errors.Is(err, ErrScheduledNotPending) {
+				return err
+			}
+			return nil
+		}
+		if s.RequiresApproval(l.Val) {
+			// Approval policy might have changed since the label was scheduled.
+			if err := fail(fmt.Errorf("%w: %q can't be written by the scheduler", ErrApprovalRequired, l.Val)); err != nil {
+				return n, err
+			}
+			n++
+			continue
+		}
+
+		results, err := s.AddLabels(ctx, []comatproto.LabelDefs_Label{l.Label()}, WithActor(l.CreatedBy), RequireApproval(), func(o *writeOptions) {
+			o.txHook = func(tx *gorm.DB, entries []*Entry, updated []bool) error {
+				seq := int64(0)
+				for i, e := range entries {
+					if updated[i] && e.Val == l.Val && e.Uri == l.Uri {
+						seq = e.Seq
+					}
+				}
+				return closeScheduledLabel(tx, l.ID, map[string]any{"status": ScheduledDone, "seq": seq})
+			}
+		})
+		switch {
+		case errors.Is(err, ErrScheduledNotPending):
+			continue
+		case err != nil:
+			return n, fmt.Errorf("writing scheduled label %d: %w", l.ID, err)
+		case results[0].Err != nil:
+			if err := fail(results[0].Err); err != nil {
+				return n, err
+			}
+		}
+		n++
+	}
+	return n, nil
+}
+
+// schedulerJob is run by RunScheduler, and returns the number of processed items.
+// It is called again right away if it has processed a full batch.
+type schedulerJob struct {
+	name string
+	run  func(context.Context) (int, error)
+}
+
+// RunScheduler publishes delayed labels and writes scheduled labels as they
+// become due, checking every `interval` until the context is cancelled. If
+// enabled with SetNegateExpired, it also writes negations for expired labels.
+// State is kept in the database, so pending work survives restarts.
+func (s *Server) RunScheduler(ctx context.Context, interval time.Duration) {
+	log := zerolog.Ctx(ctx)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		jobs := []schedulerJob{
+			{"delayed", s.PublishDelayedLabels},
+			{"scheduled", s.ApplyScheduledLabels},
+		}
+		s.mu.RLock()
+		if s.negateExpired {
+			jobs = append(jobs, schedulerJob{"expired", s.NegateExpiredLabels})
+		}
+		s.mu.RUnlock()
+		for _, job := range jobs {
+			for {
+				n, err := job.run(ctx)
+				if err != nil {
+					log.Error().Err(err).Str("job", job.name).Msgf("Failed to process %s labels: %s", job.name, err)
+					break
+				}
+				if n < schedulerBatchSize {
+					break
+				}
+			}
+		}
+	}
+}
@@ -2,24 +2,43 @@ package server
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/google/go-cmp/cmp"
 	"github.com/google/go-cmp/cmp/cmpopts"
 
 	comatproto "github.com/bluesky-social/indigo/api/atproto"
 
+	"bsky.watch/labeler/auth"
 	"bsky.watch/labeler/config"
 )
 
-const labelerDID = "did:example"
-const testDID = "did:foo"
-const otherDID = "did:bar"
+const labelerDID = "did:plc:labeler"
+const testDID = "did:plc:foo"
+const otherDID = "did:plc:bar"
 const privateKey = "c6d40ec53c689ca905036e41d8c73560777e5746d1d228fd6f9db56efed8ecaf"
 
 var dbCount = 0
 
+// Test cases use short placeholders for `cid` and `exp`, which are
+// replaced with these valid values before writing.
+var testCIDs = map[string]string{
+	"a": "bafyreigks6arfsq3xxfpvqrrwonchxcnu6do76auprhhfomao6c273sixm",
+	"b": "bafyreib6epubmabzlffdhckpmvsodmjuro6xuaei2qwevs3t52xnlhaatu",
+	"c": "bafyreibopuwahkkqplrgl3hvwu2wrbnfgoj2eau5eqjzjglsmwq2ewxpyy",
+	"d": "bafyreiayvq7hgq7qc2eqyuiosp4tkjqrnhm6h5lfinsctaypv4etj5hy4q",
+}
+var testExps = map[string]string{
+	"a": "2100-01-01T00:00:00Z",
+	"b": "2100-01-02T00:00:00Z",
+	"c": "2100-01-03T00:00:00Z",
+	"d": "2100-01-04T00:00:00Z",
+}
+
 func NewTestServer(ctx context.Context) (*Server, error) {
 	config := &config.Config{
 		SQLiteDB:   fmt.Sprintf("file:testdb%d?mode=memory&cache=shared", dbCount),
@@ -169,40 +188,448 @@ func TestBasic(t *testing.T) {
 
 	for _, tc := range cases {
 		tc := tc
-		t.Run(tc.Name, func(t *testing.T) {
-			server, err := NewTestServer(ctx)
-			if err != nil {
-				t.Fatal(err)
+		for _, batch := range []bool{false, true} {
+			name := tc.Name
+			if batch {
+				name += " (batch)"
 			}
+			t.Run(name, func(t *testing.T) {
+				server, err := NewTestServer(ctx)
+				if err != nil {
+					t.Fatal(err)
+				}
 
-			for _, l := range tc.Labels {
-				if l.Uri == "" {
-					l.Uri = testDID
+				labels := []comatproto.LabelDefs_Label{}
+				for _, l := range tc.Labels {
+					if l.Uri == "" {
+						l.Uri = testDID
+					}
+					if l.Cid != nil {
+						l.Cid = ptr(testCIDs[*l.Cid])
+					}
+					if l.Exp != nil {
+						l.Exp = ptr(testExps[*l.Exp])
+					}
+					labels = append(labels, l)
 				}
-				if _, err := server.AddLabel(ctx, l); err != nil {
-					t.Fatal(err)
+				if batch {
+					results, err := server.AddLabels(ctx, labels)
+					if err != nil {
+						t.Fatal(err)
+					}
+					for _, r := range results {
+						if r.Err != nil {
+							t.Fatal(r.Err)
+						}
+					}
+				} else {
+					for _, l := range labels {
+						if _, err := server.AddLabel(ctx, l); err != nil {
+							t.Fatal(err)
+						}
+					}
 				}
-			}
 
-			entries, err := server.query(ctx, queryRequestGet{UriPatterns: []string{testDID}})
-			if err != nil {
-				t.Fatal(err)
-			}
+				entries, err := server.query(ctx, queryRequestGet{UriPatterns: []string{testDID}})
+				if err != nil {
+					t.Fatal(err)
+				}
 
-			expected := []Entry{}
-			for _, l := range tc.ExpectedLabels {
-				l.Uri = testDID
-				expected = append(expected, l)
-			}
-			if diff := cmp.Diff(expected, entries, cmpOpts...); diff != "" {
-				t.Errorf(diff)
+				expected := []Entry{}
+				for _, l := range tc.ExpectedLabels {
+					l.Uri = testDID
+					if l.Cid != "" {
+						l.Cid = testCIDs[l.Cid]
+					}
+					if l.Exp != "" {
+						l.Exp = testExps[l.Exp]
+					}
+					expected = append(expected, l)
+				}
+				if diff := cmp.Diff(expected, entries, cmpOpts...); diff != "" {
+					t.Errorf(diff)
 
-				var entries []Entry
-				server.db.Model(&entries).Order("seq asc").Find(&entries)
-				for _, e := range entries {
-					t.Logf("%+v", e)
+					var entries []Entry
+					server.db.Model(&entries).Order("seq asc").Find(&entries)
+					for _, e := range entries {
+						t.Logf("%+v", e)
+					}
 				}
-			}
-		})
+			})
+		}
+	}
+}
+
+func TestDryRun(t *testing.T) {
+	ctx := context.Background()
+	server, err := NewTestServer(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := server.AddLabel(ctx, comatproto.LabelDefs_Label{Uri: testDID, Val: "a"}); err != nil {
+		t.Fatal(err)
+	}
+
+	labels := []comatproto.LabelDefs_Label{
+		{Uri: testDID, Val: "a"},
+		{Uri: testDID, Val: "b"},
+		{Uri: testDID, Val: "b"},
+		{Uri: testDID, Val: "a", Neg: ptr(true)},
+		{Uri: testDID, Val: "c", Neg: ptr(true)},
+	}
+	results, err := server.AddLabels(ctx, labels, DryRun())
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := []bool{}
+	for _, r := range results {
+		if r.Err != nil {
+			t.Fatal(r.Err)
+		}
+		got = append(got, r.Changed)
+	}
+	if diff := cmp.Diff([]bool{false, true, false, true, false}, got); diff != "" {
+		t.Errorf("unexpected results (-want +got):\n%s", diff)
+	}
+
+	changed, err := server.AddLabel(ctx, comatproto.LabelDefs_Label{Uri: testDID, Val: "b"}, DryRun())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !changed {
+		t.Errorf("expected dry run of a new label to report a change")
+	}
+
+	entries, err := server.query(ctx, queryRequestGet{UriPatterns: []string{testDID}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff([]Entry{{Uri: testDID, Val: "a"}}, entries, cmpopts.IgnoreFields(Entry{}, "Seq", "Cts", "Src")); diff != "" {
+		t.Errorf("dry run modified the database (-want +got):\n%s", diff)
+	}
+}
+
+func TestExpirationPolicy(t *testing.T) {
+	ctx := context.Background()
+	server, err := NewTestServer(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server.SetExpiration(map[string]config.Expiration{
+		"temp": {Default: time.Hour, Max: 2 * time.Hour},
+		"*":    {Max: 24 * time.Hour},
+	})
+
+	later := time.Now().Add(48 * time.Hour).UTC().Format(time.RFC3339)
+	labels := []comatproto.LabelDefs_Label{
+		{Uri: testDID, Val: "temp"},
+		{Uri: testDID, Val: "temp"}, // no-op, still active
+		{Uri: otherDID, Val: "temp", Exp: ptr(later)},
+		{Uri: testDID, Val: "other"},
+	}
+	results, err := server.AddLabels(ctx, labels)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := []bool{}
+	for _, r := range results {
+		if r.Err != nil {
+			t.Fatal(r.Err)
+		}
+		got = append(got, r.Changed)
+	}
+	if diff := cmp.Diff([]bool{true, false, true, true}, got); diff != "" {
+		t.Errorf("unexpected results (-want +got):\n%s", diff)
+	}
+
+	expected := map[string]time.Duration{
+		testDID + " temp":  time.Hour,
+		otherDID + " temp": 2 * time.Hour,
+		testDID + " other": 24 * time.Hour,
+	}
+	entries, err := server.query(ctx, queryRequestGet{UriPatterns: []string{testDID, otherDID}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != len(expected) {
+		t.Fatalf("expected %d entries, got %+v", len(expected), entries)
+	}
+	for _, e := range entries {
+		exp, err := time.Parse(time.RFC3339, e.Exp)
+		if err != nil {
+			t.Fatalf("%s %s: invalid exp %q: %s", e.Uri, e.Val, e.Exp, err)
+		}
+		want := time.Now().Add(expected[e.Uri+" "+e.Val])
+		if exp.Sub(want).Abs() > time.Minute {
+			t.Errorf("%s %s: expected exp around %s, got %s", e.Uri, e.Val, want, exp)
+		}
+	}
+}
+
+func TestSubjectRules(t *testing.T) {
+	ctx := context.Background()
+	server, err := NewTestServer(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server.SetSubjectRules(map[string]config.SubjectRule{
+		"account": {Types: []string{"account"}},
+		"post":    {Types: []string{"record"}, Collections: []string{"app.bsky.feed.post"}, RequireCID: true},
+	})
+
+	const post = "at://did:plc:foo/app.bsky.feed.post/abc"
+	const like = "at://did:plc:foo/app.bsky.feed.like/abc"
+	cases := []struct {
+		label comatproto.LabelDefs_Label
+		ok    bool
+	}{
+		{comatproto.LabelDefs_Label{Uri: testDID, Val: "account"}, true},
+		{comatproto.LabelDefs_Label{Uri: post, Val: "account"}, false},
+		{comatproto.LabelDefs_Label{Uri: post, Val: "post", Cid: ptr("bafyreie5737gdxlw5i64vzichcalba3z2v5n6icifvx5xytvske7mr3hpm")}, true},
+		{comatproto.LabelDefs_Label{Uri: post, Val: "post"}, false},
+		{comatproto.LabelDefs_Label{Uri: like, Val: "post", Cid: ptr("bafyreie5737gdxlw5i64vzichcalba3z2v5n6icifvx5xytvske7mr3hpm")}, false},
+		{comatproto.LabelDefs_Label{Uri: testDID, Val: "post"}, false},
+		{comatproto.LabelDefs_Label{Uri: testDID, Val: "post", Neg: ptr(true)}, true},
+		{comatproto.LabelDefs_Label{Uri: like, Val: "other"}, true},
+	}
+	for _, tc := range cases {
+		err := server.ValidateLabel(tc.label)
+		if (err == nil) != tc.ok {
+			t.Errorf("%s on %s: expected ok=%v, got error %v", tc.label.Val, tc.label.Uri, tc.ok, err)
+		}
+	}
+}
+
+func TestLabelSyntax(t *testing.T) {
+	ctx := context.Background()
+	server, err := NewTestServer(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	const post = "at://did:plc:foo/app.bsky.feed.post/abc"
+	cases := []struct {
+		label comatproto.LabelDefs_Label
+		ok    bool
+	}{
+		{comatproto.LabelDefs_Label{Uri: testDID, Val: "spam"}, true},
+		{comatproto.LabelDefs_Label{Uri: post, Val: "spam", Cid: ptr(testCIDs["a"]), Exp: ptr(testExps["a"])}, true},
+		{comatproto.LabelDefs_Label{Uri: testDID, Val: "!hide"}, true},
+		{comatproto.LabelDefs_Label{Uri: "did:foo", Val: "spam"}, false},
+		{comatproto.LabelDefs_Label{Uri: "https://example.com", Val: "spam"}, false},
+		{comatproto.LabelDefs_Label{Uri: post, Val: "spam", Cid: ptr("not-a-cid")}, false},
+		{comatproto.LabelDefs_Label{Uri: post, Val: "spam", Cid: ptr("QmYwAPJzv5CZsnA625s3Xf2nemtYgPpHdWEz79ojWnPbdG")}, false},
+		{comatproto.LabelDefs_Label{Uri: testDID, Val: "Spam"}, false},
+		{comatproto.LabelDefs_Label{Uri: testDID, Val: strings.Repeat("a", 129)}, false},
+		{comatproto.LabelDefs_Label{Uri: testDID, Val: "spam", Exp: ptr("tomorrow")}, false},
+		{comatproto.LabelDefs_Label{Uri: testDID, Val: "spam", Src: "example.com"}, false},
+		{comatproto.LabelDefs_Label{Uri: testDID, Val: "!takedown"}, false},
+		// Old entries could have been written without these checks, so negations are allowed anyway.
+		{comatproto.LabelDefs_Label{Uri: "did:foo", Val: "Spam", Neg: ptr(true)}, true},
+		{comatproto.LabelDefs_Label{Uri: post, Val: "spam", Cid: ptr("not-a-cid"), Neg: ptr(true)}, true},
+		{comatproto.LabelDefs_Label{Uri: testDID, Val: "", Neg: ptr(true)}, false},
+		{comatproto.LabelDefs_Label{Uri: testDID, Val: "spam", Src: "example.com", Neg: ptr(true)}, false},
+	}
+	for _, tc := range cases {
+		err := server.ValidateLabel(tc.label)
+		if (err == nil) != tc.ok {
+			t.Errorf("%+v: expected ok=%v, got error %v", tc.label, tc.ok, err)
+		}
+	}
+
+	server.SetSystemLabels([]string{})
+	if err := server.ValidateLabel(comatproto.LabelDefs_Label{Uri: testDID, Val: "!hide"}); err == nil {
+		t.Errorf("expected !hide to be rejected")
+	}
+	if err := server.ValidateLabel(comatproto.LabelDefs_Label{Uri: testDID, Val: "!hide", Neg: ptr(true)}); err != nil {
+		t.Errorf("expected negation of !hide to be allowed, got %s", err)
+	}
+}
+
+func TestExclusiveGroups(t *testing.T) {
+	ctx := context.Background()
+	server, err := NewTestServer(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server.SetExclusiveGroups(map[string][]string{"severity": {"low", "medium", "high"}})
+
+	for _, val := range []string{"low", "other", "high"} {
+		if _, err := server.AddLabel(ctx, comatproto.LabelDefs_Label{Uri: testDID, Val: val}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	results, err := server.AddLabels(ctx, []comatproto.LabelDefs_Label{
+		{Uri: otherDID, Val: "medium"},
+		{Uri: otherDID, Val: "low"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, r := range results {
+		if r.Err != nil || !r.Changed {
+			t.Fatalf("unexpected result: %+v", r)
+		}
+	}
+
+	entries, err := server.query(ctx, queryRequestGet{UriPatterns: []string{testDID, otherDID}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := []string{}
+	for _, e := range entries {
+		got = append(got, e.Uri+" "+e.Val)
+	}
+	want := []string{testDID + " other", testDID + " high", otherDID + " low"}
+	if diff := cmp.Diff(want, got, cmpopts.SortSlices(func(a, b string) bool { return a < b })); diff != "" {
+		t.Errorf("unexpected labels (-want +got):\n%s", diff)
+	}
+
+	history, err := server.SubjectHistory(ctx, testDID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got = []string{}
+	for _, e := range history {
+		if e.Neg {
+			got = append(got, "-"+e.Val)
+		} else {
+			got = append(got, "+"+e.Val)
+		}
+	}
+	if diff := cmp.Diff([]string{"+low", "+other", "-low", "+high"}, got); diff != "" {
+		t.Errorf("unexpected history (-want +got):\n%s", diff)
+	}
+
+	// Values are negated whatever CID they were applied with.
+	const post = "at://did:plc:foo/app.bsky.feed.post/abc"
+	for _, l := range []comatproto.LabelDefs_Label{
+		{Uri: post, Val: "low", Cid: ptr(testCIDs["a"])},
+		{Uri: post, Val: "medium", Cid: ptr(testCIDs["b"])},
+		{Uri: post, Val: "high"},
+	} {
+		if _, err := server.AddLabel(ctx, l); err != nil {
+			t.Fatal(err)
+		}
+	}
+	entries, err = server.query(ctx, queryRequestGet{UriPatterns: []string{post}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	got = []string{}
+	for _, e := range entries {
+		got = append(got, e.Val+" "+e.Cid)
+	}
+	if diff := cmp.Diff([]string{"high "}, got); diff != "" {
+		t.Errorf("unexpected labels on the post (-want +got):\n%s", diff)
+	}
+}
+
+func TestExclusiveGroupsPermissions(t *testing.T) {
+	ctx := context.Background()
+	server, err := NewTestServer(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server.SetExclusiveGroups(map[string][]string{"severity": {"low", "high"}})
+	if _, err := server.AddLabel(ctx, comatproto.LabelDefs_Label{Uri: testDID, Val: "low"}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Allowed to use "high", but not to remove "low".
+	ctx = auth.NewContext(ctx, &auth.Principal{Name: "mod", Labels: []string{"high"}})
+	if _, err := server.AddLabel(ctx, comatproto.LabelDefs_Label{Uri: testDID, Val: "high"}); !errors.Is(err, ErrForbiddenNegation) {
+		t.Errorf("expected ErrForbiddenNegation, got %v", err)
+	}
+	results, err := server.AddLabels(ctx, []comatproto.LabelDefs_Label{
+		{Uri: testDID, Val: "high"},
+		{Uri: otherDID, Val: "high"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !errors.Is(results[0].Err, ErrForbiddenNegation) || results[0].Changed {
+		t.Errorf("expected the label to be rejected, got %+v", results[0])
+	}
+	// Negations of values that the subject doesn't have are no-ops.
+	if results[1].Err != nil || !results[1].Changed {
+		t.Errorf("expected the label to be written, got %+v", results[1])
+	}
+
+	entries, err := server.query(ctx, queryRequestGet{UriPatterns: []string{testDID, otherDID}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := []string{}
+	for _, e := range entries {
+		got = append(got, e.Uri+" "+e.Val)
+	}
+	want := []string{testDID + " low", otherDID + " high"}
+	if diff := cmp.Diff(want, got, cmpopts.SortSlices(func(a, b string) bool { return a < b })); diff != "" {
+		t.Errorf("unexpected labels (-want +got):\n%s", diff)
+	}
+}
+
+func TestNegateExpired(t *testing.T) {
+	ctx := context.Background()
+	server, err := NewTestServer(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	past := time.Now().Add(-time.Minute).UTC().Format(time.RFC3339)
+	future := time.Now().Add(time.Hour).UTC().Format(time.RFC3339)
+	for _, l := range []comatproto.LabelDefs_Label{
+		{Uri: "did:plc:a", Val: "expired", Exp: &past},
+		{Uri: "did:plc:a", Val: "active", Exp: &future},
+		{Uri: "did:plc:a", Val: "forever"},
+		{Uri: "did:plc:b", Val: "expired", Exp: &past},
+	} {
+		if _, err := server.AddLabel(ctx, l); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Re-applied with a new expiration time, shouldn't be negated.
+	if _, err := server.AddLabel(ctx, comatproto.LabelDefs_Label{Uri: "did:plc:b", Val: "expired", Exp: &future}); err != nil {
+		t.Fatal(err)
+	}
+	// Pending re-application shouldn't be cancelled by the negation.
+	server.SetPublicationDelay(map[string]time.Duration{"expired": time.Hour})
+	results, err := server.AddLabels(ctx, []comatproto.LabelDefs_Label{{Uri: "did:plc:a", Val: "expired"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	n, err := server.NegateExpiredLabels(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 1 {
+		t.Errorf("expected one negation, got %d", n)
+	}
+	if n, err := server.NegateExpiredLabels(ctx); err != nil || n != 0 {
+		t.Errorf("expected no negations on the second run, got %d, %v", n, err)
+	}
+
+	history, err := server.SubjectHistory(ctx, "did:plc:a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	last := history[len(history)-1]
+	if last.Val != "expired" || !last.Neg || last.Actor != ExpiryActor {
+		t.Errorf("unexpected last entry: %+v", last)
+	}
+	labels, err := server.SubjectLabels(ctx, "did:plc:a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(labels) != 2 {
+		t.Errorf("expected two labels to remain, got %+v", labels)
+	}
+	if labels, err := server.SubjectLabels(ctx, "did:plc:b"); err != nil || len(labels) != 1 {
+		t.Errorf("expected re-applied label to remain, got %+v, %v", labels, err)
+	}
+	if d, err := server.GetDelayedLabel(ctx, results[0].DelayedID); err != nil || d.Status != DelayedPending {
+		t.Errorf("expected delayed label to remain pending, got %+v, %v", d, err)
 	}
 }
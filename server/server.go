@@ -32,6 +32,7 @@ import (
 	"gorm.io/gorm/logger"
 
 	comatproto "github.com/bluesky-social/indigo/api/atproto"
+	"github.com/bluesky-social/indigo/atproto/syntax"
 
 	"bsky.watch/labeler/config"
 	"bsky.watch/labeler/sign"
@@ -47,10 +48,35 @@ type Server struct {
 	mu            sync.RWMutex
 	wakeChans     []chan struct{}
 	allowedLabels map[string]bool
+	expiration    map[string]config.Expiration
+	subjectRules  map[string]config.SubjectRule
+	systemLabels  map[string]bool
+	// exclusiveGroups maps label values to the other values in the same exclusive groups.
+	exclusiveGroups map[string][]string
+	approvalLabels  map[string]bool
+	approvalExpiry  time.Duration
+	delays          map[string]time.Duration
+	negateExpired   bool
+	sinks           []*registeredSink
 }
 
 // NewWithConfig creates a new server instance using parameters provided in the config.
 func NewWithConfig(ctx context.Context, cfg *config.Config) (*Server, error) {
+	s, err := newWithConfig(ctx, cfg)
+	if err != nil {
+		return nil, err
+	}
+	s.SetExpiration(cfg.Expiration)
+	s.SetSubjectRules(cfg.Subjects)
+	s.SetSystemLabels(cfg.SystemLabels)
+	s.SetExclusiveGroups(cfg.ExclusiveGroups)
+	s.SetApprovalPolicy(cfg.Approval)
+	s.SetPublicationDelay(cfg.Delay)
+	s.SetNegateExpired(cfg.NegateExpired)
+	return s, nil
+}
+
+func newWithConfig(ctx context.Context, cfg *config.Config) (*Server, error) {
 	log := zerolog.Ctx(ctx)
 	cfg.UpdateLabelValues()
 
@@ -132,7 +158,7 @@ func newServer(ctx context.Context, dbUrl string, did string, privateKey *secec.
 		return nil, fmt.Errorf("connecting to the database: %w", err)
 	}
 
-	if err := db.AutoMigrate(&Entry{}); err != nil {
+	if err := db.AutoMigrate(&Entry{}, &IdempotencyRecord{}, &Report{}, &ReportLabel{}, &Appeal{}, &AppealLabel{}, &ApprovalRequest{}, &DelayedLabel{}, &ScheduledLabel{}, &SinkCursor{}); err != nil {
 		return nil, fmt.Errorf("failed to update DB schema: %w", err)
 	}
 
@@ -166,7 +192,7 @@ func newWithSQLite(ctx context.Context, dbpath string, did string, privateKey *s
 		return nil, fmt.Errorf("failed to connect to DB: %w", err)
 	}
 
-	if err := db.AutoMigrate(&Entry{}); err != nil {
+	if err := db.AutoMigrate(&Entry{}, &IdempotencyRecord{}, &Report{}, &ReportLabel{}, &Appeal{}, &AppealLabel{}, &ApprovalRequest{}, &DelayedLabel{}, &ScheduledLabel{}, &SinkCursor{}); err != nil {
 		return nil, fmt.Errorf("failed to update DB schema: %w", err)
 	}
 
@@ -318,24 +344,225 @@ func migrateOldDataToSQLite(ctx context.Context, source migrationAdapter, cfg *c
 	return dummyServer.ImportEntries(labels)
 }
 
+// WriteOption modifies the behaviour of AddLabel and AddLabels.
+type WriteOption func(*writeOptions)
+
+type writeOptions struct {
+	dryRun          bool
+	actor           string
+	requireApproval bool
+	// keepDelayed prevents negations from cancelling pending delayed labels.
+	keepDelayed bool
+	// txHook is called inside the transaction after all entries are written.
+	// `updated` indicates which of the entries were actually written. Returning
+	// an error rolls back the transaction without retrying.
+	txHook func(tx *gorm.DB, entries []*Entry, updated []bool) error
+	// atomic makes the write fail as a whole if any of the labels is rejected
+	// because of the negations it implies, instead of skipping just that label.
+	atomic bool
+}
+
+// DryRun makes AddLabel and AddLabels perform all the checks and report
+// what would've changed, without actually writing anything.
+func DryRun() WriteOption {
+	return func(o *writeOptions) { o.dryRun = true }
+}
+
+// WithActor records who is making the change. It is stored alongside the
+// written entries, but not published.
+func WithActor(actor string) WriteOption {
+	return func(o *writeOptions) { o.actor = actor }
+}
+
+func applyWriteOptions(opts []WriteOption) writeOptions {
+	r := writeOptions{}
+	for _, o := range opts {
+		o(&r)
+	}
+	return r
+}
+
 // AddLabel updates the internal state and writes the label to the database.
 //
 // Note that it will ignore values that have no effect (e.g., if the label already exists,
 // or trying to negate a label that doesn't exist). Return value indicates if
-// there was a change or not.
-func (s *Server) AddLabel(ctx context.Context, label comatproto.LabelDefs_Label) (bool, error) {
+// there was a change or not. Labels with a publication delay (see SetPublicationDelay)
+// are staged instead, and reported as a change if they'd have an effect when written now.
+func (s *Server) AddLabel(ctx context.Context, label comatproto.LabelDefs_Label, opts ...WriteOption) (bool, error) {
+	o := applyWriteOptions(opts)
+	entry, err := s.prepareLabel(label)
+	if err != nil {
+		return false, err
+	}
+	if o.requireApproval && s.RequiresApproval(label.Val) {
+		return false, s.requestApproval(ctx, label, o)
+	}
+	entry.Actor = o.actor
+	if s.shouldDelay(entry, o) {
+		staged, _, errs, err := s.stageLabels(ctx, []*Entry{entry}, o)
+		if err != nil {
+			return false, err
+		}
+		if errs[0] != nil {
+			return false, errs[0]
+		}
+		return staged[0], nil
+	}
+	start := time.Now()
+	r, err := s.writeLabel(ctx, *entry, cancelNegatedLabels([]*Entry{entry}, o))
+	duration := time.Since(start)
+	if o.dryRun {
+		return r, err
+	}
+	if err != nil {
+		writeLatency.WithLabelValues(s.did, "error").Observe(duration.Seconds())
+		return false, err
+	}
+	if r {
+		writeLatency.WithLabelValues(s.did, "written").Observe(duration.Seconds())
+	} else {
+		writeLatency.WithLabelValues(s.did, "noop").Observe(duration.Seconds())
+	}
+	if r {
+		go s.wakeUpSubs()
+	}
+	return r, nil
+}
+
+// LabelResult is the outcome of writing a single label with AddLabels.
+type LabelResult struct {
+	// Changed is true if a new entry was written.
+	Changed bool
+	// Seq is the sequence number of the new entry, if one was written.
+	// Always 0 in dry-run mode.
+	Seq int64
+	// DelayedID is the ID of the DelayedLabel, if the label was staged for
+	// publication later. Always 0 in dry-run mode.
+	DelayedID int64
+	// Err is set if the label was rejected.
+	Err error
+}
+
+// AddLabels is like AddLabel, but writes multiple labels in a single transaction.
+// Labels that fail validation are skipped and have Err set in the corresponding result,
+// the rest are written in the order they are provided. Labels with a publication
+// delay are staged after the rest are written. Returned error indicates
+// a failure to write the whole batch.
+func (s *Server) AddLabels(ctx context.Context, labels []comatproto.LabelDefs_Label, opts ...WriteOption) ([]LabelResult, error) {
+	o := applyWriteOptions(opts)
+	results := make([]LabelResult, len(labels))
+	entries := []*Entry{}
+	idxs := []int{}
+	delayed := []*Entry{}
+	delayedIdxs := []int{}
+	for i, label := range labels {
+		entry, err := s.prepareLabel(label)
+		if err != nil {
+			results[i].Err = err
+			continue
+		}
+		if o.requireApproval && s.RequiresApproval(label.Val) {
+			results[i].Err = s.requestApproval(ctx, label, o)
+			continue
+		}
+		entry.Actor = o.actor
+		if s.shouldDelay(entry, o) {
+			delayed = append(delayed, entry)
+			delayedIdxs = append(delayedIdxs, i)
+			continue
+		}
+		entries = append(entries, entry)
+		idxs = append(idxs, i)
+	}
+	if len(entries) > 0 {
+		if err := s.writeBatch(ctx, entries, idxs, results, o); err != nil {
+			return nil, err
+		}
+	}
+	if len(delayed) > 0 {
+		staged, ids, errs, err := s.stageLabels(ctx, delayed, o)
+		if err != nil {
+			return nil, err
+		}
+		for i, idx := range delayedIdxs {
+			results[idx].Changed = staged[i]
+			results[idx].DelayedID = ids[i]
+			results[idx].Err = errs[i]
+		}
+	}
+	return results, nil
+}
+
+// writeBatch implements AddLabels for labels that are written immediately.
+func (s *Server) writeBatch(ctx context.Context, entries []*Entry, idxs []int, results []LabelResult, o writeOptions) error {
+	start := time.Now()
+	updated, errs, err := s.writeLabels(ctx, entries, cancelNegatedLabels(entries, o))
+	duration := time.Since(start)
+	if err == nil {
+		for i, e := range errs {
+			results[idxs[i]].Err = e
+		}
+	}
+	if o.dryRun {
+		if err != nil {
+			return err
+		}
+		for i, u := range updated {
+			results[idxs[i]].Changed = u
+		}
+		return nil
+	}
+	if err != nil {
+		writeLatency.WithLabelValues(s.did, "error").Observe(duration.Seconds())
+		return err
+	}
+	changed := false
+	for i, u := range updated {
+		results[idxs[i]].Changed = u
+		if u {
+			results[idxs[i]].Seq = entries[i].Seq
+			changed = true
+		}
+	}
+	if changed {
+		writeLatency.WithLabelValues(s.did, "written").Observe(duration.Seconds())
+		go s.wakeUpSubs()
+	} else {
+		writeLatency.WithLabelValues(s.did, "noop").Observe(duration.Seconds())
+	}
+	return nil
+}
+
+// ValidateLabel returns an error if the label would be rejected by AddLabel.
+func (s *Server) ValidateLabel(label comatproto.LabelDefs_Label) error {
+	_, err := s.prepareLabel(label)
+	return err
+}
+
+// prepareLabel checks if the label can be written and fills in the missing fields.
+func (s *Server) prepareLabel(label comatproto.LabelDefs_Label) (*Entry, error) {
+	if err := s.checkLabelSyntax(label); err != nil {
+		return nil, err
+	}
+
 	s.mu.Lock()
 	if len(s.allowedLabels) > 0 && !s.allowedLabels[label.Val] {
 		s.mu.Unlock()
-		return false, fmt.Errorf("we are not allowed to apply the label %q", label.Val)
+		return nil, fmt.Errorf("we are not allowed to apply the label %q", label.Val)
 	}
 	s.mu.Unlock()
 
+	if label.Neg == nil || !*label.Neg {
+		if err := s.checkSubject(label); err != nil {
+			return nil, err
+		}
+	}
+
 	if label.Src == "" {
 		label.Src = s.did
 	}
 	if label.Src == "" {
-		return false, fmt.Errorf("missing `src`")
+		return nil, fmt.Errorf("missing `src`")
 	}
 	if label.Ver == nil {
 		var n int64 = 1
@@ -344,22 +571,17 @@ func (s *Server) AddLabel(ctx context.Context, label comatproto.LabelDefs_Label)
 	label.Cts = time.Now().Format(time.RFC3339)
 	label.Sig = nil // We don't store signatures and always generate them on demand.
 
-	start := time.Now()
-	r, err := s.writeLabel(ctx, *(&Entry{}).FromLabel(0, label))
-	duration := time.Since(start)
-	if err != nil {
-		writeLatency.WithLabelValues(s.did, "error").Observe(duration.Seconds())
-		return false, err
-	}
-	if r {
-		writeLatency.WithLabelValues(s.did, "written").Observe(duration.Seconds())
-	} else {
-		writeLatency.WithLabelValues(s.did, "noop").Observe(duration.Seconds())
-	}
-	if r {
-		go s.wakeUpSubs()
+	if policy := s.expirationFor(label.Val); label.Exp != nil && *label.Exp != "" && policy.Max > 0 && !(label.Neg != nil && *label.Neg) {
+		exp, err := syntax.ParseDatetimeTime(*label.Exp)
+		if err != nil {
+			return nil, fmt.Errorf("invalid `exp`: %w", err)
+		}
+		if limit := time.Now().Add(policy.Max); exp.After(limit) {
+			label.Exp = ptr(limit.UTC().Format(time.RFC3339))
+		}
 	}
-	return r, nil
+
+	return (&Entry{}).FromLabel(0, label), nil
 }
 
 func (s *Server) wakeUpSubs() {
@@ -407,6 +629,33 @@ func (s *Server) SetAllowedLabels(labels []string) {
 	s.mu.Unlock()
 }
 
+// SetExpiration sets expiration policy for new labels, see [config.Expiration].
+// Keys are label values, with "*" matching all values that aren't listed.
+func (s *Server) SetExpiration(policy map[string]config.Expiration) {
+	s.mu.Lock()
+	s.expiration = maps.Clone(policy)
+	s.mu.Unlock()
+}
+
+func (s *Server) expirationFor(val string) config.Expiration {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if e, ok := s.expiration[val]; ok {
+		return e
+	}
+	return s.expiration["*"]
+}
+
+// AllowedLabels returns the list of label values set by SetAllowedLabels.
+// Empty list means that all labels are allowed.
+func (s *Server) AllowedLabels() []string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	r := maps.Keys(s.allowedLabels)
+	slices.Sort(r)
+	return r
+}
+
 // IsEmpty returns true if there are no labels in the database.
 func (s *Server) IsEmpty() (bool, error) {
 	count := int64(0)
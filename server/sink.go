@@ -0,0 +1,228 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/rs/zerolog"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Sink receives label entries after they are written to the database.
+type Sink interface {
+	// Name identifies the sink in logs and metrics.
+	Name() string
+	// Publish is called with the entries written by a single transaction, after
+	// it is committed. Entries are ordered by sequence number, but calls for
+	// concurrent transactions may happen in any order. It is called synchronously,
+	// so it shouldn't block for long.
+	Publish(ctx context.Context, entries []Entry) error
+}
+
+// SinkCursor stores the sequence number of the last entry passed to a sink,
+// so that entries that were written but not passed to it (e.g., because of
+// a crash) can be sent after a restart.
+type SinkCursor struct {
+	Name string `gorm:"primaryKey"`
+	Seq  int64  `gorm:"not null;default:0"`
+}
+
+type registeredSink struct {
+	Sink
+
+	mu sync.Mutex
+	// cursor is the highest sequence number such that all entries up to it
+	// were successfully passed to the sink.
+	cursor int64
+	// sent tracks entries above the cursor that were passed to the sink, and
+	// whether it succeeded. Entries can be sent out of order by concurrent
+	// transactions, so the cursor is advanced only over a contiguous range of
+	// delivered ones.
+	sent map[int64]bool
+	// stalled is set after a failed Publish call. The cursor is not advanced
+	// anymore, so the missing entries are sent again after a restart.
+	stalled bool
+}
+
+// sinkBackfillBatchSize limits how many entries are passed to Publish at once
+// when catching up after a restart.
+const sinkBackfillBatchSize = 100
+
+// AddSink registers a sink to be notified about all the new entries.
+//
+// If the sink was registered before (with the same name), it's first sent all the
+// entries written after the last one it has received. A new sink only receives
+// entries written after it was added. The sink should deduplicate entries by
+// sequence number, since some of them can be sent twice after a crash.
+func (s *Server) AddSink(ctx context.Context, sink Sink) error {
+	rs := &registeredSink{Sink: sink, sent: map[int64]bool{}}
+
+	// Register the sink before looking at the existing entries, so that the ones
+	// written concurrently are either found by the query or passed to publish.
+	// Publishing to this sink waits until the cursor is loaded.
+	rs.mu.Lock()
+	s.mu.Lock()
+	s.sinks = append(s.sinks, rs)
+	s.mu.Unlock()
+
+	lastKey, cursor, err := s.loadSinkCursor(ctx, sink.Name())
+	if err != nil {
+		rs.stalled = true
+		rs.mu.Unlock()
+		return err
+	}
+	rs.cursor = cursor
+	rs.mu.Unlock()
+
+	if cursor < lastKey {
+		zerolog.Ctx(ctx).Info().Str("sink", sink.Name()).
+			Msgf("Sending %d missed entries to sink %q", lastKey-cursor, sink.Name())
+	}
+	for from := cursor; from < lastKey; {
+		var entries []Entry
+		err := s.db.WithContext(ctx).Model(&Entry{}).
+			Where("seq > ? and seq <= ?", from, lastKey).
+			Order("seq asc").
+			Limit(sinkBackfillBatchSize).
+			Find(&entries).Error
+		if err != nil {
+			return fmt.Errorf("querying entries for sink %q: %w", sink.Name(), err)
+		}
+		if len(entries) == 0 {
+			break
+		}
+		if err := s.publishTo(ctx, rs, entries); err != nil {
+			return fmt.Errorf("publishing missed entries to sink %q: %w", sink.Name(), err)
+		}
+		from = entries[len(entries)-1].Seq
+	}
+	return nil
+}
+
+// loadSinkCursor returns the last existing sequence number and the cursor of the
+// sink, creating it if needed.
+func (s *Server) loadSinkCursor(ctx context.Context, name string) (int64, int64, error) {
+	var lastKey int64
+	err := s.db.WithContext(ctx).Model(&Entry{}).Select("seq").Order("seq desc").Limit(1).Pluck("seq", &lastKey).Error
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		return 0, 0, fmt.Errorf("failed to query last existing key: %w", err)
+	}
+	cursor := &SinkCursor{Name: name, Seq: lastKey}
+	err = s.db.WithContext(ctx).Clauses(clause.OnConflict{DoNothing: true}).Create(cursor).Error
+	if err != nil {
+		return 0, 0, fmt.Errorf("creating cursor for sink %q: %w", name, err)
+	}
+	if err := s.db.WithContext(ctx).Where("name = ?", name).Take(cursor).Error; err != nil {
+		return 0, 0, fmt.Errorf("fetching cursor for sink %q: %w", name, err)
+	}
+	return lastKey, cursor.Seq, nil
+}
+
+// publishTo passes the entries to the sink, skipping the ones it has already
+// received, and advances its cursor.
+func (s *Server) publishTo(ctx context.Context, rs *registeredSink, entries []Entry) error {
+	rs.mu.Lock()
+	toSend := []Entry{}
+	for _, e := range entries {
+		if e.Seq <= rs.cursor {
+			continue
+		}
+		if _, ok := rs.sent[e.Seq]; ok {
+			continue
+		}
+		toSend = append(toSend, e)
+		if !rs.stalled {
+			rs.sent[e.Seq] = false
+		}
+	}
+	rs.mu.Unlock()
+	if len(toSend) == 0 {
+		return nil
+	}
+
+	err := rs.Publish(ctx, toSend)
+
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	if err != nil {
+		sinkPublished.WithLabelValues(s.did, rs.Name(), "error").Add(float64(len(toSend)))
+		rs.stalled = true
+		clear(rs.sent)
+		return err
+	}
+	sinkPublished.WithLabelValues(s.did, rs.Name(), "ok").Add(float64(len(toSend)))
+	if rs.stalled {
+		return nil
+	}
+	for _, e := range toSend {
+		rs.sent[e.Seq] = true
+	}
+	return s.advanceSinkCursor(context.WithoutCancel(ctx), rs)
+}
+
+// advanceSinkCursor moves the cursor of the sink over the entries that were
+// delivered, up to the first one that wasn't. Must be called with rs.mu held.
+func (s *Server) advanceSinkCursor(ctx context.Context, rs *registeredSink) error {
+	maxSent := int64(0)
+	for seq, ok := range rs.sent {
+		if ok && seq > maxSent {
+			maxSent = seq
+		}
+	}
+	if maxSent <= rs.cursor {
+		return nil
+	}
+
+	// Sequence numbers can have gaps, so check what entries actually exist.
+	var seqs []int64
+	err := s.db.WithContext(ctx).Model(&Entry{}).
+		Where("seq > ? and seq <= ?", rs.cursor, maxSent).
+		Order("seq asc").
+		Pluck("seq", &seqs).Error
+	if err != nil {
+		return fmt.Errorf("querying entries for sink %q: %w", rs.Name(), err)
+	}
+	cursor := rs.cursor
+	for _, seq := range seqs {
+		if !rs.sent[seq] {
+			break
+		}
+		cursor = seq
+	}
+	if cursor == rs.cursor {
+		return nil
+	}
+
+	err = s.db.WithContext(ctx).Model(&SinkCursor{}).
+		Where("name = ? and seq < ?", rs.Name(), cursor).
+		Update("seq", cursor).Error
+	if err != nil {
+		return fmt.Errorf("updating cursor for sink %q: %w", rs.Name(), err)
+	}
+	rs.cursor = cursor
+	for seq := range rs.sent {
+		if seq <= cursor {
+			delete(rs.sent, seq)
+		}
+	}
+	return nil
+}
+
+func (s *Server) publish(ctx context.Context, entries []Entry) {
+	if len(entries) == 0 {
+		return
+	}
+	s.mu.RLock()
+	sinks := s.sinks
+	s.mu.RUnlock()
+
+	for _, sink := range sinks {
+		if err := s.publishTo(ctx, sink, entries); err != nil {
+			zerolog.Ctx(ctx).Error().Err(err).Str("sink", sink.Name()).
+				Msgf("Failed to publish %d entries to sink %q, they will be sent again after a restart: %s", len(entries), sink.Name(), err)
+		}
+	}
+}
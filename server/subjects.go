@@ -0,0 +1,64 @@
+package server
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/bluesky-social/indigo/atproto/syntax"
+	"golang.org/x/exp/maps"
+
+	comatproto "github.com/bluesky-social/indigo/api/atproto"
+
+	"bsky.watch/labeler/config"
+)
+
+// SetSubjectRules sets restrictions on what subjects label values can be applied to,
+// see [config.SubjectRule]. Keys are label values, with "*" matching all values
+// that aren't listed.
+func (s *Server) SetSubjectRules(rules map[string]config.SubjectRule) {
+	s.mu.Lock()
+	s.subjectRules = maps.Clone(rules)
+	s.mu.Unlock()
+}
+
+func (s *Server) subjectRuleFor(val string) (config.SubjectRule, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if r, ok := s.subjectRules[val]; ok {
+		return r, true
+	}
+	r, ok := s.subjectRules["*"]
+	return r, ok
+}
+
+// checkSubject returns an error if the label is not allowed on its subject.
+func (s *Server) checkSubject(label comatproto.LabelDefs_Label) error {
+	rule, ok := s.subjectRuleFor(label.Val)
+	if !ok {
+		return nil
+	}
+
+	if strings.HasPrefix(label.Uri, "did:") {
+		if len(rule.Types) > 0 && !slices.Contains(rule.Types, "account") {
+			return fmt.Errorf("label %q can't be applied to accounts, only to records", label.Val)
+		}
+		return nil
+	}
+
+	uri, err := syntax.ParseATURI(label.Uri)
+	if err != nil || uri.Collection() == "" || uri.RecordKey() == "" {
+		return fmt.Errorf("subject %q is neither a DID nor an AT URI of a record", label.Uri)
+	}
+	if len(rule.Types) > 0 && !slices.Contains(rule.Types, "record") {
+		return fmt.Errorf("label %q can't be applied to records, only to accounts", label.Val)
+	}
+	if len(rule.Collections) > 0 && !slices.Contains(rule.Collections, uri.Collection().String()) {
+		return fmt.Errorf("label %q can't be applied to records in %q, only in %s",
+			label.Val, uri.Collection(), strings.Join(rule.Collections, ", "))
+	}
+	if rule.RequireCID && (label.Cid == nil || *label.Cid == "") {
+		return fmt.Errorf("label %q requires `cid` to be set for records", label.Val)
+	}
+	return nil
+}
@@ -0,0 +1,93 @@
+package server
+
+import (
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/bluesky-social/indigo/atproto/syntax"
+	"github.com/ipfs/go-cid"
+
+	comatproto "github.com/bluesky-social/indigo/api/atproto"
+)
+
+// DefaultSystemLabels are `!`-prefixed label values allowed if SetSystemLabels
+// wasn't called.
+var DefaultSystemLabels = []string{"!hide", "!warn", "!no-unauthenticated"}
+
+const maxLabelValueLength = 128
+
+var labelValueRegexp = regexp.MustCompile(`^!?[a-z-]+$`)
+
+// SetSystemLabels limits what `!`-prefixed label values can be applied.
+// If vals is nil, DefaultSystemLabels are used. Negations are always allowed.
+func (s *Server) SetSystemLabels(vals []string) {
+	if vals == nil {
+		vals = DefaultSystemLabels
+	}
+	s.mu.Lock()
+	s.systemLabels = map[string]bool{}
+	for _, v := range vals {
+		s.systemLabels[v] = true
+	}
+	s.mu.Unlock()
+}
+
+// checkLabelSyntax validates the label fields according to the lexicon.
+// Negations are only checked for `src`, since entries written before these
+// checks were added can be invalid, and it must be possible to negate them.
+func (s *Server) checkLabelSyntax(label comatproto.LabelDefs_Label) error {
+	if label.Src != "" {
+		if _, err := syntax.ParseDID(label.Src); err != nil {
+			return fmt.Errorf("invalid `src`: %w", err)
+		}
+	}
+	if label.Neg != nil && *label.Neg {
+		if label.Val == "" || label.Uri == "" {
+			return fmt.Errorf("`val` and `uri` must not be empty")
+		}
+		return nil
+	}
+
+	if len(label.Val) > maxLabelValueLength {
+		return fmt.Errorf("label value is longer than %d bytes", maxLabelValueLength)
+	}
+	if !labelValueRegexp.MatchString(label.Val) {
+		return fmt.Errorf("label value %q must consist of lowercase ASCII letters and '-', optionally prefixed with '!'", label.Val)
+	}
+	if strings.HasPrefix(label.Val, "!") {
+		s.mu.RLock()
+		allowed := s.systemLabels[label.Val]
+		if s.systemLabels == nil {
+			allowed = slices.Contains(DefaultSystemLabels, label.Val)
+		}
+		s.mu.RUnlock()
+		if !allowed {
+			return fmt.Errorf("system label %q is not allowed", label.Val)
+		}
+	}
+
+	if strings.HasPrefix(label.Uri, "did:") {
+		if _, err := syntax.ParseDID(label.Uri); err != nil {
+			return fmt.Errorf("invalid `uri`: %w", err)
+		}
+	} else if _, err := syntax.ParseATURI(label.Uri); err != nil {
+		return fmt.Errorf("`uri` must be a DID or an AT URI: %w", err)
+	}
+	if label.Cid != nil && *label.Cid != "" {
+		c, err := cid.Decode(*label.Cid)
+		if err != nil {
+			return fmt.Errorf("invalid `cid`: %w", err)
+		}
+		if c.Version() == 0 {
+			return fmt.Errorf("invalid `cid`: CIDv0 is not allowed")
+		}
+	}
+	if label.Exp != nil && *label.Exp != "" {
+		if _, err := syntax.ParseDatetime(*label.Exp); err != nil {
+			return fmt.Errorf("invalid `exp`: %w", err)
+		}
+	}
+	return nil
+}
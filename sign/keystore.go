@@ -0,0 +1,141 @@
+package sign
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+
+	"gitlab.com/yawning/secp256k1-voi/secec"
+	"golang.org/x/crypto/scrypt"
+)
+
+// keystore is a passphrase-protected private key, suitable for storing on disk.
+//
+// The key is encrypted with AES-256-GCM, using an encryption key derived from
+// the passphrase with scrypt.
+type keystore struct {
+	Version    int          `json:"version"`
+	PublicKey  string       `json:"public_key"`
+	KDF        string       `json:"kdf"`
+	KDFParams  scryptParams `json:"kdfparams"`
+	Cipher     string       `json:"cipher"`
+	Nonce      string       `json:"nonce"`
+	Ciphertext string       `json:"ciphertext"`
+}
+
+type scryptParams struct {
+	N    int    `json:"n"`
+	R    int    `json:"r"`
+	P    int    `json:"p"`
+	Salt string `json:"salt"`
+}
+
+const keystoreVersion = 1
+
+// EncryptKeystore encrypts the private key with the given passphrase
+// and returns JSON-encoded keystore.
+func EncryptKeystore(key *secec.PrivateKey, passphrase string) ([]byte, error) {
+	if passphrase == "" {
+		return nil, fmt.Errorf("passphrase must not be empty")
+	}
+	publicKey, err := GetPublicKey(key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get the public key: %w", err)
+	}
+
+	ks := &keystore{
+		Version:   keystoreVersion,
+		PublicKey: "did:key:" + publicKey,
+		KDF:       "scrypt",
+		KDFParams: scryptParams{N: 1 << 15, R: 8, P: 1},
+		Cipher:    "aes-256-gcm",
+	}
+
+	salt := make([]byte, 32)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, err
+	}
+	ks.KDFParams.Salt = hex.EncodeToString(salt)
+
+	aead, err := ks.aead(passphrase)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	ks.Nonce = hex.EncodeToString(nonce)
+	ks.Ciphertext = hex.EncodeToString(aead.Seal(nil, nonce, key.Bytes(), []byte(ks.PublicKey)))
+
+	return json.MarshalIndent(ks, "", "  ")
+}
+
+// DecryptKeystore decrypts a JSON-encoded keystore created by [EncryptKeystore].
+func DecryptKeystore(data []byte, passphrase string) (*secec.PrivateKey, error) {
+	ks := &keystore{}
+	if err := json.Unmarshal(data, ks); err != nil {
+		return nil, fmt.Errorf("parsing keystore: %w", err)
+	}
+	if ks.Version != keystoreVersion {
+		return nil, fmt.Errorf("unsupported keystore version %d", ks.Version)
+	}
+	if ks.KDF != "scrypt" {
+		return nil, fmt.Errorf("unsupported key derivation function %q", ks.KDF)
+	}
+	if ks.Cipher != "aes-256-gcm" {
+		return nil, fmt.Errorf("unsupported cipher %q", ks.Cipher)
+	}
+
+	aead, err := ks.aead(passphrase)
+	if err != nil {
+		return nil, err
+	}
+	nonce, err := hex.DecodeString(ks.Nonce)
+	if err != nil {
+		return nil, fmt.Errorf("decoding nonce: %w", err)
+	}
+	if len(nonce) != aead.NonceSize() {
+		return nil, fmt.Errorf("invalid nonce length")
+	}
+	ciphertext, err := hex.DecodeString(ks.Ciphertext)
+	if err != nil {
+		return nil, fmt.Errorf("decoding ciphertext: %w", err)
+	}
+	b, err := aead.Open(nil, nonce, ciphertext, []byte(ks.PublicKey))
+	if err != nil {
+		return nil, fmt.Errorf("decryption failed, wrong passphrase?")
+	}
+	key, err := secec.NewPrivateKey(b)
+	if err != nil {
+		return nil, err
+	}
+
+	publicKey, err := GetPublicKey(key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get the public key: %w", err)
+	}
+	if "did:key:"+publicKey != ks.PublicKey {
+		return nil, fmt.Errorf("decrypted key doesn't match the public key stored in the keystore")
+	}
+	return key, nil
+}
+
+func (ks *keystore) aead(passphrase string) (cipher.AEAD, error) {
+	salt, err := hex.DecodeString(ks.KDFParams.Salt)
+	if err != nil {
+		return nil, fmt.Errorf("decoding salt: %w", err)
+	}
+	dk, err := scrypt.Key([]byte(passphrase), salt, ks.KDFParams.N, ks.KDFParams.R, ks.KDFParams.P, 32)
+	if err != nil {
+		return nil, fmt.Errorf("deriving encryption key: %w", err)
+	}
+	block, err := aes.NewCipher(dk)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
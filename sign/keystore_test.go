@@ -0,0 +1,86 @@
+package sign
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"testing"
+)
+
+func TestKeystore(t *testing.T) {
+	key, err := GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := EncryptKeystore(key, "correct horse")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(data, []byte(hex.EncodeToString(key.Bytes()))) {
+		t.Fatalf("keystore contains the private key in plain text")
+	}
+
+	got, err := DecryptKeystore(data, "correct horse")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got.Bytes(), key.Bytes()) {
+		t.Errorf("decrypted key doesn't match the original")
+	}
+
+	otherKey, err := GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherPublicKey, err := GetPublicKey(otherKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	modified := func(modify func(ks map[string]any)) []byte {
+		t.Helper()
+		ks := map[string]any{}
+		if err := json.Unmarshal(data, &ks); err != nil {
+			t.Fatal(err)
+		}
+		modify(ks)
+		b, err := json.Marshal(ks)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return b
+	}
+
+	tests := []struct {
+		name       string
+		data       []byte
+		passphrase string
+	}{
+		{"wrong passphrase", data, "wrong"},
+		{"empty passphrase", data, ""},
+		{"not JSON", []byte("correct horse"), "correct horse"},
+		{"unsupported version", modified(func(ks map[string]any) { ks["version"] = 2 }), "correct horse"},
+		{"unsupported cipher", modified(func(ks map[string]any) { ks["cipher"] = "aes-128-cbc" }), "correct horse"},
+		{"replaced public key", modified(func(ks map[string]any) { ks["public_key"] = "did:key:" + otherPublicKey }), "correct horse"},
+		{"modified ciphertext", modified(func(ks map[string]any) {
+			c := []byte(ks["ciphertext"].(string))
+			if c[0] == '0' {
+				c[0] = '1'
+			} else {
+				c[0] = '0'
+			}
+			ks["ciphertext"] = string(c)
+		}), "correct horse"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if _, err := DecryptKeystore(test.data, test.passphrase); err == nil {
+				t.Errorf("expected decryption to fail")
+			}
+		})
+	}
+
+	if _, err := EncryptKeystore(key, ""); err == nil {
+		t.Errorf("expected an empty passphrase to be rejected")
+	}
+}
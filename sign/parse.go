@@ -3,19 +3,108 @@ package sign
 import (
 	"encoding/binary"
 	"encoding/hex"
+	"fmt"
+	"strings"
 
 	"github.com/multiformats/go-multibase"
 	"github.com/multiformats/go-multicodec"
 	"gitlab.com/yawning/secp256k1-voi/secec"
 )
 
-// ParsePrivateKey parses hex-encoded string into a private key.
+// KeyFormat is a textual encoding of a private key.
+type KeyFormat string
+
+const (
+	// FormatHex is a hex-encoded 32-byte scalar, as produced by the
+	// `openssl ... | xxd` pipeline and used by Ozone.
+	FormatHex KeyFormat = "hex"
+	// FormatMultibase is a base58btc multibase string with `secp256k1-priv`
+	// multicodec prefix.
+	FormatMultibase KeyFormat = "multibase"
+	// FormatPEM is a PEM-encoded SEC 1 EC private key, as produced by
+	// `openssl ecparam --name secp256k1 --genkey`.
+	FormatPEM KeyFormat = "pem"
+)
+
+// ParseKeyFormat converts a user-provided format name into KeyFormat.
+func ParseKeyFormat(s string) (KeyFormat, error) {
+	switch f := KeyFormat(strings.ToLower(s)); f {
+	case FormatHex, FormatMultibase, FormatPEM:
+		return f, nil
+	default:
+		return "", fmt.Errorf("unknown key format %q", s)
+	}
+}
+
+// DetectKeyFormat guesses the format of an encoded private key.
+func DetectKeyFormat(s string) KeyFormat {
+	s = strings.TrimSpace(s)
+	switch {
+	case strings.HasPrefix(s, "-----BEGIN"):
+		return FormatPEM
+	case strings.HasPrefix(s, "z"):
+		return FormatMultibase
+	default:
+		return FormatHex
+	}
+}
+
+// ParsePrivateKey parses a string into a private key. The string can be
+// in any of the supported formats, see [KeyFormat].
 func ParsePrivateKey(s string) (*secec.PrivateKey, error) {
-	b, err := hex.DecodeString(s)
+	s = strings.TrimSpace(s)
+	switch DetectKeyFormat(s) {
+	case FormatPEM:
+		return parsePEMPrivateKey([]byte(s))
+	case FormatMultibase:
+		return parseMultibasePrivateKey(s)
+	default:
+		b, err := hex.DecodeString(s)
+		if err != nil {
+			return nil, err
+		}
+		return secec.NewPrivateKey(b)
+	}
+}
+
+// GenerateKey creates a new random private key.
+func GenerateKey() (*secec.PrivateKey, error) {
+	return secec.GenerateKey()
+}
+
+// EncodePrivateKey returns a string representation of the private key in the given format.
+func EncodePrivateKey(key *secec.PrivateKey, format KeyFormat) (string, error) {
+	switch format {
+	case FormatHex:
+		return hex.EncodeToString(key.Bytes()), nil
+	case FormatMultibase:
+		b := binary.AppendUvarint(nil, uint64(multicodec.Secp256k1Priv))
+		b = append(b, key.Bytes()...)
+		return multibase.Encode(multibase.Base58BTC, b)
+	case FormatPEM:
+		b, err := encodePEMPrivateKey(key)
+		if err != nil {
+			return "", err
+		}
+		return string(b), nil
+	default:
+		return "", fmt.Errorf("unknown key format %q", format)
+	}
+}
+
+func parseMultibasePrivateKey(s string) (*secec.PrivateKey, error) {
+	_, b, err := multibase.Decode(s)
 	if err != nil {
-		return nil, err
+		return nil, fmt.Errorf("decoding multibase: %w", err)
+	}
+	codec, n := binary.Uvarint(b)
+	if n <= 0 {
+		return nil, fmt.Errorf("invalid multicodec prefix")
+	}
+	if multicodec.Code(codec) != multicodec.Secp256k1Priv {
+		return nil, fmt.Errorf("unsupported key type %s, only secp256k1 keys are supported", multicodec.Code(codec))
 	}
-	return secec.NewPrivateKey(b)
+	return secec.NewPrivateKey(b[n:])
 }
 
 // GetPublicKey returns a string representation of the public key
@@ -0,0 +1,99 @@
+package sign
+
+import (
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/pem"
+	"fmt"
+
+	"gitlab.com/yawning/secp256k1-voi/secec"
+)
+
+// Go's crypto/x509 doesn't know about secp256k1, so we have to deal with
+// ASN.1 structures ourselves.
+
+var (
+	oidPublicKeyECDSA = asn1.ObjectIdentifier{1, 2, 840, 10045, 2, 1}
+	oidSecp256k1      = asn1.ObjectIdentifier{1, 3, 132, 0, 10}
+)
+
+// RFC 5915
+type ecPrivateKey struct {
+	Version       int
+	PrivateKey    []byte
+	NamedCurveOID asn1.ObjectIdentifier `asn1:"optional,explicit,tag:0"`
+	PublicKey     asn1.BitString        `asn1:"optional,explicit,tag:1"`
+}
+
+// RFC 5208
+type pkcs8 struct {
+	Version    int
+	Algo       pkix.AlgorithmIdentifier
+	PrivateKey []byte
+}
+
+func parsePEMPrivateKey(data []byte) (*secec.PrivateKey, error) {
+	for {
+		block, rest := pem.Decode(data)
+		if block == nil {
+			return nil, fmt.Errorf("no private key found in PEM data")
+		}
+		data = rest
+
+		switch block.Type {
+		case "EC PRIVATE KEY":
+			return parseSEC1PrivateKey(block.Bytes)
+		case "PRIVATE KEY":
+			var k pkcs8
+			if _, err := asn1.Unmarshal(block.Bytes, &k); err != nil {
+				return nil, fmt.Errorf("parsing PKCS #8 private key: %w", err)
+			}
+			if !k.Algo.Algorithm.Equal(oidPublicKeyECDSA) {
+				return nil, fmt.Errorf("not an EC private key")
+			}
+			var curve asn1.ObjectIdentifier
+			if _, err := asn1.Unmarshal(k.Algo.Parameters.FullBytes, &curve); err != nil {
+				return nil, fmt.Errorf("parsing curve identifier: %w", err)
+			}
+			if !curve.Equal(oidSecp256k1) {
+				return nil, fmt.Errorf("unsupported curve %s, only secp256k1 keys are supported", curve)
+			}
+			return parseSEC1PrivateKey(k.PrivateKey)
+		}
+		// Skip everything else, e.g. "EC PARAMETERS" block emitted by openssl.
+	}
+}
+
+func parseSEC1PrivateKey(der []byte) (*secec.PrivateKey, error) {
+	var k ecPrivateKey
+	if _, err := asn1.Unmarshal(der, &k); err != nil {
+		return nil, fmt.Errorf("parsing EC private key: %w", err)
+	}
+	if k.Version != 1 {
+		return nil, fmt.Errorf("unsupported EC private key version %d", k.Version)
+	}
+	if len(k.NamedCurveOID) > 0 && !k.NamedCurveOID.Equal(oidSecp256k1) {
+		return nil, fmt.Errorf("unsupported curve %s, only secp256k1 keys are supported", k.NamedCurveOID)
+	}
+	if len(k.PrivateKey) > 32 {
+		return nil, fmt.Errorf("private key is too long")
+	}
+	// Some encoders strip leading zeroes.
+	b := make([]byte, 32)
+	copy(b[32-len(k.PrivateKey):], k.PrivateKey)
+	return secec.NewPrivateKey(b)
+}
+
+func encodePEMPrivateKey(key *secec.PrivateKey) ([]byte, error) {
+	pub := key.PublicKey().Bytes()
+	der, err := asn1.Marshal(ecPrivateKey{
+		Version:       1,
+		PrivateKey:    key.Bytes(),
+		NamedCurveOID: oidSecp256k1,
+		PublicKey:     asn1.BitString{Bytes: pub, BitLength: len(pub) * 8},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("marshaling EC private key: %w", err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der}), nil
+}
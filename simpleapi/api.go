@@ -1,12 +1,24 @@
 // Package simpleapi implements a very bare-bones API for mutating labeler's state.
 //
 // Handler accepts a POST request, with a partially populated label
-// as JSON in the request body. It doesn't provide any authentication
-// whatsoever, so make sure you're limiting who can access it.
+// as JSON in the request body. It doesn't do authentication by itself,
+// wrap it with [auth.Middleware] or make sure you're limiting who can access it
+// in some other way. If there is an authenticated principal, it must be
+// allowed to use the label value.
+//
+// Adding `?dry_run=true` to the URL makes handlers report what would've
+// changed without writing anything.
+//
+// Labels that require approval (see [server.RequireApproval]) are not written,
+// instead an approval request is created and Handler responds with 202 Accepted.
+// Same happens for labels with a publication delay (see [server.Server.SetPublicationDelay]),
+// which are staged to be written later.
 package simpleapi
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"net/http"
 
 	"github.com/Jille/convreq"
@@ -14,6 +26,7 @@ import (
 
 	comatproto "github.com/bluesky-social/indigo/api/atproto"
 
+	"bsky.watch/labeler/auth"
 	"bsky.watch/labeler/server"
 )
 
@@ -31,11 +44,57 @@ func New(server *server.Server) *Handler {
 
 type label_JSON comatproto.LabelDefs_Label
 
-func (h *Handler) serve(ctx context.Context, post label_JSON) convreq.HttpResponse {
-	changed, err := h.server.AddLabel(ctx, comatproto.LabelDefs_Label(post))
+type optionsGet struct {
+	DryRun bool `schema:"dry_run"`
+}
+
+func (o optionsGet) writeOptions(ctx context.Context) []server.WriteOption {
+	r := []server.WriteOption{server.WithActor(auth.Name(ctx)), server.RequireApproval()}
+	if o.DryRun {
+		r = append(r, server.DryRun())
+	}
+	return r
+}
+
+func (h *Handler) serve(ctx context.Context, get optionsGet, post label_JSON) convreq.HttpResponse {
+	if err := auth.CheckLabel(ctx, post.Val); err != nil {
+		if get.DryRun {
+			return respond.Forbidden("rejected: " + err.Error())
+		}
+		return respond.Forbidden(err.Error())
+	}
+	results, err := h.server.AddLabels(ctx, []comatproto.LabelDefs_Label{comatproto.LabelDefs_Label(post)}, get.writeOptions(ctx)...)
+	if err == nil {
+		err = results[0].Err
+	}
+	if pending := (*server.PendingApprovalError)(nil); errors.As(err, &pending) {
+		if get.DryRun {
+			return respond.String("would require approval")
+		}
+		return respond.Accepted(fmt.Sprintf("pending approval: request %d", pending.ID))
+	}
+	if errors.Is(err, server.ErrForbiddenNegation) {
+		if get.DryRun {
+			return respond.Forbidden("rejected: " + err.Error())
+		}
+		return respond.Forbidden(err.Error())
+	}
 	if err != nil {
+		if get.DryRun {
+			return respond.BadRequest("rejected: " + err.Error())
+		}
 		return respond.BadRequest(err.Error())
 	}
+	changed := results[0].Changed
+	if id := results[0].DelayedID; id != 0 {
+		return respond.Accepted(fmt.Sprintf("delayed: pending label %d", id))
+	}
+	if get.DryRun {
+		if changed {
+			return respond.String("would create")
+		}
+		return respond.String("no-op")
+	}
 	if changed {
 		return respond.Created("OK")
 	}
@@ -0,0 +1,199 @@
+package simpleapi
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/rs/zerolog"
+
+	comatproto "github.com/bluesky-social/indigo/api/atproto"
+
+	"bsky.watch/labeler/auth"
+	"bsky.watch/labeler/server"
+)
+
+const (
+	bulkBatchSize   = 500
+	bulkMaxLineSize = 64 * 1024
+	// bulkMaxDryRunLines limits the number of non-empty lines in a dry run,
+	// since they are all checked in a single transaction.
+	bulkMaxDryRunLines = 4 * bulkBatchSize
+)
+
+// BulkHandler accepts a POST request with newline-delimited JSON labels in the body,
+// in the same format as Handler. Labels are written in batches, and for each non-empty
+// input line the response contains a JSON object with the line number and the result:
+//
+//	{"line": 1, "status": "created", "seq": 123}
+//	{"line": 2, "status": "noop"}
+//	{"line": 3, "status": "error", "error": "..."}
+//	{"line": 4, "status": "pending_approval", "approval_id": 12}
+//	{"line": 5, "status": "delayed", "delayed_id": 34}
+//
+// Invalid lines don't prevent processing of the rest of the input, but if a batch
+// fails to be written, all lines in it are reported as errors and processing stops.
+// Lines are reported in the same order as they were received, so the client can
+// resume from the first failed line.
+//
+// In dry-run mode "created" is replaced with "would_create" and nothing is written.
+// All labels are checked as a single batch, so that later lines see the effect of
+// the earlier ones, and results are sent only after the whole input is read.
+// Dry runs are limited to bulkMaxDryRunLines non-empty lines, longer input is
+// rejected with 413 status code.
+type BulkHandler struct {
+	server *server.Server
+}
+
+// NewBulk returns HTTP handler to serve bulk requests.
+func NewBulk(server *server.Server) *BulkHandler {
+	return &BulkHandler{server: server}
+}
+
+type bulkResult struct {
+	Line       int    `json:"line"`
+	Status     string `json:"status"`
+	Seq        int64  `json:"seq,omitempty"`
+	ApprovalID int64  `json:"approval_id,omitempty"`
+	DelayedID  int64  `json:"delayed_id,omitempty"`
+	Error      string `json:"error,omitempty"`
+}
+
+type bulkItem struct {
+	line  int
+	label comatproto.LabelDefs_Label
+}
+
+func (h *BulkHandler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	ctx := req.Context()
+	log := zerolog.Ctx(ctx)
+
+	if req.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var options optionsGet
+	options.DryRun, _ = strconv.ParseBool(req.URL.Query().Get("dry_run"))
+
+	// We're going to write results while still reading the request body.
+	rc := http.NewResponseController(w)
+	_ = rc.EnableFullDuplex()
+
+	// In dry-run mode nothing is sent until the whole input is read, so
+	// that we can still respond with an error if it's too long.
+	started := false
+	start := func() {
+		if !started {
+			w.Header().Set("Content-Type", "application/x-ndjson")
+			w.WriteHeader(http.StatusOK)
+			started = true
+		}
+	}
+	if !options.DryRun {
+		start()
+	}
+	enc := json.NewEncoder(w)
+
+	var pending []bulkResult
+	var batch []bulkItem
+	failed := false
+
+	flush := func() {
+		if len(batch) > 0 {
+			labels := make([]comatproto.LabelDefs_Label, len(batch))
+			for i, item := range batch {
+				labels[i] = item.label
+			}
+			results, err := h.server.AddLabels(ctx, labels, options.writeOptions(ctx)...)
+			for i, item := range batch {
+				r := bulkResult{Line: item.line}
+				approval := (*server.PendingApprovalError)(nil)
+				switch {
+				case err != nil:
+					r.Status = "error"
+					r.Error = err.Error()
+				case errors.As(results[i].Err, &approval):
+					r.Status = "pending_approval"
+					r.ApprovalID = approval.ID
+				case results[i].Err != nil:
+					r.Status = "error"
+					r.Error = results[i].Err.Error()
+				case results[i].DelayedID != 0:
+					r.Status = "delayed"
+					r.DelayedID = results[i].DelayedID
+				case results[i].Changed && options.DryRun:
+					r.Status = "would_create"
+				case results[i].Changed:
+					r.Status = "created"
+					r.Seq = results[i].Seq
+				default:
+					r.Status = "noop"
+				}
+				pending = append(pending, r)
+			}
+			if err != nil {
+				log.Error().Err(err).Msgf("Failed to write a batch of labels: %s", err)
+				failed = true
+			}
+			batch = nil
+		}
+
+		// Sort by line number to keep the output in the input order: rejected
+		// lines are put into `pending` right away, while the rest - only after
+		// the batch is written.
+		slices.SortFunc(pending, func(a, b bulkResult) int { return a.Line - b.Line })
+		start()
+		for _, r := range pending {
+			if err := enc.Encode(r); err != nil {
+				failed = true
+				return
+			}
+		}
+		pending = nil
+		_ = rc.Flush()
+	}
+
+	scanner := bufio.NewScanner(req.Body)
+	scanner.Buffer(make([]byte, 0, 4096), bulkMaxLineSize)
+	line := 0
+	nonEmpty := 0
+	for !failed && scanner.Scan() {
+		line++
+		s := strings.TrimSpace(scanner.Text())
+		if s == "" {
+			continue
+		}
+		nonEmpty++
+		if options.DryRun && nonEmpty > bulkMaxDryRunLines {
+			http.Error(w, fmt.Sprintf("dry run is limited to %d labels, split the input into smaller parts", bulkMaxDryRunLines), http.StatusRequestEntityTooLarge)
+			return
+		}
+
+		var label comatproto.LabelDefs_Label
+		if err := json.Unmarshal([]byte(s), &label); err != nil {
+			pending = append(pending, bulkResult{Line: line, Status: "error", Error: fmt.Sprintf("parsing JSON: %s", err)})
+			continue
+		}
+		if err := auth.CheckLabel(ctx, label.Val); err != nil {
+			pending = append(pending, bulkResult{Line: line, Status: "error", Error: err.Error()})
+			continue
+		}
+		batch = append(batch, bulkItem{line: line, label: label})
+		if len(batch) >= bulkBatchSize && !options.DryRun {
+			flush()
+		}
+	}
+	if !failed {
+		flush()
+	}
+	if err := scanner.Err(); err != nil && !failed {
+		start()
+		enc.Encode(bulkResult{Line: line + 1, Status: "error", Error: fmt.Sprintf("reading request: %s", err)})
+	}
+}
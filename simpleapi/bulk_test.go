@@ -0,0 +1,117 @@
+package simpleapi
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"bsky.watch/labeler/config"
+	"bsky.watch/labeler/server"
+)
+
+func newTestServer(t *testing.T) *server.Server {
+	t.Helper()
+	s, err := server.NewWithConfig(context.Background(), &config.Config{
+		SQLiteDB:   fmt.Sprintf("file:%s?mode=memory&cache=shared", t.Name()),
+		DID:        "did:plc:labeler",
+		PrivateKey: "c6d40ec53c689ca905036e41d8c73560777e5746d1d228fd6f9db56efed8ecaf",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func bulkRequest(t *testing.T, h http.Handler, query string, lines []string) (*httptest.ResponseRecorder, []bulkResult) {
+	t.Helper()
+	req := httptest.NewRequest("POST", "/label/bulk"+query, strings.NewReader(strings.Join(lines, "\n")))
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		return w, nil
+	}
+	var results []bulkResult
+	dec := json.NewDecoder(w.Body)
+	for dec.More() {
+		var r bulkResult
+		if err := dec.Decode(&r); err != nil {
+			t.Fatal(err)
+		}
+		results = append(results, r)
+	}
+	return w, results
+}
+
+func TestBulkDryRun(t *testing.T) {
+	s := newTestServer(t)
+	h := NewBulk(s)
+
+	// More than a single batch, with every label repeated further down in the input.
+	n := bulkBatchSize + 100
+	lines := []string{}
+	for i := 0; i < n; i++ {
+		lines = append(lines, fmt.Sprintf(`{"uri": "did:plc:user%d", "val": "spam"}`, i%(n/2)))
+	}
+
+	w, results := bulkRequest(t, h, "?dry_run=true", lines)
+	if w.Code != http.StatusOK {
+		t.Fatalf("unexpected status %d: %s", w.Code, w.Body)
+	}
+	if len(results) != n {
+		t.Fatalf("expected %d results, got %d", n, len(results))
+	}
+	for i, r := range results {
+		want := "would_create"
+		if i >= n/2 {
+			want = "noop"
+		}
+		if r.Line != i+1 || r.Status != want {
+			t.Errorf("line %d: expected %q, got %+v", i+1, want, r)
+		}
+	}
+	history, err := s.SubjectHistory(context.Background(), "did:plc:user0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(history) != 0 {
+		t.Errorf("dry run wrote %+v", history)
+	}
+
+	// Same input is written in multiple batches.
+	w, results = bulkRequest(t, h, "", lines)
+	if w.Code != http.StatusOK || len(results) != n {
+		t.Fatalf("unexpected response %d with %d results: %s", w.Code, len(results), w.Body)
+	}
+	for i, r := range results {
+		want := "created"
+		if i >= n/2 {
+			want = "noop"
+		}
+		if r.Status != want {
+			t.Errorf("line %d: expected %q, got %+v", i+1, want, r)
+		}
+	}
+}
+
+func TestBulkDryRunLimit(t *testing.T) {
+	h := NewBulk(newTestServer(t))
+
+	lines := []string{}
+	for i := 0; i <= bulkMaxDryRunLines; i++ {
+		lines = append(lines, fmt.Sprintf(`{"uri": "did:plc:user%d", "val": "spam"}`, i))
+	}
+	if w, _ := bulkRequest(t, h, "?dry_run=true", lines); w.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("expected status %d, got %d: %s", http.StatusRequestEntityTooLarge, w.Code, w.Body)
+	}
+
+	// Empty lines don't count towards the limit.
+	lines = append(lines[:bulkMaxDryRunLines], "", "  ", "")
+	w, results := bulkRequest(t, h, "?dry_run=true", lines)
+	if w.Code != http.StatusOK || len(results) != bulkMaxDryRunLines {
+		t.Errorf("unexpected response %d with %d results", w.Code, len(results))
+	}
+}
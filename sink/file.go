@@ -0,0 +1,61 @@
+package sink
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+
+	"bsky.watch/labeler/server"
+)
+
+// File appends each label change as a JSON object on a separate line.
+type File struct {
+	path string
+
+	mu sync.Mutex
+	f  *os.File
+}
+
+// NewFile opens the file at path for appending, creating it if needed.
+func NewFile(path string) (*File, error) {
+	if path == "" {
+		return nil, fmt.Errorf("missing path")
+	}
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("opening %q: %w", path, err)
+	}
+	return &File{path: path, f: f}, nil
+}
+
+func (s *File) Name() string {
+	return "file:" + s.path
+}
+
+func (s *File) Publish(ctx context.Context, entries []server.Entry) error {
+	buf := &bytes.Buffer{}
+	enc := json.NewEncoder(buf)
+	for _, e := range entries {
+		if err := enc.Encode(eventFromEntry(e)); err != nil {
+			return fmt.Errorf("serializing entry %d: %w", e.Seq, err)
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	// A single write, so that lines from concurrent calls don't get interleaved.
+	if _, err := s.f.Write(buf.Bytes()); err != nil {
+		return fmt.Errorf("writing to %q: %w", s.path, err)
+	}
+	return nil
+}
+
+// Close closes the underlying file.
+func (s *File) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.f.Close()
+}
@@ -0,0 +1,34 @@
+package sink
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var (
+	webhookDeliveries = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "labeler",
+		Subsystem: "sink",
+		Name:      "webhook_requests_total",
+		Help:      "Number of webhook delivery attempts.",
+	}, []string{"url", "status"})
+	webhookDeliveredEvents = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "labeler",
+		Subsystem: "sink",
+		Name:      "webhook_delivered_events_total",
+		Help:      "Number of events successfully delivered to a webhook.",
+	}, []string{"url"})
+	webhookLatency = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: "labeler",
+		Subsystem: "sink",
+		Name:      "webhook_request_duration_seconds",
+		Help:      "Latency of webhook requests.",
+		Buckets:   prometheus.ExponentialBucketsRange(0.01, 30, 15),
+	}, []string{"url"})
+	webhookOutboxSize = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "labeler",
+		Subsystem: "sink",
+		Name:      "webhook_outbox_size",
+		Help:      "Number of events waiting to be delivered.",
+	}, []string{"url"})
+)
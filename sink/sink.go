@@ -0,0 +1,44 @@
+// Package sink implements delivery of label changes to other services.
+//
+// Webhook sends batches of changes as signed HTTP POST requests, storing them
+// in a durable outbox until they are delivered. File appends changes to a file,
+// as newline-delimited JSON.
+//
+// Both produce [Event] objects, with the label in com.atproto.label.defs#label format
+// (without a signature).
+package sink
+
+import (
+	"context"
+	"fmt"
+
+	comatproto "github.com/bluesky-social/indigo/api/atproto"
+
+	"bsky.watch/labeler/config"
+	"bsky.watch/labeler/server"
+)
+
+// Event is a single label change.
+type Event struct {
+	Seq   int64                      `json:"seq"`
+	Label comatproto.LabelDefs_Label `json:"label"`
+	// Actor is who made the change, if known.
+	Actor string `json:"actor,omitempty"`
+}
+
+func eventFromEntry(e server.Entry) Event {
+	return Event{Seq: e.Seq, Label: e.ToLabel(), Actor: e.Actor}
+}
+
+// NewFromConfig creates a sink described by cfg. Background activity
+// of the sink stops when ctx is cancelled.
+func NewFromConfig(ctx context.Context, cfg config.Sink) (server.Sink, error) {
+	switch cfg.Type {
+	case "webhook":
+		return NewWebhook(ctx, cfg.URL, cfg.Secret, cfg.Outbox)
+	case "file":
+		return NewFile(cfg.Path)
+	default:
+		return nil, fmt.Errorf("unknown sink type %q", cfg.Type)
+	}
+}
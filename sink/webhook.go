@@ -0,0 +1,240 @@
+package sink
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/rs/zerolog"
+	bolt "go.etcd.io/bbolt"
+
+	"bsky.watch/labeler/server"
+)
+
+const (
+	// TimestampHeader contains the Unix time when the request was signed.
+	TimestampHeader = "X-Labeler-Timestamp"
+	// SignatureHeader contains "sha256=" followed by hex-encoded HMAC-SHA256
+	// of the timestamp, a dot, and the request body.
+	SignatureHeader = "X-Labeler-Signature"
+
+	webhookBatchSize  = 100
+	webhookTimeout    = 30 * time.Second
+	webhookMinBackoff = time.Second
+	webhookMaxBackoff = 5 * time.Minute
+)
+
+var outboxBucket = []byte("outbox")
+
+// Webhook sends label changes as POST requests with a JSON object in the body:
+//
+//	{"events": [{"seq": 123, "label": {...}}, ...]}
+//
+// Events are first stored in an outbox file, and removed from it only after
+// the endpoint responds with 2xx status. Failed requests are retried indefinitely,
+// with exponential backoff, and events are always delivered in the order they
+// were stored. The endpoint should use `seq` to deduplicate events, since
+// a request can be repeated if the response got lost.
+type Webhook struct {
+	url    string
+	secret []byte
+	db     *bolt.DB
+	client *http.Client
+	wake   chan struct{}
+}
+
+// NewWebhook creates a webhook sink and starts delivering events from the outbox
+// in the background, until ctx is cancelled.
+func NewWebhook(ctx context.Context, url string, secret string, outbox string) (*Webhook, error) {
+	if url == "" {
+		return nil, fmt.Errorf("missing url")
+	}
+	if secret == "" {
+		return nil, fmt.Errorf("missing secret")
+	}
+	if outbox == "" {
+		return nil, fmt.Errorf("missing outbox")
+	}
+	db, err := bolt.Open(outbox, 0600, &bolt.Options{Timeout: 5 * time.Second})
+	if err != nil {
+		return nil, fmt.Errorf("opening outbox %q: %w", outbox, err)
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(outboxBucket)
+		return err
+	})
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("initializing outbox %q: %w", outbox, err)
+	}
+
+	w := &Webhook{
+		url:    url,
+		secret: []byte(secret),
+		db:     db,
+		client: &http.Client{Timeout: webhookTimeout},
+		wake:   make(chan struct{}, 1),
+	}
+	w.updateOutboxSize()
+	go w.run(ctx)
+	return w, nil
+}
+
+func (w *Webhook) Name() string {
+	return "webhook:" + w.url
+}
+
+// Publish stores the entries in the outbox.
+func (w *Webhook) Publish(ctx context.Context, entries []server.Entry) error {
+	err := w.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(outboxBucket)
+		for _, e := range entries {
+			v, err := json.Marshal(eventFromEntry(e))
+			if err != nil {
+				return fmt.Errorf("serializing entry %d: %w", e.Seq, err)
+			}
+			if err := b.Put(seqKey(e.Seq), v); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("writing to outbox: %w", err)
+	}
+	w.updateOutboxSize()
+
+	select {
+	case w.wake <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+func seqKey(seq int64) []byte {
+	return binary.BigEndian.AppendUint64(nil, uint64(seq))
+}
+
+func (w *Webhook) updateOutboxSize() {
+	w.db.View(func(tx *bolt.Tx) error {
+		webhookOutboxSize.WithLabelValues(w.url).Set(float64(tx.Bucket(outboxBucket).Stats().KeyN))
+		return nil
+	})
+}
+
+func (w *Webhook) run(ctx context.Context) {
+	log := zerolog.Ctx(ctx).With().Str("sink", w.Name()).Logger()
+	defer w.db.Close()
+
+	backoff := time.Duration(0)
+	for {
+		n, err := w.deliverBatch(ctx)
+		if err != nil {
+			backoff = min(max(backoff*2, webhookMinBackoff), webhookMaxBackoff)
+			log.Warn().Err(err).Msgf("Webhook delivery failed, retrying in %s: %s", backoff, err)
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(backoff):
+			}
+			continue
+		}
+		backoff = 0
+		if n > 0 {
+			// There might be more events already.
+			continue
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-w.wake:
+		}
+	}
+}
+
+// deliverBatch sends the oldest events from the outbox, and returns the number of
+// events delivered.
+func (w *Webhook) deliverBatch(ctx context.Context) (int, error) {
+	var keys [][]byte
+	var events []json.RawMessage
+	err := w.db.View(func(tx *bolt.Tx) error {
+		c := tx.Bucket(outboxBucket).Cursor()
+		for k, v := c.First(); k != nil && len(keys) < webhookBatchSize; k, v = c.Next() {
+			keys = append(keys, bytes.Clone(k))
+			events = append(events, bytes.Clone(v))
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, fmt.Errorf("reading outbox: %w", err)
+	}
+	if len(events) == 0 {
+		return 0, nil
+	}
+
+	body, err := json.Marshal(map[string]any{"events": events})
+	if err != nil {
+		return 0, fmt.Errorf("serializing request: %w", err)
+	}
+	start := time.Now()
+	err = w.send(ctx, body)
+	webhookLatency.WithLabelValues(w.url).Observe(time.Since(start).Seconds())
+	if err != nil {
+		webhookDeliveries.WithLabelValues(w.url, "error").Inc()
+		return 0, err
+	}
+	webhookDeliveries.WithLabelValues(w.url, "ok").Inc()
+	webhookDeliveredEvents.WithLabelValues(w.url).Add(float64(len(events)))
+
+	err = w.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(outboxBucket)
+		for _, k := range keys {
+			if err := b.Delete(k); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, fmt.Errorf("removing delivered events from outbox: %w", err)
+	}
+	w.updateOutboxSize()
+	return len(events), nil
+}
+
+func (w *Webhook) send(ctx context.Context, body []byte) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, w.url, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("creating request: %w", err)
+	}
+	ts := strconv.FormatInt(time.Now().Unix(), 10)
+	mac := hmac.New(sha256.New, w.secret)
+	io.WriteString(mac, ts)
+	io.WriteString(mac, ".")
+	mac.Write(body)
+
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(TimestampHeader, ts)
+	req.Header.Set(SignatureHeader, "sha256="+hex.EncodeToString(mac.Sum(nil)))
+
+	resp, err := w.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("sending request: %w", err)
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64*1024))
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook responded with %s", resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,176 @@
+package sink
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	bolt "go.etcd.io/bbolt"
+
+	"bsky.watch/labeler/server"
+)
+
+const testSecret = "secret"
+
+// verifySignature checks the signature headers the way a webhook endpoint would.
+func verifySignature(r *http.Request, body []byte, secret string) bool {
+	ts := r.Header.Get(TimestampHeader)
+	unix, err := strconv.ParseInt(ts, 10, 64)
+	if err != nil || time.Since(time.Unix(unix, 0)).Abs() > time.Minute {
+		return false
+	}
+	mac := hmac.New(sha256.New, []byte(secret))
+	io.WriteString(mac, ts+".")
+	mac.Write(body)
+	want := "sha256=" + hex.EncodeToString(mac.Sum(nil))
+	return hmac.Equal([]byte(want), []byte(r.Header.Get(SignatureHeader)))
+}
+
+func TestWebhookSignature(t *testing.T) {
+	body := []byte(`{"events":[]}`)
+	tests := []struct {
+		name   string
+		secret string
+		modify func(r *http.Request)
+		want   bool
+	}{
+		{name: "valid", secret: testSecret, want: true},
+		{name: "wrong secret", secret: "other", want: false},
+		{name: "changed timestamp", secret: testSecret, want: false, modify: func(r *http.Request) {
+			ts, _ := strconv.ParseInt(r.Header.Get(TimestampHeader), 10, 64)
+			r.Header.Set(TimestampHeader, strconv.FormatInt(ts+1, 10))
+		}},
+		{name: "missing signature", secret: testSecret, want: false, modify: func(r *http.Request) {
+			r.Header.Del(SignatureHeader)
+		}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var got *http.Request
+			var gotBody []byte
+			endpoint := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				got = r
+				gotBody, _ = io.ReadAll(r.Body)
+			}))
+			defer endpoint.Close()
+
+			w := &Webhook{url: endpoint.URL, secret: []byte(testSecret), client: endpoint.Client()}
+			if err := w.send(context.Background(), body); err != nil {
+				t.Fatal(err)
+			}
+			if got.Header.Get("Content-Type") != "application/json" {
+				t.Errorf("unexpected Content-Type %q", got.Header.Get("Content-Type"))
+			}
+			if string(gotBody) != string(body) {
+				t.Errorf("unexpected body %q", gotBody)
+			}
+			if test.modify != nil {
+				test.modify(got)
+			}
+			if ok := verifySignature(got, gotBody, test.secret); ok != test.want {
+				t.Errorf("signature check returned %v, want %v", ok, test.want)
+			}
+		})
+	}
+}
+
+func TestWebhookOutbox(t *testing.T) {
+	var fail atomic.Bool
+	fail.Store(true)
+	attempts := make(chan struct{}, 100)
+	delivered := make(chan []Event, 100)
+	endpoint := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		if !verifySignature(r, body, testSecret) {
+			t.Errorf("invalid signature")
+		}
+		attempts <- struct{}{}
+		if fail.Load() {
+			http.Error(w, "unavailable", http.StatusServiceUnavailable)
+			return
+		}
+		var req struct {
+			Events []Event `json:"events"`
+		}
+		if err := json.Unmarshal(body, &req); err != nil {
+			t.Errorf("decoding request: %s", err)
+		}
+		delivered <- req.Events
+	}))
+	defer endpoint.Close()
+
+	outbox := filepath.Join(t.TempDir(), "outbox.db")
+	ctx, cancel := context.WithCancel(context.Background())
+	w, err := NewWebhook(ctx, endpoint.URL, testSecret, outbox)
+	if err != nil {
+		t.Fatal(err)
+	}
+	entries := []server.Entry{
+		{Seq: 1, Uri: "did:plc:a", Val: "x", Src: "did:plc:labeler", Actor: "alice"},
+		{Seq: 2, Uri: "did:plc:a", Val: "x", Src: "did:plc:labeler", Neg: true},
+	}
+	if err := w.Publish(ctx, entries); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-attempts:
+	case <-time.After(5 * time.Second):
+		t.Fatal("no delivery attempts")
+	}
+
+	// Events must survive a restart while the endpoint is unavailable.
+	cancel()
+	fail.Store(false)
+	ctx, cancel = context.WithCancel(context.Background())
+	defer cancel()
+	w, err = NewWebhook(ctx, endpoint.URL, testSecret, outbox)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got := []Event{}
+	for len(got) < len(entries) {
+		select {
+		case events := <-delivered:
+			got = append(got, events...)
+		case <-time.After(5 * time.Second):
+			t.Fatalf("events were not delivered, got %+v", got)
+		}
+	}
+	want := []Event{
+		{Seq: 1, Label: entries[0].ToLabel(), Actor: "alice"},
+		{Seq: 2, Label: entries[1].ToLabel()},
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("unexpected events (-want +got):\n%s", diff)
+	}
+
+	// Delivered events are removed from the outbox right after the response.
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		n := 0
+		w.db.View(func(tx *bolt.Tx) error {
+			n = tx.Bucket(outboxBucket).Stats().KeyN
+			return nil
+		})
+		if n == 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("outbox still has %d events", n)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}